@@ -0,0 +1,171 @@
+package wess
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+)
+
+// Principal is the authenticated identity of a request
+type Principal struct {
+	// Subject identifies the principal (user name, API key owner, JWT subject, etc)
+	Subject string `json:"sub"`
+
+	// Scopes are the permissions granted to the principal
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Method is the authentication method ("basic", "apikey", "jwt", etc)
+	Method string `json:"method"`
+
+	// Claims are the claims of the JWT, if any
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// HasScopes tells if the principal has all the given scopes
+func (principal Principal) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(principal.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authenticator authenticates requests
+type Authenticator interface {
+	// Authenticate gives the principal of the request
+	//
+	// If the request does not carry credentials for this Authenticator, it returns nil and no error.
+	// If the credentials are invalid, it returns an error.
+	Authenticate(r *http.Request) (*Principal, error)
+
+	// Challenge gives the value of the WWW-Authenticate header sent when the authentication fails
+	Challenge() string
+}
+
+// InvalidToken is returned when a bearer token is invalid
+var InvalidToken = errors.NewSentinel(http.StatusUnauthorized, "error.token.invalid", "Invalid Token: %s")
+
+// principalContextKey is the context key of the Principal
+type principalContextKey struct{}
+
+// GetPrincipal gives the Principal of the request
+//
+// returns nil if the request is not authenticated
+func GetPrincipal(r *http.Request) *Principal {
+	if r == nil {
+		return nil
+	}
+	if principal, ok := r.Context().Value(principalContextKey{}).(*Principal); ok {
+		return principal
+	}
+	return nil
+}
+
+// AuthenticationMiddleware authenticates the requests with the given authenticators
+//
+// The authenticators are tried in order, the first one that finds credentials wins.
+// The Principal is stored in the request context (See GetPrincipal).
+//
+// Requests without valid credentials get a 401 Unauthorized Problem
+// with a WWW-Authenticate header per authenticator.
+//
+// It can be used on subrouters with Use, or on routes (See WithAuthentication).
+func AuthenticationMiddleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("auth", "authenticate")
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if err != nil {
+					log.Warnf("Authentication failed for %s %s: %s", r.Method, r.URL.Path, err)
+					writeUnauthorized(w, r, authenticators, err)
+					return
+				}
+				if principal != nil {
+					log.Debugf("Authenticated %s with %s", principal.Subject, principal.Method)
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+					return
+				}
+			}
+			log.Debugf("No credentials for %s %s", r.Method, r.URL.Path)
+			writeUnauthorized(w, r, authenticators, nil)
+		})
+	}
+}
+
+// WithAuthentication authenticates the requests of a route (See AuthenticationMiddleware)
+func WithAuthentication(authenticators ...Authenticator) RouteOption {
+	return func(config *routeConfig) {
+		WithMiddleware(AuthenticationMiddleware(authenticators...))(config)
+		for _, authenticator := range authenticators {
+			if scheme, _, _ := strings.Cut(authenticator.Challenge(), " "); len(scheme) > 0 && !slices.Contains(config.metadata.Authentication, scheme) {
+				config.metadata.Authentication = append(config.metadata.Authentication, scheme)
+			}
+		}
+	}
+}
+
+// RequireScopesMiddleware requires the Principal of the requests to have all the given scopes
+//
+// It must run after an AuthenticationMiddleware.
+// Requests without a Principal get a 401 Unauthorized Problem,
+// requests without the scopes get a 403 Forbidden Problem.
+func RequireScopesMiddleware(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := GetPrincipal(r)
+			if principal == nil {
+				writeUnauthorized(w, r, nil, nil)
+				return
+			}
+			if !principal.HasScopes(scopes...) {
+				log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("auth", "scopes")
+				log.Warnf("%s does not have the scopes %s", principal.Subject, strings.Join(scopes, ", "))
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+				WriteProblem(w, r, NewProblem(http.StatusForbidden, "Insufficient scope").With("scopes", scopes))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScopes requires the Principal of the requests of a route to have all the given scopes (See RequireScopesMiddleware)
+func RequireScopes(scopes ...string) RouteOption {
+	return func(config *routeConfig) {
+		WithMiddleware(RequireScopesMiddleware(scopes...))(config)
+		config.metadata.AuthenticationRequired = true
+		config.metadata.Scopes = append(config.metadata.Scopes, scopes...)
+	}
+}
+
+// RateLimitByPrincipal rate limits requests by the subject of their Principal
+//
+// Requests without a Principal are rate limited by client IP address.
+func RateLimitByPrincipal() RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if principal := GetPrincipal(r); principal != nil {
+			return principal.Method + ":" + principal.Subject
+		}
+		return ClientIP(r)
+	}
+}
+
+// writeUnauthorized writes a 401 Unauthorized Problem with the challenges of the authenticators
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, authenticators []Authenticator, err error) {
+	for _, authenticator := range authenticators {
+		challenge := authenticator.Challenge()
+		if err != nil && errors.Is(err, InvalidToken) && strings.HasPrefix(challenge, "Bearer") {
+			challenge += `, error="invalid_token"`
+		}
+		if len(challenge) > 0 {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	WriteProblem(w, r, NewProblem(http.StatusUnauthorized, "Authentication required"))
+}
@@ -0,0 +1,83 @@
+package wess
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+
+	"github.com/gildas/go-errors"
+)
+
+// APIKeyAuthenticator authenticates requests with an API key sent in a header or a query parameter
+type APIKeyAuthenticator struct {
+	// Header is the name of the header that carries the API key.
+	// Default: "X-Api-Key"
+	Header string
+
+	// Query is the name of the query parameter that carries the API key.
+	// If empty, the query is not used.
+	Query string
+
+	// Lookup finds the principal of API keys that are not given to NewAPIKeyAuthenticator,
+	// like keys stored in a database. It returns nil if the key is unknown.
+	Lookup func(context context.Context, key string) (*Principal, error)
+
+	keys map[[sha256.Size]byte]Principal
+}
+
+// NewAPIKeyAuthenticator creates a new APIKeyAuthenticator with the given keys and their principal
+//
+// Only the hashes of the keys are kept.
+func NewAPIKeyAuthenticator(keys map[string]Principal) *APIKeyAuthenticator {
+	authenticator := &APIKeyAuthenticator{keys: make(map[[sha256.Size]byte]Principal, len(keys))}
+	for key, principal := range keys {
+		if len(principal.Subject) == 0 {
+			principal.Subject = "apikey"
+		}
+		principal.Method = "apikey"
+		authenticator.keys[sha256.Sum256([]byte(key))] = principal
+	}
+	return authenticator
+}
+
+// Authenticate gives the principal of the request
+//
+// implements Authenticator
+func (authenticator *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := authenticator.Header
+	if len(header) == 0 {
+		header = "X-Api-Key"
+	}
+	key := r.Header.Get(header)
+	if len(key) == 0 && len(authenticator.Query) > 0 {
+		key = r.URL.Query().Get(authenticator.Query)
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if principal, found := authenticator.keys[sha256.Sum256([]byte(key))]; found {
+		return &principal, nil
+	}
+	if authenticator.Lookup != nil {
+		principal, err := authenticator.Lookup(r.Context(), key)
+		if err != nil {
+			return nil, err
+		}
+		if principal != nil {
+			principal.Method = "apikey"
+			return principal, nil
+		}
+	}
+	return nil, errors.Unauthorized.WithStack()
+}
+
+// Challenge gives the value of the WWW-Authenticate header
+//
+// implements Authenticator
+func (authenticator *APIKeyAuthenticator) Challenge() string {
+	header := authenticator.Header
+	if len(header) == 0 {
+		header = "X-Api-Key"
+	}
+	return `APIKey header="` + header + `"`
+}
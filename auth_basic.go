@@ -0,0 +1,105 @@
+package wess
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gildas/go-errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuthenticator authenticates requests with HTTP Basic credentials
+//
+// The passwords are checked against an htpasswd file, bcrypt ($2y$, $2a$, $2b$) and {SHA} hashes are supported.
+type BasicAuthenticator struct {
+	// Realm is the realm sent in the WWW-Authenticate header.
+	// Default: "wess"
+	Realm string
+
+	// Scopes gives the scopes of the users, if any
+	Scopes map[string][]string
+
+	hashes map[string]string
+}
+
+// dummyBcryptHash is used to spend the same time checking unknown users
+var dummyBcryptHash = []byte("$2a$10$WujRncX.VSBw1nSnlZeDD.Rl/l///aANdhE4JR7iNwmsOH91Q9Lsm")
+
+// NewBasicAuthenticator creates a new BasicAuthenticator from the content of an htpasswd file
+func NewBasicAuthenticator(realm string, htpasswd io.Reader) (*BasicAuthenticator, error) {
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(htpasswd)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if len(entry) == 0 || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		username, hash, found := strings.Cut(entry, ":")
+		if !found || len(username) == 0 || len(hash) == 0 {
+			return nil, errors.ArgumentInvalid.With("htpasswd line", line)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, errors.Unsupported.With("htpasswd hash", username)
+		}
+		hashes[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &BasicAuthenticator{Realm: realm, hashes: hashes}, nil
+}
+
+// LoadBasicAuthenticator creates a new BasicAuthenticator from an htpasswd file
+func LoadBasicAuthenticator(realm, filename string) (*BasicAuthenticator, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewBasicAuthenticator(realm, file)
+}
+
+// Authenticate gives the principal of the request
+//
+// implements Authenticator
+func (authenticator *BasicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	hash, found := authenticator.hashes[username]
+	if !found {
+		_ = bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(password))
+		return nil, errors.Unauthorized.WithStack()
+	}
+	if !checkHTPasswd(hash, password) {
+		return nil, errors.Unauthorized.WithStack()
+	}
+	return &Principal{Subject: username, Method: "basic", Scopes: authenticator.Scopes[username]}, nil
+}
+
+// Challenge gives the value of the WWW-Authenticate header
+//
+// implements Authenticator
+func (authenticator *BasicAuthenticator) Challenge() string {
+	realm := authenticator.Realm
+	if len(realm) == 0 {
+		realm = "wess"
+	}
+	return `Basic realm="` + strings.ReplaceAll(realm, `"`, `'`) + `", charset="UTF-8"`
+}
+
+// checkHTPasswd checks a password against an htpasswd hash
+func checkHTPasswd(hash, password string) bool {
+	if encoded, found := strings.CutPrefix(hash, "{SHA}"); found {
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(encoded)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
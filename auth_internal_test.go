@@ -0,0 +1,283 @@
+package wess
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+// signTestJWT creates a signed JWT for the tests
+func signTestJWT(algorithm, keyID string, key any, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := jwtAlgorithms[algorithm]
+	digest := hash.New()
+	digest.Write([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(algorithm, "PS") {
+			signature, _ = rsa.SignPSS(rand.Reader, key, hash, digest.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, _ = rsa.SignPKCS1v15(rand.Reader, key, hash, digest.Sum(nil))
+		}
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testJWKS gives the JWKS of the given public keys
+func testJWKS(rsaKey *rsa.PublicKey, ecKey *ecdsa.PublicKey) []byte {
+	encode := func(value []byte) string { return base64.RawURLEncoding.EncodeToString(value) }
+	x, y := make([]byte, 32), make([]byte, 32)
+	ecBytes, _ := ecKey.Bytes()
+	copy(x, ecBytes[1:33])
+	copy(y, ecBytes[33:])
+	payload, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": encode(x), "y": encode(y)},
+		{"kty": "RSA", "kid": "enc1", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	return payload
+}
+
+func (suite *ServerSuite) TestCanAuthenticateWithBasic() {
+	authenticator, err := NewBasicAuthenticator("test", strings.NewReader(strings.Join([]string{
+		"# users",
+		"john:$2a$04$mLcweTAcX7qV6exv81Sw1OGoHOUaALTfw2PTxd24KoD.dcDeVRtkK",
+		"jane:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", // "secret"
+	}, "\n")))
+	suite.Require().NoError(err)
+	authenticator.Scopes = map[string][]string{"john": {"admin"}}
+
+	for _, credentials := range []struct {
+		username, password string
+		valid              bool
+	}{{"john", "secret", true}, {"jane", "secret", true}, {"john", "wrong", false}, {"unknown", "secret", false}} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(credentials.username, credentials.password)
+		principal, err := authenticator.Authenticate(req)
+		if credentials.valid {
+			suite.Require().NoError(err, "%s should be authenticated", credentials.username)
+			suite.Assert().Equal(credentials.username, principal.Subject)
+			suite.Assert().Equal("basic", principal.Method)
+		} else {
+			suite.Assert().ErrorIs(err, errors.Unauthorized, "%s should not be authenticated", credentials.username)
+		}
+	}
+
+	principal, err := authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	suite.Assert().NoError(err)
+	suite.Assert().Nil(principal, "No credentials should give no principal")
+
+	_, err = NewBasicAuthenticator("test", strings.NewReader("john:$apr1$abc$def"))
+	suite.Assert().ErrorIs(err, errors.Unsupported)
+}
+
+func (suite *ServerSuite) TestCanAuthenticateWithAPIKey() {
+	authenticator := NewAPIKeyAuthenticator(map[string]Principal{"key1": {Subject: "service1", Scopes: []string{"read"}}})
+	authenticator.Query = "api_key"
+	authenticator.Lookup = func(context context.Context, key string) (*Principal, error) {
+		if key == "dbkey" {
+			return &Principal{Subject: "service2"}, nil
+		}
+		return nil, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Api-Key", "key1")
+	principal, err := authenticator.Authenticate(req)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("service1", principal.Subject)
+	suite.Assert().Equal("apikey", principal.Method)
+	suite.Assert().True(principal.HasScopes("read"))
+
+	principal, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "/?api_key=dbkey", nil))
+	suite.Require().NoError(err)
+	suite.Assert().Equal("service2", principal.Subject)
+
+	_, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "/?api_key=unknown", nil))
+	suite.Assert().ErrorIs(err, errors.Unauthorized)
+}
+
+func (suite *ServerSuite) TestCanAuthenticateWithJWT() {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks, err := ParseJWKS(testJWKS(&rsaKey.PublicKey, &ecKey.PublicKey))
+	suite.Require().NoError(err)
+	suite.Assert().Equal(2, jwks.Len(), "Encryption keys should be ignored")
+
+	secret := []byte("my-secret")
+	authenticator := &JWTAuthenticator{Issuer: "https://issuer", Audience: "wess", Secret: secret, JWKS: jwks}
+	claims := func(changes map[string]any) map[string]any {
+		values := map[string]any{"sub": "john", "iss": "https://issuer", "aud": []string{"other", "wess"}, "exp": time.Now().Add(time.Hour).Unix(), "scope": "read write"}
+		for key, value := range changes {
+			if value == nil {
+				delete(values, key)
+			} else {
+				values[key] = value
+			}
+		}
+		return values
+	}
+	authenticate := func(token string) (*Principal, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return authenticator.Authenticate(req)
+	}
+
+	for _, token := range []string{
+		signTestJWT("HS256", "", secret, claims(nil)),
+		signTestJWT("HS512", "", secret, claims(nil)),
+		signTestJWT("RS256", "rsa1", rsaKey, claims(nil)),
+		signTestJWT("PS384", "rsa1", rsaKey, claims(nil)),
+		signTestJWT("ES256", "ec1", ecKey, claims(nil)),
+	} {
+		principal, err := authenticate(token)
+		suite.Require().NoError(err)
+		suite.Assert().Equal("john", principal.Subject)
+		suite.Assert().Equal([]string{"read", "write"}, principal.Scopes)
+		suite.Assert().Equal("https://issuer", principal.Claims["iss"])
+	}
+
+	for name, token := range map[string]string{
+		"expired":            signTestJWT("HS256", "", secret, claims(map[string]any{"exp": time.Now().Add(-time.Minute).Unix()})),
+		"no expiration":      signTestJWT("HS256", "", secret, claims(map[string]any{"exp": nil})),
+		"not valid yet":      signTestJWT("HS256", "", secret, claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"wrong issuer":       signTestJWT("HS256", "", secret, claims(map[string]any{"iss": "https://evil"})),
+		"wrong audience":     signTestJWT("HS256", "", secret, claims(map[string]any{"aud": "other"})),
+		"wrong secret":       signTestJWT("HS256", "", []byte("other"), claims(nil)),
+		"wrong key":          signTestJWT("ES256", "rsa1", ecKey, claims(nil)),
+		"none algorithm":     "eyJhbGciOiJub25lIn0." + strings.Split(signTestJWT("HS256", "", secret, claims(nil)), ".")[1] + ".",
+		"public key as HMAC": signTestJWT("HS256", "rsa1", rsaKey.PublicKey.N.Bytes(), claims(nil)),
+		"malformed":          "not.a-jwt",
+	} {
+		_, err := authenticate(token)
+		suite.Assert().ErrorIs(err, InvalidToken, "Token should be invalid: %s", name)
+	}
+}
+
+func (suite *ServerSuite) TestCanFetchJWKSFromURL() {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(testJWKS(&rsaKey.PublicKey, &ecKey.PublicKey))
+	}))
+	defer jwksServer.Close()
+
+	authenticator := &JWTAuthenticator{JWKS: NewJWKS(jwksServer.URL, time.Hour)}
+	token := signTestJWT("RS256", "rsa1", rsaKey, map[string]any{"sub": "john", "exp": time.Now().Add(time.Hour).Unix()})
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		principal, err := authenticator.Authenticate(req)
+		suite.Require().NoError(err)
+		suite.Assert().Equal("john", principal.Subject)
+	}
+	suite.Assert().Equal(int32(1), atomic.LoadInt32(&fetches), "The keys should be cached")
+
+	// Unknown key IDs trigger a refresh, at most once per refresh delay
+	token = signTestJWT("RS256", "rotated", rsaKey, map[string]any{"sub": "john", "exp": time.Now().Add(time.Hour).Unix()})
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := authenticator.Authenticate(req)
+		suite.Assert().ErrorIs(err, InvalidToken)
+	}
+	suite.Assert().Equal(int32(1), atomic.LoadInt32(&fetches), "Unknown keys should not refresh before the refresh delay")
+}
+
+func (suite *ServerSuite) TestCanLoadJWKSFromFile() {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	filename := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Require().NoError(os.WriteFile(filename, testJWKS(&rsaKey.PublicKey, &ecKey.PublicKey), 0600))
+	jwks, err := LoadJWKS(filename)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(2, jwks.Len())
+
+	_, err = LoadJWKS(filepath.Join(suite.T().TempDir(), "missing.json"))
+	suite.Assert().Error(err)
+}
+
+func (suite *ServerSuite) TestCanAuthenticateRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	secret := []byte("my-secret")
+	jwtAuthenticator := &JWTAuthenticator{Secret: secret, Realm: "api"}
+	apiKeyAuthenticator := NewAPIKeyAuthenticator(map[string]Principal{"key1": {Subject: "service1"}})
+	router := server.SubRouter("/api")
+	router.Use(AuthenticationMiddleware(jwtAuthenticator, apiKeyAuthenticator))
+	router.Methods(http.MethodGet).Path("/me").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(GetPrincipal(r).Subject))
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, WithAuthentication(jwtAuthenticator), RequireScopes("admin"))
+
+	send := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := send("/api/me", nil)
+	suite.Assert().Equal(http.StatusUnauthorized, res.Code)
+	suite.Assert().Equal([]string{`Bearer realm="api"`, `APIKey header="X-Api-Key"`}, res.Header().Values("WWW-Authenticate"))
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	res = send("/api/me", map[string]string{"X-Api-Key": "key1"})
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("service1", res.Body.String())
+
+	res = send("/api/me", map[string]string{"Authorization": "Bearer garbage"})
+	suite.Assert().Equal(http.StatusUnauthorized, res.Code)
+	suite.Assert().Contains(res.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	user := signTestJWT("HS256", "", secret, map[string]any{"sub": "john", "exp": time.Now().Add(time.Hour).Unix(), "scope": "read"})
+	admin := signTestJWT("HS256", "", secret, map[string]any{"sub": "jane", "exp": time.Now().Add(time.Hour).Unix(), "scp": []string{"admin"}})
+	res = send("/admin", map[string]string{"Authorization": "Bearer " + user})
+	suite.Assert().Equal(http.StatusForbidden, res.Code)
+	suite.Assert().Equal(`Bearer error="insufficient_scope", scope="admin"`, res.Header().Get("WWW-Authenticate"))
+	res = send("/admin", map[string]string{"Authorization": "Bearer " + admin})
+	suite.Assert().Equal(http.StatusOK, res.Code)
+}
+
+func (suite *ServerSuite) TestCanRateLimitByPrincipal() {
+	key := RateLimitByPrincipal()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	suite.Assert().Equal(ClientIP(req), key(req))
+	req = req.WithContext(context.WithValue(req.Context(), principalContextKey{}, &Principal{Subject: "john", Method: "jwt"}))
+	suite.Assert().Equal("jwt:john", key(req))
+}
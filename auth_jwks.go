@@ -0,0 +1,231 @@
+package wess
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+// JWKS is a JSON Web Key Set used to verify JWTs
+//
+// The keys are loaded from a file (See LoadJWKS) or fetched from a URL (See NewJWKS).
+// Fetched keys are cached for CacheDuration, and fetched again when a token carries an unknown key ID,
+// at most once per minute.
+type JWKS struct {
+	// URL is the URL of the key set, if any
+	URL string
+
+	// CacheDuration is the duration the fetched keys are kept.
+	// Default: 1 hour
+	CacheDuration time.Duration
+
+	// Client is the HTTP client used to fetch the keys.
+	// Default: a client with a 10 seconds timeout
+	Client *http.Client
+
+	mutex        sync.RWMutex
+	keys         []jsonWebKey
+	fetched      time.Time
+	lastAttempt  time.Time
+	refreshDelay time.Duration
+}
+
+// jsonWebKey is a parsed JSON Web Key
+type jsonWebKey struct {
+	ID        string
+	Algorithm string
+	Key       any // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+}
+
+// NewJWKS creates a new JWKS that fetches its keys from the given URL
+//
+// The keys are fetched on first use.
+func NewJWKS(url string, cacheDuration time.Duration) *JWKS {
+	return &JWKS{URL: url, CacheDuration: cacheDuration}
+}
+
+// LoadJWKS loads a JWKS from a file
+func LoadJWKS(filename string) (*JWKS, error) {
+	payload, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(payload)
+}
+
+// ParseJWKS parses a JWKS from its JSON representation
+//
+// Keys of unsupported types are ignored.
+func ParseJWKS(payload []byte) (*JWKS, error) {
+	keys, err := parseJSONWebKeys(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &JWKS{keys: keys, fetched: time.Now()}, nil
+}
+
+// Len gives the number of keys in the set
+func (jwks *JWKS) Len() int {
+	jwks.mutex.RLock()
+	defer jwks.mutex.RUnlock()
+	return len(jwks.keys)
+}
+
+// find gives the keys that can verify a token signed with the given key ID
+//
+// If the key ID is empty, all keys are given.
+func (jwks *JWKS) find(context context.Context, keyID string) ([]jsonWebKey, error) {
+	keys, stale := jwks.lookup(keyID)
+	if !stale || len(jwks.URL) == 0 {
+		return keys, nil
+	}
+	if err := jwks.refresh(context); err != nil {
+		if len(keys) > 0 {
+			return keys, nil // keep using the cached keys
+		}
+		return nil, err
+	}
+	keys, _ = jwks.lookup(keyID)
+	return keys, nil
+}
+
+// lookup gives the cached keys for the key ID and tells if they should be fetched again
+func (jwks *JWKS) lookup(keyID string) (keys []jsonWebKey, stale bool) {
+	jwks.mutex.RLock()
+	defer jwks.mutex.RUnlock()
+	for _, key := range jwks.keys {
+		if len(keyID) == 0 || key.ID == keyID {
+			keys = append(keys, key)
+		}
+	}
+	cacheDuration := jwks.CacheDuration
+	if cacheDuration <= 0 {
+		cacheDuration = time.Hour
+	}
+	refreshDelay := jwks.refreshDelay
+	if refreshDelay <= 0 {
+		refreshDelay = time.Minute
+	}
+	expired := time.Since(jwks.fetched) >= cacheDuration
+	unknown := len(keys) == 0 && time.Since(jwks.lastAttempt) >= refreshDelay
+	return keys, expired || unknown
+}
+
+// refresh fetches the keys from the URL
+func (jwks *JWKS) refresh(context context.Context) error {
+	jwks.mutex.Lock()
+	jwks.lastAttempt = time.Now()
+	jwks.mutex.Unlock()
+
+	client := jwks.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(context, http.MethodGet, jwks.URL, nil)
+	if err != nil {
+		return errors.InvalidURL.With(jwks.URL)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.FromHTTPStatusCode(res.StatusCode)
+	}
+	payload, err := io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+	if err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	keys, err := parseJSONWebKeys(payload)
+	if err != nil {
+		return err
+	}
+	jwks.mutex.Lock()
+	defer jwks.mutex.Unlock()
+	jwks.keys = keys
+	jwks.fetched = time.Now()
+	return nil
+}
+
+// parseJSONWebKeys parses the keys of a JWKS
+func parseJSONWebKeys(payload []byte) ([]jsonWebKey, error) {
+	var set struct {
+		Keys []struct {
+			Type      string `json:"kty"`
+			ID        string `json:"kid"`
+			Algorithm string `json:"alg"`
+			Use       string `json:"use"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+			Curve     string `json:"crv"`
+			X         string `json:"x"`
+			Y         string `json:"y"`
+			K         string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(payload, &set); err != nil {
+		return nil, errors.JSONUnmarshalError.Wrap(err)
+	}
+	keys := make([]jsonWebKey, 0, len(set.Keys))
+	for _, raw := range set.Keys {
+		if len(raw.Use) > 0 && raw.Use != "sig" {
+			continue
+		}
+		key := jsonWebKey{ID: raw.ID, Algorithm: raw.Algorithm}
+		switch raw.Type {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(raw.N)
+			e, errE := base64.RawURLEncoding.DecodeString(raw.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+				return nil, errors.ArgumentInvalid.With("jwk", raw.ID)
+			}
+			key.Key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch raw.Curve {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			size := (curve.Params().BitSize + 7) / 8
+			x, errX := base64.RawURLEncoding.DecodeString(raw.X)
+			y, errY := base64.RawURLEncoding.DecodeString(raw.Y)
+			if errX != nil || errY != nil || len(x) != size || len(y) != size {
+				return nil, errors.ArgumentInvalid.With("jwk", raw.ID)
+			}
+			publicKey, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+			if err != nil {
+				return nil, errors.ArgumentInvalid.With("jwk", raw.ID)
+			}
+			key.Key = publicKey
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(raw.K)
+			if err != nil || len(secret) == 0 {
+				return nil, errors.ArgumentInvalid.With("jwk", raw.ID)
+			}
+			key.Key = secret
+		default:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,261 @@
+package wess
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// JWTAuthenticator authenticates requests with a JWT bearer token
+//
+// HS256, HS384, HS512 tokens are verified with the Secret or the symmetric keys of the JWKS,
+// RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 tokens with the public keys of the JWKS.
+//
+// The token must have an expiration (exp), its issuer (iss) and audience (aud) are checked when configured.
+// The scopes of the Principal come from the "scope" (space separated) or "scp" claims.
+type JWTAuthenticator struct {
+	// Issuer is the expected issuer of the tokens, if empty the issuer is not checked
+	Issuer string
+
+	// Audience is the expected audience of the tokens, if empty the audience is not checked
+	Audience string
+
+	// Secret is the secret used to verify the HS* tokens
+	Secret []byte
+
+	// JWKS contains the keys used to verify the tokens
+	JWKS *JWKS
+
+	// Algorithms is the list of accepted algorithms.
+	// Default: all supported algorithms
+	Algorithms []string
+
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+
+	// Realm is the realm sent in the WWW-Authenticate header.
+	// Default: "wess"
+	Realm string
+
+	now func() time.Time
+}
+
+// jwtAlgorithms maps the supported algorithms to their hash
+var jwtAlgorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// Authenticate gives the principal of the request
+//
+// implements Authenticator
+func (authenticator *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+	claims, err := authenticator.Verify(r, strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	principal := &Principal{Method: "jwt", Claims: claims}
+	if subject, ok := claims["sub"].(string); ok && len(subject) > 0 {
+		principal.Subject = subject
+	} else if clientID, ok := claims["client_id"].(string); ok {
+		principal.Subject = clientID
+	}
+	if len(principal.Subject) == 0 {
+		return nil, InvalidToken.With("missing subject")
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	}
+	switch scp := claims["scp"].(type) {
+	case string:
+		principal.Scopes = append(principal.Scopes, strings.Fields(scp)...)
+	case []any:
+		for _, scope := range scp {
+			if scope, ok := scope.(string); ok {
+				principal.Scopes = append(principal.Scopes, scope)
+			}
+		}
+	}
+	return principal, nil
+}
+
+// Challenge gives the value of the WWW-Authenticate header
+//
+// implements Authenticator
+func (authenticator *JWTAuthenticator) Challenge() string {
+	realm := authenticator.Realm
+	if len(realm) == 0 {
+		realm = "wess"
+	}
+	return `Bearer realm="` + strings.ReplaceAll(realm, `"`, `'`) + `"`
+}
+
+// Verify verifies the signature and the claims of a token and gives its claims
+func (authenticator *JWTAuthenticator) Verify(r *http.Request, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, InvalidToken.With("malformed")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, InvalidToken.With("malformed header")
+	}
+	hash, supported := jwtAlgorithms[header.Algorithm]
+	if !supported || (len(authenticator.Algorithms) > 0 && !slices.Contains(authenticator.Algorithms, header.Algorithm)) {
+		return nil, InvalidToken.With("unsupported algorithm " + header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, InvalidToken.With("malformed signature")
+	}
+
+	keys := []jsonWebKey{}
+	if len(authenticator.Secret) > 0 {
+		keys = append(keys, jsonWebKey{Key: authenticator.Secret})
+	}
+	if authenticator.JWKS != nil {
+		found, err := authenticator.JWKS.find(r.Context(), header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if len(key.Algorithm) > 0 && key.Algorithm != header.Algorithm {
+			continue
+		}
+		if verifyJWTSignature(header.Algorithm, hash, key.Key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, InvalidToken.With("invalid signature")
+	}
+
+	claims := map[string]any{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, InvalidToken.With("malformed claims")
+	}
+	if err := authenticator.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims checks the registered claims
+func (authenticator *JWTAuthenticator) validateClaims(claims map[string]any) error {
+	now := time.Now()
+	if authenticator.now != nil {
+		now = authenticator.now()
+	}
+	leeway := authenticator.Leeway.Seconds()
+	timestamp := func(name string) (float64, bool) {
+		value, ok := claims[name].(float64)
+		return value, ok
+	}
+	expires, ok := timestamp("exp")
+	if !ok {
+		return InvalidToken.With("missing expiration")
+	}
+	if float64(now.Unix()) >= expires+leeway {
+		return InvalidToken.With("expired")
+	}
+	if notBefore, ok := timestamp("nbf"); ok && float64(now.Unix())+leeway < notBefore {
+		return InvalidToken.With("not valid yet")
+	}
+	if issuedAt, ok := timestamp("iat"); ok && float64(now.Unix())+leeway < issuedAt {
+		return InvalidToken.With("issued in the future")
+	}
+	if len(authenticator.Issuer) > 0 {
+		if issuer, _ := claims["iss"].(string); issuer != authenticator.Issuer {
+			return InvalidToken.With("invalid issuer")
+		}
+	}
+	if len(authenticator.Audience) > 0 {
+		valid := false
+		switch audience := claims["aud"].(type) {
+		case string:
+			valid = audience == authenticator.Audience
+		case []any:
+			valid = slices.Contains(audience, any(authenticator.Audience))
+		}
+		if !valid {
+			return InvalidToken.With("invalid audience")
+		}
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON part of a JWT
+func decodeJWTPart(part string, value any) error {
+	payload, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, value)
+}
+
+// verifyJWTSignature verifies the signature with the given key
+//
+// The key type must match the algorithm, so a public key can never be used as an HMAC secret.
+func verifyJWTSignature(algorithm string, hash crypto.Hash, key any, signed, signature []byte) bool {
+	switch algorithm[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS", "PS":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := hash.New()
+		digest.Write(signed)
+		if algorithm[:2] == "PS" {
+			return rsa.VerifyPSS(publicKey, hash, digest.Sum(nil), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(publicKey, hash, digest.Sum(nil), signature) == nil
+	case "ES":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		digest := hash.New()
+		digest.Write(signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(publicKey, digest.Sum(nil), r, s)
+	}
+	return false
+}
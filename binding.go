@@ -0,0 +1,289 @@
+package wess
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gorilla/mux"
+)
+
+// FieldError describes an invalid field of a request
+type FieldError struct {
+	// Field is the name of the field, as sent by the client (e.g. "address.city", "items[0].name")
+	Field string `json:"field"`
+
+	// In tells where the field comes from: "body", "path", "query" or "header"
+	In string `json:"in"`
+
+	// Message tells why the field is invalid
+	Message string `json:"message"`
+}
+
+// Bind binds the request to the given value, which must be a pointer
+//
+// The JSON body of the request is decoded first, if any.
+// Then, if the value is a struct, its fields are bound with their tags,
+// these fields are never decoded from the body:
+//
+//	type GetUsers struct {
+//	  Tenant string   `header:"X-Tenant"`
+//	  Group  string   `path:"group"`  // from mux.Vars
+//	  Page   int      `query:"page"`
+//	  Sort   []string `query:"sort"`  // repeated query parameters
+//	}
+//
+// Supported field types are strings, booleans, numbers, time.Time (RFC 3339), time.Duration,
+// types that implement encoding.TextUnmarshaler, and pointers and slices of them.
+//
+// returns a 400 Bad Request Problem with the invalid fields in its "errors" member
+// or a 415 Unsupported Media Type Problem if the body is not JSON.
+func Bind(r *http.Request, value any) error {
+	target := reflect.ValueOf(value)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return errors.ArgumentInvalid.With("value", reflect.TypeOf(value))
+	}
+	target = target.Elem()
+	for target.Kind() == reflect.Pointer {
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		target = target.Elem()
+	}
+	if target.Kind() != reflect.Struct {
+		return bindBody(r, value)
+	}
+	if err := bindStructBody(r, target); err != nil {
+		return err
+	}
+	fieldErrors := []FieldError{}
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	for _, field := range reflect.VisibleFields(target.Type()) {
+		if !field.IsExported() {
+			continue
+		}
+		for _, in := range []string{"path", "query", "header"} {
+			name, found := field.Tag.Lookup(in)
+			if !found || len(name) == 0 || name == "-" {
+				continue
+			}
+			var values []string
+			switch in {
+			case "path":
+				if value, found := vars[name]; found {
+					values = []string{value}
+				}
+			case "query":
+				values = query[name]
+			case "header":
+				values = r.Header.Values(name)
+			}
+			if len(values) == 0 {
+				continue
+			}
+			fieldValue, err := target.FieldByIndexErr(field.Index)
+			if err != nil || !fieldValue.CanSet() {
+				continue
+			}
+			if message := setFromStrings(fieldValue, values); len(message) > 0 {
+				fieldErrors = append(fieldErrors, FieldError{Field: name, In: in, Message: message})
+			}
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return NewProblem(http.StatusBadRequest, "The request is invalid").With("errors", fieldErrors)
+	}
+	return nil
+}
+
+// isBoundField tells if the field is bound from the path, the query or the headers instead of the body
+func isBoundField(field reflect.StructField) bool {
+	return len(field.Tag.Get("path")) > 0 || len(field.Tag.Get("query")) > 0 || len(field.Tag.Get("header")) > 0
+}
+
+// bindStructBody decodes the JSON body of the request into the struct, except its bound fields
+//
+// The body is decoded in a copy whose bound fields are zeroed, so clients cannot set them (e.g. {"Tenant": "..."}).
+func bindStructBody(r *http.Request, target reflect.Value) error {
+	bound := []reflect.StructField{}
+	for _, field := range reflect.VisibleFields(target.Type()) {
+		if field.IsExported() && isBoundField(field) {
+			bound = append(bound, field)
+		}
+	}
+	if len(bound) == 0 {
+		return bindBody(r, target.Addr().Interface())
+	}
+	decoded := reflect.New(target.Type())
+	decoded.Elem().Set(target)
+	saved := make([]reflect.Value, len(bound))
+	for index, field := range bound {
+		if fieldValue, err := decoded.Elem().FieldByIndexErr(field.Index); err == nil {
+			saved[index] = reflect.New(field.Type).Elem()
+			saved[index].Set(fieldValue)
+			fieldValue.SetZero()
+		}
+	}
+	if err := bindBody(r, decoded.Interface()); err != nil {
+		return err
+	}
+	target.Set(decoded.Elem())
+	for index, field := range bound {
+		if fieldValue, err := target.FieldByIndexErr(field.Index); err == nil {
+			if saved[index].IsValid() {
+				fieldValue.Set(saved[index])
+			} else {
+				fieldValue.SetZero()
+			}
+		}
+	}
+	return nil
+}
+
+// bindBody decodes the JSON body of the request into the value
+func bindBody(r *http.Request, value any) error {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil
+	}
+	if contentType := r.Header.Get("Content-Type"); len(contentType) > 0 {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			return NewProblem(http.StatusUnsupportedMediaType, "The request body must be JSON")
+		}
+	}
+	err := json.NewDecoder(r.Body).Decode(value)
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return err
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		field := typeError.Field
+		if len(field) == 0 {
+			field = "."
+		}
+		return NewProblem(http.StatusBadRequest, "The request is invalid").With("errors", []FieldError{{
+			Field:   field,
+			In:      "body",
+			Message: fmt.Sprintf("must be %s, not %s", jsonTypeName(typeError.Type), typeError.Value),
+		}})
+	}
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) {
+		return NewProblem(http.StatusBadRequest, fmt.Sprintf("The request body is not valid JSON at offset %d: %s", syntaxError.Offset, syntaxError))
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return NewProblem(http.StatusBadRequest, "The request body is not valid JSON: unexpected end of data")
+	}
+	return NewProblem(http.StatusBadRequest, "The request body is invalid: "+err.Error())
+}
+
+// jsonTypeName gives the JSON name of a Go type, for error messages
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// setFromStrings sets a value from the strings of a path variable, query parameter or header
+//
+// returns why the strings are invalid, or an empty string on success
+func setFromStrings(value reflect.Value, values []string) string {
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(value.Type(), len(values), len(values))
+		for index, raw := range values {
+			if message := setFromString(slice.Index(index), raw); len(message) > 0 {
+				return message
+			}
+		}
+		value.Set(slice)
+		return ""
+	}
+	return setFromString(value, values[0])
+}
+
+// setFromString sets a value from a string
+//
+// returns why the string is invalid, or an empty string on success
+func setFromString(value reflect.Value, raw string) string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return setFromString(value.Elem(), raw)
+	}
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := unmarshaler.UnmarshalText([]byte(raw)); err != nil {
+			return "is invalid"
+		}
+		return ""
+	}
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Sprintf("must be a duration, not %q", raw)
+		}
+		value.SetInt(int64(duration))
+		return ""
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Sprintf("must be a boolean, not %q", raw)
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Sprintf("must be an integer, not %q", raw)
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Sprintf("must be a positive integer, not %q", raw)
+		}
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return fmt.Sprintf("must be a number, not %q", raw)
+		}
+		value.SetFloat(parsed)
+	case reflect.Slice: // []byte
+		value.SetBytes([]byte(raw))
+	default:
+		return fmt.Sprintf("cannot be bound from %q", raw)
+	}
+	return ""
+}
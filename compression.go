@@ -0,0 +1,398 @@
+package wess
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gildas/go-errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionOptions defines the options for the compression middleware
+type CompressionOptions struct {
+	// Encodings is the list of encodings the server can use, by order of preference.
+	// Supported encodings are "zstd", "br" and "gzip".
+	// Default: zstd, br, gzip
+	Encodings []string
+
+	// MinSize is the minimum size of a response body to be compressed.
+	// Default: 1024 bytes
+	MinSize int
+
+	// ContentTypes is the list of media types that can be compressed.
+	// Entries ending with "/*" match any subtype (e.g. "text/*"),
+	// entries starting with "*+" match structured syntax suffixes (e.g. "*+json").
+	// Default: text/*, application/json, application/javascript, application/xml,
+	// application/wasm, image/svg+xml, *+json, *+xml
+	ContentTypes []string
+
+	// Disabled turns compression off.
+	// This is useful to disable compression on a subrouter when it is enabled on the server.
+	Disabled bool
+}
+
+const (
+	// DefaultCompressionMinSize is the default minimum size of a response body to be compressed
+	DefaultCompressionMinSize = 1024
+)
+
+// DefaultCompressionEncodings is the default list of encodings, by order of preference
+var DefaultCompressionEncodings = []string{"zstd", "br", "gzip"}
+
+// DefaultCompressibleContentTypes is the default list of media types that can be compressed
+var DefaultCompressibleContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+	"*+json",
+	"*+xml",
+}
+
+// incompressibleContentTypes are never compressed, even if they match the configured content types
+var incompressibleContentTypes = []string{
+	"text/event-stream",
+}
+
+// compressionContextKey is the key of the compression options in a request context
+type compressionContextKey struct{}
+
+// compressionSettings holds the compression options of a request
+//
+// Middlewares closer to the handler (subrouters, routes) can override the options.
+type compressionSettings struct {
+	options CompressionOptions
+}
+
+// CompressionMiddleware compresses the responses according to the Accept-Encoding header of the request
+//
+// Responses are compressed only if their media type is compressible and their size is at least MinSize.
+// The Vary header is updated, the ETag header is weakened and the Content-Length header is removed.
+//
+// Server-Sent Events, WebSocket upgrades, partial content and already encoded responses are never compressed.
+//
+// When used on a subrouter of a server that already compresses,
+// the options given here replace the server's options for the routes of that subrouter.
+func CompressionMiddleware(options CompressionOptions) func(http.Handler) http.Handler {
+	options = options.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if settings, ok := r.Context().Value(compressionContextKey{}).(*compressionSettings); ok {
+				settings.options = options
+				next.ServeHTTP(w, r)
+				return
+			}
+			if options.Disabled || isWebSocketUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			settings := &compressionSettings{options: options}
+			writer := &compressWriter{ResponseWriter: w, request: r, settings: settings, status: http.StatusOK}
+			defer writer.Close()
+			next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), compressionContextKey{}, settings)))
+		})
+	}
+}
+
+// withDefaults sets the default values of the options
+func (options CompressionOptions) withDefaults() CompressionOptions {
+	if options.Encodings == nil {
+		options.Encodings = DefaultCompressionEncodings
+	}
+	if options.MinSize <= 0 {
+		options.MinSize = DefaultCompressionMinSize
+	}
+	if options.ContentTypes == nil {
+		options.ContentTypes = DefaultCompressibleContentTypes
+	}
+	return options
+}
+
+// isCompressible tells if the given Content-Type can be compressed
+func (options CompressionOptions) isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if slices.Contains(incompressibleContentTypes, mediaType) {
+		return false
+	}
+	for _, candidate := range options.ContentTypes {
+		candidate = strings.ToLower(candidate)
+		if suffix, found := strings.CutPrefix(candidate, "*"); found && strings.HasPrefix(suffix, "+") {
+			if strings.HasSuffix(mediaType, suffix) {
+				return true
+			}
+		} else if mediaTypeMatches(candidate, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding finds the best encoding for the given Accept-Encoding header
+//
+// returns an empty string if the response should not be encoded
+func (options CompressionOptions) negotiateEncoding(acceptEncoding string) string {
+	accepted := parseAccept(acceptEncoding)
+	bestEncoding, bestQuality := "", 0.0
+	for _, encoding := range options.Encodings {
+		if _, supported := encoderPools[encoding]; !supported {
+			continue
+		}
+		quality := -1.0
+		for _, value := range accepted {
+			if value.Value == encoding {
+				quality = value.Quality
+				break
+			}
+			if value.Value == "*" && quality < 0 {
+				quality = value.Quality
+			}
+		}
+		if quality > bestQuality {
+			bestEncoding, bestQuality = encoding, quality
+		}
+	}
+	return bestEncoding
+}
+
+// isWebSocketUpgrade tells if the request is a WebSocket upgrade
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+// headerContainsToken tells if the comma separated values of a header contain the given token
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, candidate := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(candidate), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addVary adds the given header name to the Vary header, if not already present
+func addVary(header http.Header, name string) {
+	if headerContainsToken(header, "Vary", name) || headerContainsToken(header, "Vary", "*") {
+		return
+	}
+	header.Add("Vary", name)
+}
+
+// encoder is a compressing io.WriteCloser that can be reused
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// encoderPools contains the pools of encoders for each supported encoding
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+	"zstd": {New: func() any {
+		encoder, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return encoder
+	}},
+}
+
+// compressWriter is an http.ResponseWriter that compresses the response body
+//
+// The body is buffered until MinSize bytes are written, so small responses are not compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	request   *http.Request
+	settings  *compressionSettings
+	status    int
+	statusSet bool   // true when WriteHeader was called by the handler
+	decided   bool   // true when the compression decision was taken and the header sent
+	buffer    []byte // body bytes received before the decision
+	encoding  string
+	encoder   encoder
+	closed    bool
+}
+
+// WriteHeader records the status code, the header is sent once the compression decision is taken
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.statusSet {
+		return
+	}
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	w.statusSet = true
+	if !w.canCompress(false) {
+		w.decide(false)
+		return
+	}
+	if length, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+		w.decide(length >= w.settings.options.MinSize)
+	}
+}
+
+// Write compresses the data or buffers it until the compression decision can be taken
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.statusSet {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buffer = append(w.buffer, data...)
+	if len(w.buffer) >= w.settings.options.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush sends any buffered data to the client
+//
+// implements http.Flusher
+func (w *compressWriter) Flush() {
+	if !w.statusSet {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		// Streaming responses are compressed regardless of their size
+		_ = w.decide(true)
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection
+//
+// implements http.Hijacker
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, errors.NotImplemented.Wrap(err)
+	}
+	w.decided, w.closed = true, true
+	return conn, buffer, nil
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends the buffered data and terminates the compressed stream
+func (w *compressWriter) Close() {
+	if w.closed {
+		return
+	}
+	if !w.decided {
+		if !w.statusSet && len(w.buffer) == 0 {
+			// Nothing was written by the handler, net/http will send the default response
+			w.closed = true
+			return
+		}
+		_ = w.decide(len(w.buffer) >= w.settings.options.MinSize)
+	}
+	w.closed = true
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		encoderPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+// canCompress tells if the response could be compressed, given the request and the response headers
+//
+// If sniff is true and the response has no Content-Type yet, it is detected from the buffered data.
+func (w *compressWriter) canCompress(sniff bool) bool {
+	if w.settings.options.Disabled || w.request.Method == http.MethodHead {
+		return false
+	}
+	switch w.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent, http.StatusSwitchingProtocols:
+		return false
+	}
+	header := w.Header()
+	if len(header.Get("Content-Encoding")) > 0 || len(header.Get("Content-Range")) > 0 {
+		return false
+	}
+	if headerContainsToken(header, "Cache-Control", "no-transform") {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if len(contentType) == 0 {
+		if !sniff {
+			return true // we will know when the body is written
+		}
+		contentType = http.DetectContentType(w.buffer)
+		header.Set("Content-Type", contentType)
+	}
+	return w.settings.options.isCompressible(contentType)
+}
+
+// decide takes the compression decision, sends the header and the buffered data
+func (w *compressWriter) decide(compress bool) error {
+	if w.decided {
+		return nil
+	}
+	w.decided = true
+	header := w.Header()
+
+	if compress && w.canCompress(true) {
+		addVary(header, "Accept-Encoding")
+		if encoding := w.settings.options.negotiateEncoding(w.request.Header.Get("Accept-Encoding")); len(encoding) > 0 {
+			w.encoding = encoding
+			w.encoder = encoderPools[encoding].Get().(encoder)
+			w.encoder.Reset(w.ResponseWriter)
+			header.Set("Content-Encoding", encoding)
+			header.Del("Content-Length")
+			if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	} else if w.canCompress(true) {
+		// Too small to be compressed this time, but the representation still varies on Accept-Encoding
+		addVary(header, "Accept-Encoding")
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buffer)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer)
+	}
+	w.buffer = nil
+	return err
+}
@@ -0,0 +1,100 @@
+package wess
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func (suite *ServerSuite) newCompressionServer() *Server {
+	server := NewServer(ServerOptions{
+		Logger:      suite.Logger,
+		Compression: &CompressionOptions{},
+	})
+	payload := `{"data": "` + strings.Repeat("wess ", 1000) + `"}`
+	server.AddRouteWithFunc(http.MethodGet, "/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"1234"`)
+		_, _ = w.Write([]byte(payload))
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": "small"}`))
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte(payload))
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(payload))
+		w.(http.Flusher).Flush()
+	})
+	subrouter := server.SubRouter("/raw")
+	subrouter.Use(CompressionMiddleware(CompressionOptions{Disabled: true}))
+	subrouter.Methods(http.MethodGet).Path("/json").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(payload))
+	})
+	return server
+}
+
+func (suite *ServerSuite) TestCanCompressResponses() {
+	server := suite.newCompressionServer()
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for encoding, decoder := range decoders {
+		req := httptest.NewRequest(http.MethodGet, "/json", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		suite.Assert().Equal(http.StatusOK, res.Code)
+		suite.Assert().Equal(encoding, res.Header().Get("Content-Encoding"))
+		suite.Assert().Equal("Accept-Encoding", res.Header().Get("Vary"))
+		suite.Assert().Equal(`W/"1234"`, res.Header().Get("ETag"))
+		suite.Assert().Empty(res.Header().Get("Content-Length"))
+		reader, err := decoder(res.Body)
+		suite.Require().NoError(err, "Failed to create a %s decoder", encoding)
+		body, err := io.ReadAll(reader)
+		suite.Require().NoError(err, "Failed to decode the %s body", encoding)
+		suite.Assert().True(strings.HasPrefix(string(body), `{"data": "wess wess`), "Body should be decoded")
+	}
+}
+
+func (suite *ServerSuite) TestCanNegotiateCompressionEncoding() {
+	options := CompressionOptions{}.withDefaults()
+	suite.Assert().Equal("zstd", options.negotiateEncoding("gzip, br, zstd"))
+	suite.Assert().Equal("br", options.negotiateEncoding("gzip;q=0.5, br"))
+	suite.Assert().Equal("gzip", options.negotiateEncoding("*;q=0.1, zstd;q=0, br;q=0"))
+	suite.Assert().Equal("", options.negotiateEncoding("identity"))
+	suite.Assert().Equal("", options.negotiateEncoding(""))
+}
+
+func (suite *ServerSuite) TestShouldNotCompressSomeResponses() {
+	server := suite.newCompressionServer()
+	for _, path := range []string{"/small", "/image", "/events", "/raw/json"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		suite.Assert().Equal(http.StatusOK, res.Code, "Request to %s should succeed", path)
+		suite.Assert().Empty(res.Header().Get("Content-Encoding"), "Response from %s should not be compressed", path)
+		suite.Assert().NotEmpty(res.Body.String(), "Response from %s should have a body", path)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Empty(res.Header().Get("Content-Encoding"), "WebSocket upgrades should not be compressed")
+}
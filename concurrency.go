@@ -0,0 +1,370 @@
+package wess
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-logger"
+)
+
+// Priority is the priority of a route when the server is overloaded
+type Priority int
+
+const (
+	// PriorityLow routes are shed first
+	PriorityLow Priority = -10
+	// PriorityNormal is the default priority
+	PriorityNormal Priority = 0
+	// PriorityHigh routes are shed last
+	PriorityHigh Priority = 10
+	// PriorityCritical routes are never limited (like the health probes)
+	PriorityCritical Priority = 100
+)
+
+// String returns the string version of this Priority
+func (priority Priority) String() string {
+	switch priority {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return strconv.Itoa(int(priority))
+	}
+}
+
+// ConcurrencyAlgorithm is the algorithm used to compute the concurrency limit
+type ConcurrencyAlgorithm int
+
+const (
+	// StaticConcurrency uses MaxInFlight as the limit
+	StaticConcurrency ConcurrencyAlgorithm = iota
+	// AIMDConcurrency increases the limit additively while the latency stays under TargetLatency,
+	// and decreases it multiplicatively when it goes over
+	AIMDConcurrency
+	// GradientConcurrency adjusts the limit with the gradient between the long term latency and the current latency
+	GradientConcurrency
+)
+
+// String returns the string version of this ConcurrencyAlgorithm
+func (algorithm ConcurrencyAlgorithm) String() string {
+	switch algorithm {
+	case StaticConcurrency:
+		return "static"
+	case AIMDConcurrency:
+		return "aimd"
+	case GradientConcurrency:
+		return "gradient"
+	default:
+		return fmt.Sprintf("ConcurrencyAlgorithm(%d)", int(algorithm))
+	}
+}
+
+// ConcurrencyOptions defines the options of the concurrency limiter
+type ConcurrencyOptions struct {
+	// MaxInFlight is the maximum number of requests handled at the same time.
+	// With adaptive algorithms, it is also the initial limit.
+	MaxInFlight int
+
+	// MinInFlight is the minimum limit for the adaptive algorithms.
+	// Default: 1
+	MinInFlight int
+
+	// Algorithm is the algorithm used to compute the limit.
+	// Default: StaticConcurrency
+	Algorithm ConcurrencyAlgorithm
+
+	// TargetLatency is the latency above which the AIMD algorithm decreases the limit.
+	// Default: 1 second
+	TargetLatency time.Duration
+
+	// QueueSize is the maximum number of requests waiting for a slot.
+	// When the queue is full, the lowest priority request is shed.
+	// Default: 0 (requests are shed immediately)
+	QueueSize int
+
+	// QueueTimeout is the maximum time a request waits in the queue.
+	// Default: 1 second
+	QueueTimeout time.Duration
+
+	// RetryAfter is the value of the Retry-After header of shed requests.
+	// Default: 1 second
+	RetryAfter time.Duration
+
+	// DegradeReadiness, if true, makes the readiness probe fail while requests are shed.
+	DegradeReadiness bool
+}
+
+// ConcurrencyLimiter limits the number of requests handled at the same time
+//
+// Requests over the limit wait in a bounded priority queue,
+// and are shed with a 503 Service Unavailable when the queue is full or when they waited too long.
+type ConcurrencyLimiter struct {
+	options  ConcurrencyOptions
+	metrics  *Metrics
+	mutex    sync.Mutex
+	limit    float64
+	inflight int
+	queue    []*concurrencyWaiter
+	sequence uint64
+	longRTT  float64 // seconds, used by GradientConcurrency
+	lastShed time.Time
+}
+
+// concurrencyWaiter is a request waiting for a slot
+type concurrencyWaiter struct {
+	priority Priority
+	sequence uint64
+	ready    chan bool // receives true when a slot is granted, false when shed
+}
+
+// NewConcurrencyLimiter creates a new ConcurrencyLimiter
+//
+// The shed requests, the in-flight requests and the limit are reported to the given metrics, if any.
+func NewConcurrencyLimiter(options ConcurrencyOptions, metrics *Metrics) *ConcurrencyLimiter {
+	if options.MaxInFlight <= 0 {
+		options.MaxInFlight = 100
+	}
+	if options.MinInFlight <= 0 {
+		options.MinInFlight = 1
+	}
+	if options.MinInFlight > options.MaxInFlight {
+		options.MinInFlight = options.MaxInFlight
+	}
+	if options.TargetLatency <= 0 {
+		options.TargetLatency = time.Second
+	}
+	if options.QueueTimeout <= 0 {
+		options.QueueTimeout = time.Second
+	}
+	if options.RetryAfter <= 0 {
+		options.RetryAfter = time.Second
+	}
+	metrics.Describe("wess_requests_shed_total", CounterMetric, "Number of requests shed by the concurrency limiter")
+	metrics.Describe("wess_concurrency_inflight", GaugeMetric, "Number of requests being handled")
+	metrics.Describe("wess_concurrency_queued", GaugeMetric, "Number of requests waiting for a slot")
+	metrics.Describe("wess_concurrency_limit", GaugeMetric, "Current concurrency limit")
+	limiter := &ConcurrencyLimiter{
+		options: options,
+		metrics: metrics,
+		limit:   float64(options.MaxInFlight),
+	}
+	limiter.report()
+	return limiter
+}
+
+// Limit gives the current concurrency limit
+func (limiter *ConcurrencyLimiter) Limit() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return int(limiter.limit)
+}
+
+// InFlight gives the number of requests being handled
+func (limiter *ConcurrencyLimiter) InFlight() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.inflight
+}
+
+// IsShedding tells if requests were shed recently (within RetryAfter)
+func (limiter *ConcurrencyLimiter) IsShedding() bool {
+	if limiter == nil {
+		return false
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return !limiter.lastShed.IsZero() && time.Since(limiter.lastShed) < limiter.options.RetryAfter
+}
+
+// Middleware limits the concurrency of the requests
+//
+// The priority of a request is given by the priority func, if nil all requests have PriorityNormal.
+// Requests with PriorityCritical are never limited.
+func (limiter *ConcurrencyLimiter) Middleware(priority func(r *http.Request) Priority) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestPriority := PriorityNormal
+			if priority != nil {
+				requestPriority = priority(r)
+			}
+			if requestPriority >= PriorityCritical {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("concurrency", "concurrency")
+
+			release, reason := limiter.acquire(r, requestPriority)
+			if release == nil {
+				log.Warnf("Shedding request %s %s (priority: %s, reason: %s)", r.Method, r.URL.Path, requestPriority, reason)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(limiter.options.RetryAfter.Seconds())))))
+				WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, "The server is overloaded"))
+				return
+			}
+			start := time.Now()
+			defer func() { release(time.Since(start)) }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acquire waits for a slot
+//
+// returns a release func to call when the request is done, or nil and the reason if the request is shed
+func (limiter *ConcurrencyLimiter) acquire(r *http.Request, priority Priority) (release func(latency time.Duration), reason string) {
+	limiter.mutex.Lock()
+	if limiter.inflight < int(limiter.limit) && len(limiter.queue) == 0 {
+		limiter.inflight++
+		limiter.report()
+		limiter.mutex.Unlock()
+		return limiter.release, ""
+	}
+	if limiter.options.QueueSize <= 0 {
+		limiter.shed(priority, "limit")
+		limiter.mutex.Unlock()
+		return nil, "limit"
+	}
+	if len(limiter.queue) >= limiter.options.QueueSize {
+		lowest := limiter.lowestWaiter()
+		if lowest < 0 || limiter.queue[lowest].priority >= priority {
+			limiter.shed(priority, "queue_full")
+			limiter.mutex.Unlock()
+			return nil, "queue_full"
+		}
+		// Make room for the higher priority request
+		evicted := limiter.queue[lowest]
+		limiter.queue = append(limiter.queue[:lowest], limiter.queue[lowest+1:]...)
+		limiter.shed(evicted.priority, "evicted")
+		evicted.ready <- false
+	}
+	limiter.sequence++
+	waiter := &concurrencyWaiter{priority: priority, sequence: limiter.sequence, ready: make(chan bool, 1)}
+	limiter.queue = append(limiter.queue, waiter)
+	limiter.report()
+	limiter.mutex.Unlock()
+
+	timer := time.NewTimer(limiter.options.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case granted := <-waiter.ready:
+		if granted {
+			return limiter.release, ""
+		}
+		return nil, "evicted"
+	case <-timer.C:
+		reason = "timeout"
+	case <-r.Context().Done():
+		reason = "canceled"
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.removeWaiter(waiter) {
+		limiter.shed(priority, reason)
+		limiter.report()
+		return nil, reason
+	}
+	// The waiter was granted or evicted while we were giving up
+	if granted := <-waiter.ready; granted {
+		return limiter.release, ""
+	}
+	return nil, "evicted"
+}
+
+// release frees a slot and updates the limit with the latency of the request
+func (limiter *ConcurrencyLimiter) release(latency time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.inflight--
+	limiter.adjust(latency)
+	for limiter.inflight < int(limiter.limit) && len(limiter.queue) > 0 {
+		highest := limiter.highestWaiter()
+		waiter := limiter.queue[highest]
+		limiter.queue = append(limiter.queue[:highest], limiter.queue[highest+1:]...)
+		limiter.inflight++
+		waiter.ready <- true
+	}
+	limiter.report()
+}
+
+// adjust updates the limit with the latency of a request, the caller must hold the lock
+func (limiter *ConcurrencyLimiter) adjust(latency time.Duration) {
+	minimum, maximum := float64(limiter.options.MinInFlight), float64(limiter.options.MaxInFlight)
+	switch limiter.options.Algorithm {
+	case AIMDConcurrency:
+		if latency > limiter.options.TargetLatency {
+			limiter.limit = limiter.limit * 0.9
+		} else if float64(limiter.inflight+1)*2 >= limiter.limit {
+			limiter.limit += 1 / math.Max(1, limiter.limit)
+		}
+	case GradientConcurrency:
+		sample := math.Max(latency.Seconds(), 1e-6)
+		if limiter.longRTT == 0 {
+			limiter.longRTT = sample
+		} else {
+			limiter.longRTT = limiter.longRTT*0.95 + sample*0.05
+		}
+		gradient := math.Max(0.5, math.Min(1.0, limiter.longRTT/sample))
+		target := limiter.limit*gradient + math.Sqrt(limiter.limit)
+		limiter.limit = limiter.limit*0.8 + target*0.2
+	default:
+		return
+	}
+	limiter.limit = math.Max(minimum, math.Min(maximum, limiter.limit))
+}
+
+// lowestWaiter finds the index of the waiter with the lowest priority, the most recent first
+func (limiter *ConcurrencyLimiter) lowestWaiter() int {
+	lowest := -1
+	for index, waiter := range limiter.queue {
+		if lowest < 0 || waiter.priority < limiter.queue[lowest].priority || (waiter.priority == limiter.queue[lowest].priority && waiter.sequence > limiter.queue[lowest].sequence) {
+			lowest = index
+		}
+	}
+	return lowest
+}
+
+// highestWaiter finds the index of the waiter with the highest priority, the oldest first
+func (limiter *ConcurrencyLimiter) highestWaiter() int {
+	highest := -1
+	for index, waiter := range limiter.queue {
+		if highest < 0 || waiter.priority > limiter.queue[highest].priority || (waiter.priority == limiter.queue[highest].priority && waiter.sequence < limiter.queue[highest].sequence) {
+			highest = index
+		}
+	}
+	return highest
+}
+
+// removeWaiter removes the waiter from the queue, the caller must hold the lock
+//
+// returns false if the waiter was not in the queue anymore
+func (limiter *ConcurrencyLimiter) removeWaiter(waiter *concurrencyWaiter) bool {
+	for index, candidate := range limiter.queue {
+		if candidate == waiter {
+			limiter.queue = append(limiter.queue[:index], limiter.queue[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// shed records a shed request, the caller must hold the lock
+func (limiter *ConcurrencyLimiter) shed(priority Priority, reason string) {
+	limiter.lastShed = time.Now()
+	limiter.metrics.Inc("wess_requests_shed_total", "priority", priority.String(), "reason", reason)
+}
+
+// report reports the gauges to the metrics, the caller must hold the lock
+func (limiter *ConcurrencyLimiter) report() {
+	limiter.metrics.Set("wess_concurrency_inflight", float64(limiter.inflight))
+	limiter.metrics.Set("wess_concurrency_queued", float64(len(limiter.queue)))
+	limiter.metrics.Set("wess_concurrency_limit", math.Floor(limiter.limit))
+}
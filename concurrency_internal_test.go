@@ -0,0 +1,175 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func (suite *ServerSuite) TestCanShedRequestsOverTheConcurrencyLimit() {
+	metrics := NewMetrics()
+	limiter := NewConcurrencyLimiter(ConcurrencyOptions{MaxInFlight: 1, RetryAfter: 2 * time.Second}, metrics)
+	blocked := make(chan struct{})
+	entered := make(chan struct{})
+	handler := limiter.Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-blocked
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan int)
+	go func() {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- res.Code
+	}()
+	<-entered
+	suite.Assert().Equal(1, limiter.InFlight())
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	suite.Assert().Equal(http.StatusServiceUnavailable, res.Code)
+	suite.Assert().Equal("2", res.Header().Get("Retry-After"))
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+	suite.Assert().Equal(float64(1), metrics.Get("wess_requests_shed_total", "priority", "normal", "reason", "limit"))
+	suite.Assert().True(limiter.IsShedding(), "The limiter should be shedding")
+
+	close(blocked)
+	suite.Assert().Equal(http.StatusOK, <-done)
+	suite.Assert().Equal(0, limiter.InFlight())
+}
+
+func (suite *ServerSuite) TestCanQueueRequestsByPriority() {
+	metrics := NewMetrics()
+	limiter := NewConcurrencyLimiter(ConcurrencyOptions{MaxInFlight: 1, QueueSize: 1, QueueTimeout: 5 * time.Second}, metrics)
+	blocked := make(chan struct{})
+	entered := make(chan struct{}, 3)
+	handler := limiter.Middleware(func(r *http.Request) Priority {
+		if r.URL.Path == "/high" {
+			return PriorityHigh
+		}
+		return PriorityLow
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-blocked
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(path string, codes chan int) {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		codes <- res.Code
+	}
+
+	first, low, high := make(chan int, 1), make(chan int, 1), make(chan int, 1)
+	go send("/low", first)
+	<-entered
+	go send("/low", low)
+	suite.Require().Eventually(func() bool { return metrics.Get("wess_concurrency_queued") == 1 }, time.Second, 5*time.Millisecond)
+
+	// The queue is full, the high priority request evicts the low priority one
+	go send("/high", high)
+	suite.Assert().Equal(http.StatusServiceUnavailable, <-low)
+
+	close(blocked)
+	suite.Assert().Equal(http.StatusOK, <-first)
+	suite.Assert().Equal(http.StatusOK, <-high)
+}
+
+func (suite *ServerSuite) TestShouldShedQueuedRequestsAfterTimeout() {
+	limiter := NewConcurrencyLimiter(ConcurrencyOptions{MaxInFlight: 1, QueueSize: 10, QueueTimeout: 50 * time.Millisecond}, nil)
+	release, _ := limiter.acquire(httptest.NewRequest(http.MethodGet, "/", nil), PriorityNormal)
+	suite.Require().NotNil(release)
+
+	_, reason := limiter.acquire(httptest.NewRequest(http.MethodGet, "/", nil), PriorityNormal)
+	suite.Assert().Equal("timeout", reason)
+	release(time.Millisecond)
+	suite.Assert().Equal(0, limiter.InFlight())
+}
+
+func (suite *ServerSuite) TestCanAdaptConcurrencyLimitWithAIMD() {
+	limiter := NewConcurrencyLimiter(ConcurrencyOptions{MaxInFlight: 100, MinInFlight: 5, Algorithm: AIMDConcurrency, TargetLatency: 100 * time.Millisecond}, nil)
+	limiter.limit = 50
+	for i := 0; i < 10; i++ {
+		release, _ := limiter.acquire(httptest.NewRequest(http.MethodGet, "/", nil), PriorityNormal)
+		release(time.Second)
+	}
+	suite.Assert().Less(limiter.Limit(), 50)
+
+	for i := 0; i < 1000; i++ {
+		release, _ := limiter.acquire(httptest.NewRequest(http.MethodGet, "/", nil), PriorityNormal)
+		release(time.Second)
+	}
+	suite.Assert().Equal(5, limiter.Limit(), "The limit should not go below MinInFlight")
+}
+
+func (suite *ServerSuite) TestCanAdaptConcurrencyLimitWithGradient() {
+	limiter := NewConcurrencyLimiter(ConcurrencyOptions{MaxInFlight: 100, Algorithm: GradientConcurrency}, nil)
+	limiter.limit = 20
+	for i := 0; i < 50; i++ {
+		limiter.mutex.Lock()
+		limiter.adjust(10 * time.Millisecond)
+		limiter.mutex.Unlock()
+	}
+	steady := limiter.Limit()
+	suite.Assert().Greater(steady, 20, "The limit should grow while the latency is stable")
+
+	for i := 0; i < 10; i++ {
+		limiter.mutex.Lock()
+		limiter.adjust(100 * time.Millisecond)
+		limiter.mutex.Unlock()
+	}
+	suite.Assert().Less(limiter.Limit(), steady, "The limit should shrink when the latency grows")
+}
+
+func (suite *ServerSuite) TestShouldNotLimitCriticalRoutes() {
+	server := NewServer(ServerOptions{
+		Logger:           suite.Logger,
+		ProbePort:        8000,
+		ConcurrencyLimit: &ConcurrencyOptions{MaxInFlight: 1, DegradeReadiness: true},
+	})
+	atomic.StoreInt32(&server.healthStatus, 1)
+	server.healthRoutes(server.proberouter)
+
+	var wg sync.WaitGroup
+	blocked := make(chan struct{})
+	entered := make(chan struct{})
+	server.AddRouteWithFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-blocked
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/important", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, WithPriority(PriorityCritical))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.webserver.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-entered
+
+	send := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		return res
+	}
+	suite.Assert().Equal(http.StatusServiceUnavailable, send("/slow").Code)
+	suite.Assert().Equal(http.StatusOK, send("/important").Code)
+	probe := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.probeserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		return res
+	}
+	suite.Assert().Equal(http.StatusServiceUnavailable, probe("/healthz/readiness").Code, "Readiness should be degraded while shedding")
+	suite.Assert().Equal(http.StatusOK, probe("/healthz/liveness").Code)
+
+	res := probe("/healthz/metrics")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Contains(res.Body.String(), `wess_requests_shed_total{priority="normal",reason="limit"} 1`)
+	suite.Assert().Contains(res.Body.String(), "wess_concurrency_inflight 1")
+
+	close(blocked)
+	wg.Wait()
+}
@@ -0,0 +1,143 @@
+package wess
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+// cookieCodec encrypts and authenticates cookie values with AES-GCM
+//
+// The first key encrypts, all keys decrypt, so keys can be rotated by adding a new key in front.
+// The cookie name is authenticated with the value, so a value cannot be moved to another cookie.
+type cookieCodec struct {
+	aeads []cipher.AEAD
+}
+
+// cookiePayload is the encrypted content of a cookie
+type cookiePayload struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e,omitempty"`
+}
+
+// newCookieCodec creates a cookieCodec with the given AES keys (16, 24 or 32 bytes)
+//
+// If no key is given, a random key is generated and the cookies will not survive a restart.
+func newCookieCodec(keys ...[]byte) (*cookieCodec, error) {
+	if len(keys) == 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, errors.RuntimeError.Wrap(err)
+		}
+		keys = [][]byte{key}
+	}
+	codec := &cookieCodec{}
+	for index, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.ArgumentInvalid.With("key", index)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.RuntimeError.Wrap(err)
+		}
+		codec.aeads = append(codec.aeads, aead)
+	}
+	return codec, nil
+}
+
+// encode encrypts the value of a cookie
+//
+// If expires is not zero, the cookie cannot be decoded after that time.
+func (codec *cookieCodec) encode(name string, value any, expires time.Time) (string, error) {
+	payload := cookiePayload{}
+	var err error
+	if payload.Value, err = json.Marshal(value); err != nil {
+		return "", errors.JSONMarshalError.Wrap(err)
+	}
+	if !expires.IsZero() {
+		payload.Expires = expires.Unix()
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", errors.JSONMarshalError.Wrap(err)
+	}
+	aead := codec.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.RuntimeError.Wrap(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(name))), nil
+}
+
+// decode decrypts the value of a cookie
+func (codec *cookieCodec) decode(name, encoded string, value any) error {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.ArgumentInvalid.With("cookie", name)
+	}
+	for _, aead := range codec.aeads {
+		if len(ciphertext) < aead.NonceSize() {
+			continue
+		}
+		plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], []byte(name))
+		if err != nil {
+			continue
+		}
+		var payload cookiePayload
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			return errors.JSONUnmarshalError.Wrap(err)
+		}
+		if payload.Expires > 0 && time.Now().Unix() >= payload.Expires {
+			return errors.ArgumentInvalid.With("cookie", name)
+		}
+		if err := json.Unmarshal(payload.Value, value); err != nil {
+			return errors.JSONUnmarshalError.Wrap(err)
+		}
+		return nil
+	}
+	return errors.ArgumentInvalid.With("cookie", name)
+}
+
+// setCookie encodes the value and sets the cookie on the response
+//
+// The cookie is HttpOnly and Secure when the client uses HTTPS.
+// Values larger than what browsers accept are refused.
+func (codec *cookieCodec) setCookie(w http.ResponseWriter, r *http.Request, cookie http.Cookie, value any, expires time.Time) error {
+	encoded, err := codec.encode(cookie.Name, value, expires)
+	if err != nil {
+		return err
+	}
+	if len(encoded) > maxCookieSessionSize {
+		return errors.ArgumentInvalid.With("cookie size", len(encoded))
+	}
+	cookie.Value = encoded
+	cookie.HttpOnly = true
+	cookie.Secure = GetClientInfo(r).Scheme == "https"
+	if !expires.IsZero() {
+		cookie.Expires = expires
+		cookie.MaxAge = int(time.Until(expires).Seconds())
+	}
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// readCookie reads and decodes the cookie of the request
+func (codec *cookieCodec) readCookie(r *http.Request, name string, value any) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return errors.NotFound.With("cookie", name)
+	}
+	return codec.decode(name, cookie.Value, value)
+}
+
+// clearCookie removes the cookie from the client
+func clearCookie(w http.ResponseWriter, name, path string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Path: path, MaxAge: -1, HttpOnly: true})
+}
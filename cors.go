@@ -1,6 +1,17 @@
 package wess
 
-import "github.com/gildas/go-logger"
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gildas/go-logger"
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+)
 
 // CORS Logger
 type CorsLogger struct {
@@ -10,3 +21,287 @@ type CorsLogger struct {
 func (corsLogger CorsLogger) Printf(format string, args ...interface{}) {
 	corsLogger.Logger.Debugf(format, args...)
 }
+
+// CORSOptions defines a CORS policy
+//
+// Empty fields get the value of the CORS fields of the ServerOptions.
+// The allowed origins are inherited only if both AllowedOrigins and AllowOriginFunc are empty.
+// Boolean fields are not inherited.
+type CORSOptions struct {
+	// AllowedOrigins is the list of allowed origins, "*" allows all origins
+	AllowedOrigins []string
+
+	// AllowOriginFunc is a custom function to validate the origin
+	AllowOriginFunc func(origin string) bool
+
+	// AllowedMethods is the list of allowed methods
+	AllowedMethods []string
+
+	// AllowedHeaders is the list of allowed headers
+	AllowedHeaders []string
+
+	// ExposedHeaders is the list of headers that are safe to expose to
+	// the API of a CORS API specification
+	ExposedHeaders []string
+
+	// MaxAge indicates how long the results of a preflight request can be cached
+	MaxAge time.Duration
+
+	// AllowCredentials indicates whether the request can include user credentials
+	// like cookies, HTTP authentication or client side SSL certificates
+	AllowCredentials bool
+
+	// AllowPrivateNetwork indicates whether to accept requests over a private network
+	AllowPrivateNetwork bool
+
+	// OptionsPassthrough instructs preflight to let other potential next handlers to
+	// process the OPTIONS method. Turn this on if your application handles OPTIONS.
+	OptionsPassthrough bool
+
+	// OptionsSuccessStatus provides a status code to use for
+	// successful OPTIONS requests, instead of http.StatusNoContent (204)
+	OptionsSuccessStatus int
+}
+
+// CORSPolicy is a CORS policy that can be attached to subrouters and routes
+// (See Server.SubRouter, Server.SetCORS and WithCORS)
+//
+// The policy can be replaced at runtime with Update, it is safe for concurrent use.
+type CORSPolicy struct {
+	mutex    sync.Mutex
+	options  CORSOptions
+	defaults *CORSOptions
+	logger   *logger.Logger
+	cors     atomic.Pointer[cors.Cors]
+}
+
+// NewCORSPolicy creates a new CORSPolicy
+func NewCORSPolicy(options CORSOptions) *CORSPolicy {
+	policy := &CORSPolicy{options: options}
+	policy.build()
+	return policy
+}
+
+// Update replaces the options of the policy
+//
+// The requests that are already handled keep the previous options.
+func (policy *CORSPolicy) Update(options CORSOptions) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	policy.options = options
+	policy.build()
+}
+
+// Options gives the options of the policy, as given to NewCORSPolicy or Update
+func (policy *CORSPolicy) Options() CORSOptions {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	return policy.options
+}
+
+// allowsOrigin tells if the policy allows the given origin
+func (policy *CORSPolicy) allowsOrigin(origin string) bool {
+	policy.mutex.Lock()
+	options := policy.options
+	if policy.defaults != nil {
+		options = options.withDefaults(*policy.defaults)
+	}
+	policy.mutex.Unlock()
+	for _, allowed := range options.AllowedOrigins {
+		if allowed == "*" || matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return options.AllowOriginFunc != nil && options.AllowOriginFunc(origin)
+}
+
+// WithCORS applies a CORS policy to a route instead of the server's policy
+func WithCORS(policy *CORSPolicy) RouteOption {
+	return func(config *routeConfig) {
+		config.cors = policy
+	}
+}
+
+// bind gives the default options and the logger of a server to the policy
+//
+// A policy is bound to the first server it is attached to.
+func (policy *CORSPolicy) bind(defaults *CORSOptions, log *logger.Logger) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	if policy.defaults != nil {
+		return
+	}
+	policy.defaults = defaults
+	policy.logger = log
+	policy.build()
+}
+
+// build builds the rs/cors handler of the policy
+//
+// must be called with the mutex locked (or before the policy is shared)
+func (policy *CORSPolicy) build() {
+	options := policy.options
+	if policy.defaults != nil {
+		options = options.withDefaults(*policy.defaults)
+	}
+	handler := cors.New(cors.Options{
+		AllowedOrigins:       options.AllowedOrigins,
+		AllowOriginFunc:      options.AllowOriginFunc,
+		AllowedMethods:       options.AllowedMethods,
+		AllowedHeaders:       options.AllowedHeaders,
+		ExposedHeaders:       options.ExposedHeaders,
+		MaxAge:               int(options.MaxAge.Seconds()),
+		AllowCredentials:     options.AllowCredentials,
+		AllowPrivateNetwork:  options.AllowPrivateNetwork,
+		OptionsPassthrough:   options.OptionsPassthrough,
+		OptionsSuccessStatus: options.OptionsSuccessStatus,
+		Debug:                policy.logger != nil && policy.logger.ShouldWrite(logger.DEBUG, "cors", "cors"),
+	})
+	if policy.logger != nil {
+		handler.Log = CorsLogger{policy.logger.Child("cors", "cors")}
+	}
+	policy.cors.Store(handler)
+}
+
+// withDefaults gives the options with the empty fields set from the defaults
+func (options CORSOptions) withDefaults(defaults CORSOptions) CORSOptions {
+	if len(options.AllowedOrigins) == 0 && options.AllowOriginFunc == nil {
+		options.AllowedOrigins = defaults.AllowedOrigins
+		options.AllowOriginFunc = defaults.AllowOriginFunc
+	}
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = defaults.AllowedMethods
+	}
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = defaults.AllowedHeaders
+	}
+	if len(options.ExposedHeaders) == 0 {
+		options.ExposedHeaders = defaults.ExposedHeaders
+	}
+	if options.MaxAge == 0 {
+		options.MaxAge = defaults.MaxAge
+	}
+	if options.OptionsSuccessStatus == 0 {
+		options.OptionsSuccessStatus = defaults.OptionsSuccessStatus
+	}
+	return options
+}
+
+// corsOptionsFromServerOptions gives the CORS options of the server
+func corsOptionsFromServerOptions(options ServerOptions) CORSOptions {
+	return CORSOptions{
+		AllowedOrigins:       options.AllowedCORSOrigins,
+		AllowOriginFunc:      options.AllowOriginFunc,
+		AllowedMethods:       options.AllowedCORSMethods,
+		AllowedHeaders:       options.AllowedCORSHeaders,
+		ExposedHeaders:       options.ExposedCORSHeaders,
+		MaxAge:               options.CORSMaxAge,
+		AllowCredentials:     options.CORSAllowCredentials,
+		AllowPrivateNetwork:  options.CORSAllowPrivateNetwork,
+		OptionsPassthrough:   options.CORSOptionsPasthrough,
+		OptionsSuccessStatus: options.CORSOptionsSuccessStatus,
+	}
+}
+
+// corsRouter selects the CORS policy of the requests
+//
+// The policy of the matched route wins over the policies of the matching hosts,
+// which win over the policy of the longest matching prefix, which wins over the server's policy.
+type corsRouter struct {
+	router     *mux.Router
+	routes     *routeRegistry
+	versions   *versionRegistry
+	defaults   *CORSOptions
+	logger     *logger.Logger
+	global     *CORSPolicy
+	routeCount atomic.Int32
+	mutex      sync.RWMutex
+	prefixes   []corsPrefix
+}
+
+// corsPrefix is a CORS policy attached to a path prefix, optionally scoped to a host
+type corsPrefix struct {
+	host    string
+	matcher *mux.Route
+	prefix  string
+	policy  *CORSPolicy
+}
+
+// setPrefix attaches a policy to a path prefix of a host, replacing the existing one
+//
+// If the host is empty, the prefix applies to all the hosts.
+// If the policy is nil, the prefix is detached.
+func (router *corsRouter) setPrefix(host, prefix string, policy *CORSPolicy) {
+	if policy != nil {
+		policy.bind(router.defaults, router.logger)
+	}
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	prefixes := make([]corsPrefix, 0, len(router.prefixes)+1)
+	for _, existing := range router.prefixes {
+		if existing.host != host || existing.prefix != prefix {
+			prefixes = append(prefixes, existing)
+		}
+	}
+	if policy != nil {
+		entry := corsPrefix{host: host, prefix: prefix, policy: policy}
+		if len(host) > 0 {
+			entry.matcher = hostMatcher(host)
+		}
+		prefixes = append(prefixes, entry)
+	}
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		if (len(prefixes[i].host) > 0) != (len(prefixes[j].host) > 0) {
+			return len(prefixes[i].host) > 0
+		}
+		return len(prefixes[i].prefix) > len(prefixes[j].prefix)
+	})
+	router.prefixes = prefixes
+}
+
+// addRoute registers the policy of a route
+func (router *corsRouter) addRoute(policy *CORSPolicy) {
+	policy.bind(router.defaults, router.logger)
+	router.routeCount.Add(1)
+}
+
+// policyFor gives the CORS policy of the request
+//
+// returns nil if CORS is not enabled for the request
+func (router *corsRouter) policyFor(r *http.Request) *CORSPolicy {
+	if router.routeCount.Load() > 0 {
+		// Match the route as the versions middleware will route the request
+		req := router.versions.route(r)
+		if method := r.Header.Get("Access-Control-Request-Method"); r.Method == http.MethodOptions && len(method) > 0 {
+			// Match the route of the preflighted request
+			req = req.Clone(req.Context())
+			req.Method = method
+		}
+		var match mux.RouteMatch
+		if router.router.Match(req, &match) {
+			if config := router.routes.get(match.Route); config != nil && config.cors != nil {
+				return config.cors
+			}
+		}
+	}
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	for _, prefix := range router.prefixes {
+		if strings.HasPrefix(r.URL.Path, prefix.prefix) && (prefix.matcher == nil || matchHost(prefix.matcher, r.Host)) {
+			return prefix.policy
+		}
+	}
+	return router.global
+}
+
+// Handler applies the CORS policy of the requests
+func (router *corsRouter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := router.policyFor(r)
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		policy.cors.Load().ServeHTTP(w, r, next.ServeHTTP)
+	})
+}
@@ -0,0 +1,106 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// sendCORS sends a request with an Origin header, as a preflight if requestMethod is not empty
+func sendCORS(server *Server, method, path, origin, requestMethod string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	if len(requestMethod) > 0 {
+		req.Header.Set("Access-Control-Request-Method", requestMethod)
+	}
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	return res
+}
+
+func (suite *ServerSuite) TestCanUseCORSPoliciesPerSubRouterAndRoute() {
+	server := NewServer(ServerOptions{
+		Logger:             suite.Logger,
+		AllowedCORSOrigins: []string{"https://www.acme.com"},
+		AllowedCORSMethods: []string{http.MethodGet, http.MethodPost},
+	})
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	public := server.SubRouter("/api/public", WithCORS(NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"*"}})))
+	public.HandleFunc("/items", handler).Methods(http.MethodGet)
+	admin := server.SubRouter("/api/admin", WithCORS(NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"https://admin.acme.com"}})))
+	admin.HandleFunc("/users", handler).Methods(http.MethodGet, http.MethodPost)
+	server.AddRouteWithFunc(http.MethodDelete, "/api/admin/cache", handler, WithCORS(NewCORSPolicy(CORSOptions{
+		AllowedOrigins: []string{"https://ops.acme.com"},
+		AllowedMethods: []string{http.MethodDelete},
+	})))
+	server.AddRouteWithFunc(http.MethodGet, "/api/other", handler)
+
+	res := sendCORS(server, http.MethodGet, "/api/public/items", "https://anyone.com", "")
+	suite.Assert().Equal("*", res.Header().Get("Access-Control-Allow-Origin"), "The public policy should allow all origins")
+
+	res = sendCORS(server, http.MethodGet, "/api/admin/users", "https://www.acme.com", "")
+	suite.Assert().Empty(res.Header().Get("Access-Control-Allow-Origin"), "The admin policy should not inherit the global origins")
+	res = sendCORS(server, http.MethodOptions, "/api/admin/users", "https://admin.acme.com", http.MethodPost)
+	suite.Assert().Equal("https://admin.acme.com", res.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal(http.MethodPost, res.Header().Get("Access-Control-Allow-Methods"), "The admin policy should inherit the global methods")
+
+	res = sendCORS(server, http.MethodOptions, "/api/admin/cache", "https://ops.acme.com", http.MethodDelete)
+	suite.Assert().Equal("https://ops.acme.com", res.Header().Get("Access-Control-Allow-Origin"), "The route policy should win over the subrouter policy")
+	res = sendCORS(server, http.MethodOptions, "/api/admin/cache", "https://admin.acme.com", http.MethodDelete)
+	suite.Assert().Empty(res.Header().Get("Access-Control-Allow-Origin"))
+
+	res = sendCORS(server, http.MethodGet, "/api/other", "https://www.acme.com", "")
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"), "Other routes should use the global policy")
+}
+
+func (suite *ServerSuite) TestCanUpdateCORSPoliciesAtRuntime() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	suite.Assert().Nil(server.CORS(), "CORS should not be enabled")
+	server.AddRouteWithFunc(http.MethodGet, "/api/items", func(w http.ResponseWriter, r *http.Request) {})
+
+	res := sendCORS(server, http.MethodGet, "/api/items", "https://www.acme.com", "")
+	suite.Assert().Empty(res.Header().Get("Access-Control-Allow-Origin"))
+
+	policy := NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"https://www.acme.com"}})
+	server.SetCORS("/api", policy)
+	res = sendCORS(server, http.MethodGet, "/api/items", "https://www.acme.com", "")
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"))
+
+	policy.Update(CORSOptions{AllowOriginFunc: func(origin string) bool { return origin == "https://new.acme.com" }})
+	res = sendCORS(server, http.MethodGet, "/api/items", "https://www.acme.com", "")
+	suite.Assert().Empty(res.Header().Get("Access-Control-Allow-Origin"), "The updated policy should be used")
+	res = sendCORS(server, http.MethodGet, "/api/items", "https://new.acme.com", "")
+	suite.Assert().Equal("https://new.acme.com", res.Header().Get("Access-Control-Allow-Origin"))
+
+	server.SetCORS("/api", nil)
+	res = sendCORS(server, http.MethodGet, "/api/items", "https://new.acme.com", "")
+	suite.Assert().Empty(res.Header().Get("Access-Control-Allow-Origin"), "The detached policy should not be used")
+}
+
+func (suite *ServerSuite) TestCanUseCORSPoliciesOnVersionedRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	policy := WithCORS(NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"https://www.acme.com"}}))
+	byHeader := server.Versioned(VersioningOptions{Prefix: "/api", Strategy: VersionByHeader})
+	byHeader.Version("v1", VersionOptions{}).Get("/users", handler, policy)
+	byHeader.Version("v2", VersionOptions{}).Get("/groups", handler, policy)
+	byPath := server.Versioned(VersioningOptions{Prefix: "/papi"})
+	byPath.Version("v1", VersionOptions{}).Get("/users", handler, policy)
+
+	res := sendCORS(server, http.MethodGet, "/api/users", "https://www.acme.com", "")
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"), "The policy of the default version should apply")
+	req := httptest.NewRequest(http.MethodGet, "/api/groups", nil)
+	req.Header.Set("Origin", "https://www.acme.com")
+	req.Header.Set("Accept-Version", "v2")
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"), "The policy of the requested version should apply")
+
+	res = sendCORS(server, http.MethodGet, "/papi/users", "https://www.acme.com", "")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"), "The policy of the default version path should apply")
+	res = sendCORS(server, http.MethodOptions, "/papi/users", "https://www.acme.com", http.MethodGet)
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"), "Preflights should match the default version path")
+	res = sendCORS(server, http.MethodGet, "/papi/v1/users", "https://www.acme.com", "")
+	suite.Assert().Equal("https://www.acme.com", res.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,282 @@
+package wess
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gildas/go-logger"
+)
+
+// CSRFOptions defines the options of the CSRF protection
+//
+// The protection uses signed double-submit tokens: a token signed with the Secret is sent
+// in a cookie the frontend can read, and the unsafe requests (POST, PUT, PATCH, DELETE, etc)
+// must send it back in a header or a form field.
+// The Origin and Sec-Fetch-Site headers of these requests are checked as well.
+//
+// Requests without Origin and Sec-Fetch-Site headers come from non-browser clients,
+// they are not verified when they carry no cookie or when they carry an Authorization header.
+type CSRFOptions struct {
+	// Secret is the key used to sign the tokens.
+	// Routes that share tokens must share the same Secret.
+	// If empty, a random secret is generated and the tokens do not survive a restart.
+	Secret []byte
+
+	// CookieName is the name of the cookie that carries the token,
+	// it can be read by the frontend's JavaScript.
+	// Default: "wess_csrf"
+	CookieName string
+
+	// CookiePath is the path of the token cookie.
+	// Default: "/"
+	CookiePath string
+
+	// SameSite is the SameSite attribute of the token cookie.
+	// Default: http.SameSiteLaxMode
+	SameSite http.SameSite
+
+	// HeaderName is the name of the header that must carry the token.
+	// Default: "X-Csrf-Token"
+	HeaderName string
+
+	// FormField is the name of the form field that can carry the token,
+	// for HTML forms that cannot send headers.
+	// Default: "csrf_token"
+	FormField string
+
+	// TokenPath is the path of an endpoint that gives the token as JSON ({"token": "..."}).
+	// It is only used with ServerOptions.CSRF.
+	// If empty, the token is only available in the cookie.
+	TokenPath string
+
+	// TrustedOrigins are the origins, besides the server's own origin, allowed to send unsafe requests.
+	// Wildcards are supported like in AllowedCORSOrigins (e.g. "https://*.example.com"), "*" is ignored.
+	// With ServerOptions.CSRF, Default: ServerOptions.AllowedCORSOrigins
+	TrustedOrigins []string
+
+	// TrustedOriginFunc is a custom function to validate the origins.
+	// With ServerOptions.CSRF, Default: ServerOptions.AllowOriginFunc
+	TrustedOriginFunc func(origin string) bool
+
+	// ExemptPaths are the paths of the requests that are not verified, like webhooks.
+	// A path ending with "/" exempts all the paths under it.
+	// With ServerOptions.CSRF, the SecurityHeaders.CSPReportPath is always exempted.
+	ExemptPaths []string
+}
+
+// csrfTokenContextKey is the context key of the CSRF token
+type csrfTokenContextKey struct{}
+
+// csrfProtection verifies the requests against CSRF
+type csrfProtection struct {
+	options CSRFOptions
+}
+
+// CSRFToken gets the CSRF token of the request
+//
+// Server side rendered forms can add it in a hidden field (See CSRFOptions.FormField).
+//
+// returns an empty string if the request did not go through the CSRF middleware
+func CSRFToken(r *http.Request) string {
+	if token, ok := r.Context().Value(csrfTokenContextKey{}).(string); ok {
+		return token
+	}
+	return ""
+}
+
+// withDefaults gives the options with their default values
+func (options CSRFOptions) withDefaults() CSRFOptions {
+	if len(options.Secret) == 0 {
+		options.Secret = make([]byte, 32)
+		_, _ = rand.Read(options.Secret)
+	}
+	if len(options.CookieName) == 0 {
+		options.CookieName = "wess_csrf"
+	}
+	if len(options.CookiePath) == 0 {
+		options.CookiePath = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	if len(options.HeaderName) == 0 {
+		options.HeaderName = "X-Csrf-Token"
+	}
+	if len(options.FormField) == 0 {
+		options.FormField = "csrf_token"
+	}
+	options.TrustedOrigins = slices.DeleteFunc(slices.Clone(options.TrustedOrigins), func(origin string) bool { return origin == "*" })
+	return options
+}
+
+// CSRFMiddleware protects the routes against Cross-Site Request Forgery
+//
+// Safe requests (GET, HEAD, OPTIONS, TRACE) get the token cookie if they do not have it yet.
+// Unsafe requests are refused with a 403 Forbidden Problem if they come from an untrusted origin
+// or if they do not carry the token of their cookie.
+func CSRFMiddleware(options CSRFOptions) func(http.Handler) http.Handler {
+	protection := &csrfProtection{options: options.withDefaults()}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("csrf", "csrf")
+			token, valid := protection.cookieToken(r)
+			if !valid {
+				token = protection.newToken()
+				http.SetCookie(w, &http.Cookie{
+					Name:     protection.options.CookieName,
+					Value:    token,
+					Path:     protection.options.CookiePath,
+					SameSite: protection.options.SameSite,
+					Secure:   GetClientInfo(r).Scheme == "https",
+				})
+			}
+			if !isSafeMethod(r.Method) && !protection.isExempt(r) {
+				if reason := protection.verify(r, token, valid); len(reason) > 0 {
+					log.Record("origin", r.Header.Get("Origin")).Warnf("CSRF verification failed for %s %s: %s", r.Method, r.URL.Path, reason)
+					WriteProblem(w, r, NewProblem(http.StatusForbidden, "CSRF verification failed").With("reason", reason))
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfTokenContextKey{}, token)))
+		})
+	}
+}
+
+// WithCSRF protects a route against Cross-Site Request Forgery (See CSRFMiddleware)
+func WithCSRF(options CSRFOptions) RouteOption {
+	return WithMiddleware(CSRFMiddleware(options))
+}
+
+// csrfTokenHandler gives the CSRF token of the request as JSON
+func csrfTokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": CSRFToken(r)})
+	})
+}
+
+// isSafeMethod tells if the method is safe, i.e. does not change the state of the server
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// newToken generates a new signed token
+func (protection *csrfProtection) newToken() string {
+	random := make([]byte, 32)
+	_, _ = rand.Read(random)
+	return base64.RawURLEncoding.EncodeToString(random) + "." + protection.sign(random)
+}
+
+// sign signs the random part of a token
+func (protection *csrfProtection) sign(random []byte) string {
+	mac := hmac.New(sha256.New, protection.options.Secret)
+	mac.Write(random)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookieToken gets the token of the cookie and tells if its signature is valid
+func (protection *csrfProtection) cookieToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(protection.options.CookieName)
+	if err != nil {
+		return "", false
+	}
+	encoded, signature, found := strings.Cut(cookie.Value, ".")
+	if !found {
+		return "", false
+	}
+	random, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(random) != 32 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(protection.sign(random))) {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// isExempt tells if the request does not need to be verified
+//
+// Requests from non-browser clients (without Origin and Sec-Fetch-Site) cannot be forged
+// by a browser when they carry no cookie, and use their own credentials when they carry an Authorization header.
+func (protection *csrfProtection) isExempt(r *http.Request) bool {
+	for _, path := range protection.options.ExemptPaths {
+		if r.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)) {
+			return true
+		}
+	}
+	if len(r.Header.Get("Origin")) > 0 || len(r.Header.Get("Sec-Fetch-Site")) > 0 {
+		return false
+	}
+	return len(r.Header.Get("Cookie")) == 0 || len(r.Header.Get("Authorization")) > 0
+}
+
+// verify verifies an unsafe request
+//
+// returns the reason why the request is refused, or an empty string if it is allowed
+func (protection *csrfProtection) verify(r *http.Request, token string, valid bool) string {
+	origin := r.Header.Get("Origin")
+	switch site := r.Header.Get("Sec-Fetch-Site"); {
+	case len(origin) > 0:
+		if !protection.isTrustedOrigin(r, origin) {
+			return "untrusted origin"
+		}
+	case site == "cross-site" || site == "same-site":
+		return "cross-site request without origin"
+	}
+	if !valid {
+		return "missing token cookie"
+	}
+	submitted := r.Header.Get(protection.options.HeaderName)
+	if len(submitted) == 0 {
+		if mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediatype == "application/x-www-form-urlencoded" || mediatype == "multipart/form-data" {
+			submitted = r.PostFormValue(protection.options.FormField)
+		}
+	}
+	if len(submitted) == 0 {
+		return "missing token"
+	}
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return "invalid token"
+	}
+	return ""
+}
+
+// isTrustedOrigin tells if the origin is the server's own origin or a trusted origin
+func (protection *csrfProtection) isTrustedOrigin(r *http.Request, origin string) bool {
+	if origin == "null" {
+		return false
+	}
+	info := GetClientInfo(r)
+	if strings.EqualFold(origin, info.Scheme+"://"+info.Host) {
+		return true
+	}
+	for _, trusted := range protection.options.TrustedOrigins {
+		if matchOrigin(trusted, origin) {
+			return true
+		}
+	}
+	return protection.options.TrustedOriginFunc != nil && protection.options.TrustedOriginFunc(origin)
+}
+
+// matchOrigin tells if the origin matches the pattern, that can contain one wildcard
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	if prefix, suffix, found := strings.Cut(pattern, "*"); found {
+		return len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+	}
+	return pattern == origin
+}
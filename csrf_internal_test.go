@@ -0,0 +1,157 @@
+package wess
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+func (suite *ServerSuite) TestCanProtectAgainstCSRF() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, CSRF: &CSRFOptions{TokenPath: "/csrf"}})
+	server.AddRouteWithFunc(http.MethodGet, "/api/items", func(w http.ResponseWriter, r *http.Request) {})
+	server.AddRouteWithFunc(http.MethodPost, "/api/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := send(httptest.NewRequest(http.MethodGet, "/api/items", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Require().Len(res.Result().Cookies(), 1)
+	cookie := res.Result().Cookies()[0]
+	suite.Assert().Equal("wess_csrf", cookie.Name)
+	suite.Assert().False(cookie.HttpOnly, "The frontend must be able to read the token")
+
+	res = send(httptest.NewRequest(http.MethodGet, "/csrf", nil))
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("no-store", res.Header().Get("Cache-Control"))
+
+	req := httptest.NewRequest(http.MethodGet, "/csrf", nil)
+	req.AddCookie(cookie)
+	res = send(req)
+	var body struct{ Token string }
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &body))
+	suite.Assert().Equal(cookie.Value, body.Token, "The token endpoint should give the token of the cookie")
+	suite.Assert().Empty(res.Result().Cookies(), "A valid cookie should not be replaced")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/items", nil)
+	req.AddCookie(cookie)
+	res = send(req)
+	suite.Assert().Equal(http.StatusForbidden, res.Code, "Requests without the token should be refused")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/items", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-Csrf-Token", cookie.Value)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	res = send(req)
+	suite.Assert().Equal(http.StatusCreated, res.Code, "Requests with the token should be allowed")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/items", strings.NewReader(url.Values{"csrf_token": {cookie.Value}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	res = send(req)
+	suite.Assert().Equal(http.StatusCreated, res.Code, "Forms with the token should be allowed")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/items", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-Csrf-Token", cookie.Value)
+	req.Header.Set("Origin", "https://evil.com")
+	res = send(req)
+	suite.Assert().Equal(http.StatusForbidden, res.Code, "Requests from other origins should be refused")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/items", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-Csrf-Token", cookie.Value)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	res = send(req)
+	suite.Assert().Equal(http.StatusForbidden, res.Code, "Cross-site requests without origin should be refused")
+
+	forged := &http.Cookie{Name: "wess_csrf", Value: strings.Split(cookie.Value, ".")[0] + ".forged"}
+	req = httptest.NewRequest(http.MethodPost, "/api/items", nil)
+	req.AddCookie(forged)
+	req.Header.Set("X-Csrf-Token", forged.Value)
+	res = send(req)
+	suite.Assert().Equal(http.StatusForbidden, res.Code, "Tokens with an invalid signature should be refused")
+}
+
+func (suite *ServerSuite) TestShouldTrustCORSOriginsForCSRF() {
+	server := NewServer(ServerOptions{
+		Logger:             suite.Logger,
+		AllowedCORSOrigins: []string{"https://*.example.com"},
+		AllowedCORSMethods: []string{http.MethodGet, http.MethodPost},
+		CSRF:               &CSRFOptions{Secret: []byte("secret")},
+	})
+	server.AddRouteWithFunc(http.MethodPost, "/api/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	token := (&csrfProtection{options: CSRFOptions{Secret: []byte("secret")}}).newToken()
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "x-csrf-token")
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal("x-csrf-token", strings.ToLower(res.Header().Get("Access-Control-Allow-Headers")), "The token header should be allowed by CORS")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Sec-Fetch-Site", "same-site")
+	req.Header.Set("X-Csrf-Token", token)
+	req.AddCookie(&http.Cookie{Name: "wess_csrf", Value: token})
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusCreated, res.Code, "Requests from CORS origins should be trusted")
+
+	suite.Assert().False(matchOrigin("https://*.example.com", "https://example.com.evil.com"))
+}
+
+func (suite *ServerSuite) TestShouldNotVerifyCSRFForNonBrowserClients() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, CSRF: &CSRFOptions{}})
+	server.AddRouteWithFunc(http.MethodPost, "/api/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func(headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/items", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res.Code
+	}
+	suite.Assert().Equal(http.StatusCreated, send(map[string]string{"Authorization": "Bearer token"}), "API clients should not need a token")
+	suite.Assert().Equal(http.StatusCreated, send(map[string]string{}), "Requests without cookies should not need a token")
+	suite.Assert().Equal(http.StatusCreated, send(map[string]string{"Authorization": "Bearer token", "Cookie": "session=1"}))
+	suite.Assert().Equal(http.StatusForbidden, send(map[string]string{"Cookie": "session=1"}), "Requests with cookies should need a token")
+	suite.Assert().Equal(http.StatusForbidden, send(map[string]string{"Authorization": "Bearer token", "Origin": "https://evil.com"}), "Browser requests should be verified")
+	suite.Assert().Equal(http.StatusForbidden, send(map[string]string{"Sec-Fetch-Site": "same-origin"}), "Browser requests should be verified")
+}
+
+func (suite *ServerSuite) TestCanExemptPathsFromCSRF() {
+	server := NewServer(ServerOptions{
+		Logger:          suite.Logger,
+		SecurityHeaders: &SecurityHeaders{CSPReportPath: "/csp-report"},
+		CSRF:            &CSRFOptions{ExemptPaths: []string{"/webhooks/"}},
+	})
+	server.AddRouteWithFunc(http.MethodPost, "/webhooks/github", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	send := func(path, contentType, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Origin", "https://www.acme.com")
+		req.Header.Set("Cookie", "session=1")
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res.Code
+	}
+	suite.Assert().Equal(http.StatusAccepted, send("/webhooks/github", "application/json", "{}"))
+	suite.Assert().Equal(http.StatusNoContent, send("/csp-report", "application/csp-report", `{"csp-report":{"violated-directive":"script-src"}}`), "The CSP reports should be exempted")
+}
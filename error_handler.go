@@ -0,0 +1,161 @@
+package wess
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+)
+
+// HandlerFuncWithError is an HTTP handler that returns an error
+//
+// The returned error is mapped to an HTTP status (See RegisterErrorStatus)
+// and written as a Problem (See WriteError).
+//
+// As it implements http.Handler, it can be given to AddRoute or to any subrouter:
+//
+//	server.AddRoute("GET", "/users/{id}", wess.HandlerFuncWithError(func(w http.ResponseWriter, r *http.Request) error {
+//	  return errors.NotFound.With("user", mux.Vars(r)["id"])
+//	}))
+type HandlerFuncWithError func(w http.ResponseWriter, r *http.Request) error
+
+// ServeHTTP calls handler(w, r) and writes the returned error, if any
+//
+// implements http.Handler
+func (handler HandlerFuncWithError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := handler(w, r); err != nil {
+		WriteError(w, r, err)
+	}
+}
+
+// nilLogger is used when no Logger can be found in the request context
+var nilLogger = logger.Create("WESS", &logger.NilStream{})
+
+// errorStatus associates an error to an HTTP status
+type errorStatus struct {
+	Target error
+	Status int
+}
+
+// errorStatusRegistry contains the error to HTTP status mappings
+type errorStatusRegistry struct {
+	mutex    sync.RWMutex
+	mappings []errorStatus
+}
+
+var errorStatuses = &errorStatusRegistry{
+	mappings: []errorStatus{
+		{errors.ArgumentMissing, http.StatusBadRequest},
+		{errors.ArgumentExpected, http.StatusBadRequest},
+		{errors.ArgumentInvalid, http.StatusBadRequest},
+		{errors.Empty, http.StatusBadRequest},
+		{errors.Invalid, http.StatusBadRequest},
+		{errors.InvalidType, http.StatusBadRequest},
+		{errors.InvalidURL, http.StatusBadRequest},
+		{errors.IndexOutOfBounds, http.StatusBadRequest},
+		{errors.JSONUnmarshalError, http.StatusBadRequest},
+		{errors.JSONPropertyMissing, http.StatusBadRequest},
+		{errors.Missing, http.StatusBadRequest},
+		{errors.Unsupported, http.StatusBadRequest},
+		{errors.Unauthorized, http.StatusUnauthorized},
+		{errors.NotFound, http.StatusNotFound},
+		{errors.DuplicateFound, http.StatusConflict},
+		{errors.NotImplemented, http.StatusNotImplemented},
+		{errors.NotConnected, http.StatusServiceUnavailable},
+		{errors.Timeout, http.StatusGatewayTimeout},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+	},
+}
+
+// RegisterErrorStatus registers the HTTP status to use when a handler returns an error that matches the target
+//
+// The target is matched with errors.Is, the most recently registered targets are checked first.
+//
+// Example:
+//
+//	var ErrQuotaExceeded = errors.NewSentinel(http.StatusTooManyRequests, "error.quota.exceeded", "Quota exceeded for %s")
+//	wess.RegisterErrorStatus(ErrQuotaExceeded, http.StatusTooManyRequests)
+func RegisterErrorStatus(target error, status int) {
+	errorStatuses.mutex.Lock()
+	defer errorStatuses.mutex.Unlock()
+	errorStatuses.mappings = append([]errorStatus{{target, status}}, errorStatuses.mappings...)
+}
+
+// ErrorStatus gives the HTTP status for the given error
+//
+// A Problem with a Status gives that status,
+// then request bodies over their limit (See MaxBodySizeMiddleware) give http.StatusRequestEntityTooLarge,
+// then the registered targets are checked (See RegisterErrorStatus),
+// then go-errors HTTP errors (like errors.HTTPForbidden) give their own code.
+//
+// Other errors give http.StatusInternalServerError.
+func ErrorStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var problem Problem
+	if errors.As(err, &problem) && problem.Status > 0 {
+		return problem.Status
+	}
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	errorStatuses.mutex.RLock()
+	for _, mapping := range errorStatuses.mappings {
+		if errors.Is(err, mapping.Target) {
+			errorStatuses.mutex.RUnlock()
+			return mapping.Status
+		}
+	}
+	errorStatuses.mutex.RUnlock()
+
+	var details *errors.Error
+	if errors.As(err, &details) && details.Code >= http.StatusBadRequest && details.Code < 600 {
+		return details.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// WriteError writes the given error to the response as a Problem
+//
+// The HTTP status is given by ErrorStatus.
+//
+// Server errors (5xx) are logged with their stack and their details are not sent to the client,
+// the Problem carries the request identifier so the logs can be searched.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var problem Problem
+	if errors.As(err, &problem) {
+		WriteProblem(w, r, problem)
+		return
+	}
+
+	log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child(nil, "error")
+	status := ErrorStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Errorf("Error while handling %s %s", r.Method, r.URL.Path, err)
+		WriteProblem(w, r, NewProblem(status, ""))
+		return
+	}
+
+	log.Warnf("Error while handling %s %s: %s", r.Method, r.URL.Path, err)
+	problem = NewProblem(status, errorDetail(err))
+	var details *errors.Error
+	if errors.As(err, &details) && len(details.ID) > 0 {
+		problem = problem.With("errorId", details.ID)
+	}
+	WriteProblem(w, r, problem)
+}
+
+// errorDetail gives the message of the error without its causes
+func errorDetail(err error) string {
+	var details *errors.Error
+	if errors.As(err, &details) {
+		details.Cause = nil
+		return details.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,61 @@
+package wess
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gildas/go-errors"
+)
+
+func (suite *ServerSuite) TestCanMapErrorsToStatus() {
+	suite.Assert().Equal(http.StatusOK, ErrorStatus(nil))
+	suite.Assert().Equal(http.StatusBadRequest, ErrorStatus(errors.ArgumentMissing.With("name")))
+	suite.Assert().Equal(http.StatusNotFound, ErrorStatus(errors.NotFound.With("user", "12")))
+	suite.Assert().Equal(http.StatusUnauthorized, ErrorStatus(errors.Unauthorized.WithStack()))
+	suite.Assert().Equal(http.StatusForbidden, ErrorStatus(errors.HTTPForbidden.WithStack()))
+	suite.Assert().Equal(http.StatusNotFound, ErrorStatus(fmt.Errorf("wrapped: %w", errors.NotFound.With("user"))))
+	suite.Assert().Equal(http.StatusInternalServerError, ErrorStatus(fmt.Errorf("something bad")))
+	suite.Assert().Equal(http.StatusTeapot, ErrorStatus(NewProblem(http.StatusTeapot, "")))
+
+	sentinel := errors.NewSentinel(http.StatusInternalServerError, "error.test.quota", "Quota exceeded")
+	suite.Assert().Equal(http.StatusInternalServerError, ErrorStatus(sentinel.WithStack()))
+	RegisterErrorStatus(sentinel, http.StatusTooManyRequests)
+	suite.Assert().Equal(http.StatusTooManyRequests, ErrorStatus(sentinel.WithStack()))
+}
+
+func (suite *ServerSuite) TestCanServeHandlerWithError() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRoute(http.MethodGet, "/users/{id}", HandlerFuncWithError(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.NotFound.With("user", "12")
+	}))
+	server.AddRoute(http.MethodGet, "/boom", HandlerFuncWithError(func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("database password is hunter2")
+	}))
+	server.AddRoute(http.MethodGet, "/ok", HandlerFuncWithError(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}))
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/users/12", nil))
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	var payload map[string]any
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &payload))
+	suite.Assert().Equal("user 12 Not Found", payload["detail"])
+	suite.Assert().Equal("error.notfound", payload["errorId"])
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	suite.Assert().Equal(http.StatusInternalServerError, res.Code)
+	suite.Assert().NotContains(res.Body.String(), "hunter2")
+	payload = map[string]any{}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &payload))
+	suite.Assert().NotEmpty(payload["requestId"], "The problem should carry the request ID")
+	suite.Assert().Equal(res.Header().Get("X-Request-Id"), payload["requestId"])
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+}
@@ -0,0 +1,274 @@
+package wess
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gildas/go-errors"
+)
+
+// ClientInfo describes the client of a request
+//
+// When the request goes through trusted proxies, the information is taken
+// from the Forwarded or X-Forwarded-* headers (See ServerOptions.TrustedProxies).
+type ClientInfo struct {
+	// IP is the IP address of the client
+	IP netip.Addr
+
+	// Scheme is the scheme used by the client ("http" or "https")
+	Scheme string
+
+	// Host is the host requested by the client
+	Host string
+}
+
+// clientInfoContextKey is the key of the ClientInfo in a request context
+type clientInfoContextKey struct{}
+
+// TrustedProxies is a list of networks whose proxies are trusted to forward client information
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a list of CIDRs or IP addresses
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		address, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return nil, errors.ArgumentInvalid.With("cidr", cidr)
+		}
+		address = address.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(address, address.BitLen()))
+	}
+	return proxies, nil
+}
+
+// Contains tells if the given address belongs to a trusted proxy
+func (proxies TrustedProxies) Contains(address netip.Addr) bool {
+	address = address.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware resolves the client information of the requests and stores it in the request context
+//
+// The request RemoteAddr, Host and URL.Scheme are also updated,
+// so the access logs and the routes see the real client.
+func (proxies TrustedProxies) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := proxies.Resolve(r)
+			r = r.WithContext(context.WithValue(r.Context(), clientInfoContextKey{}, info))
+			if info.IP.IsValid() {
+				r.RemoteAddr = info.IP.String()
+			}
+			r.Host = info.Host
+			url := *r.URL
+			url.Scheme = info.Scheme
+			r.URL = &url
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Resolve resolves the client information of the given request
+//
+// The forwarding headers are only read if the request comes from a trusted proxy.
+// The hops are walked from right to left, stopping at the first untrusted hop.
+func (proxies TrustedProxies) Resolve(r *http.Request) ClientInfo {
+	info := directClientInfo(r)
+	if !info.IP.IsValid() || !proxies.Contains(info.IP) {
+		return info
+	}
+
+	hops := parseForwarded(r.Header)
+	if len(hops) == 0 {
+		hops = parseXForwarded(r.Header)
+	}
+	for index := len(hops) - 1; index >= 0; index-- {
+		hop := hops[index]
+		if !hop.For.IsValid() {
+			break
+		}
+		info.IP = hop.For
+		if len(hop.Proto) > 0 {
+			info.Scheme = hop.Proto
+		}
+		if len(hop.Host) > 0 {
+			info.Host = hop.Host
+		}
+		if !proxies.Contains(hop.For) {
+			break
+		}
+	}
+	return info
+}
+
+// GetClientInfo gets the client information of the request
+//
+// If the request did not go through the trusted proxies middleware,
+// the information is taken from the connection.
+func GetClientInfo(r *http.Request) ClientInfo {
+	if info, ok := r.Context().Value(clientInfoContextKey{}).(ClientInfo); ok {
+		return info
+	}
+	return directClientInfo(r)
+}
+
+// ClientIP gets the IP address of the client of the request
+//
+// returns an empty string if the address cannot be found
+func ClientIP(r *http.Request) string {
+	if ip := GetClientInfo(r).IP; ip.IsValid() {
+		return ip.String()
+	}
+	return ""
+}
+
+// directClientInfo gets the client information from the connection
+func directClientInfo(r *http.Request) ClientInfo {
+	info := ClientInfo{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		info.Scheme = "https"
+	}
+	info.IP = parseIP(r.RemoteAddr)
+	return info
+}
+
+// parseIP parses an IP address with an optional port
+func parseIP(value string) netip.Addr {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	address, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return address.Unmap()
+}
+
+// forwardedHop describes a hop of a forwarded request
+type forwardedHop struct {
+	For   netip.Addr
+	Proto string
+	Host  string
+}
+
+// parseForwarded parses the RFC 7239 Forwarded headers
+func parseForwarded(header http.Header) (hops []forwardedHop) {
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			hop := forwardedHop{}
+			for _, pair := range strings.Split(element, ";") {
+				key, raw, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found {
+					continue
+				}
+				raw = strings.Trim(strings.TrimSpace(raw), `"`)
+				switch strings.ToLower(strings.TrimSpace(key)) {
+				case "for":
+					hop.For = parseIP(raw)
+				case "proto":
+					hop.Proto = sanitizeScheme(raw)
+				case "host":
+					hop.Host = sanitizeHost(raw)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return
+}
+
+// parseXForwarded parses the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers
+//
+// When X-Forwarded-Proto and X-Forwarded-Host have as many values as X-Forwarded-For, they are aligned with it,
+// otherwise their last value (set by the closest proxy) applies to the first hop that is walked.
+func parseXForwarded(header http.Header) (hops []forwardedHop) {
+	addresses := splitHeaderValues(header, "X-Forwarded-For")
+	protos := splitHeaderValues(header, "X-Forwarded-Proto")
+	hosts := splitHeaderValues(header, "X-Forwarded-Host")
+	for index, address := range addresses {
+		hop := forwardedHop{For: parseIP(address)}
+		if len(protos) == len(addresses) {
+			hop.Proto = sanitizeScheme(protos[index])
+		} else if len(protos) > 0 && index == len(addresses)-1 {
+			hop.Proto = sanitizeScheme(protos[len(protos)-1])
+		}
+		if len(hosts) == len(addresses) {
+			hop.Host = sanitizeHost(hosts[index])
+		} else if len(hosts) > 0 && index == len(addresses)-1 {
+			hop.Host = sanitizeHost(hosts[len(hosts)-1])
+		}
+		hops = append(hops, hop)
+	}
+	return
+}
+
+// splitHeaderValues gets all the comma separated values of a header
+func splitHeaderValues(header http.Header, name string) (values []string) {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				values = append(values, item)
+			}
+		}
+	}
+	return
+}
+
+// sanitizeScheme returns the scheme if it is http or https, an empty string otherwise
+func sanitizeScheme(scheme string) string {
+	switch scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme {
+	case "http", "https":
+		return scheme
+	default:
+		return ""
+	}
+}
+
+// sanitizeHost returns the host if it is a valid host[:port], an empty string otherwise
+func sanitizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if len(host) == 0 || len(host) > 255 || strings.ContainsAny(host, "/\\@?#% \t\"'<>") {
+		return ""
+	}
+	return host
+}
+
+// httpsRedirectMiddleware redirects the requests that were sent over HTTP to HTTPS
+//
+// The scheme is the one resolved through the trusted proxies (See GetClientInfo).
+// Requests whose path starts with one of the excluded prefixes are not redirected.
+func httpsRedirectMiddleware(excluded ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := GetClientInfo(r)
+			if info.Scheme == "https" || len(info.Host) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, prefix := range excluded {
+				if len(prefix) > 0 && strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			target := "https://" + info.Host + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+		})
+	}
+}
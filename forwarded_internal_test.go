@@ -0,0 +1,81 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+func (suite *ServerSuite) TestCanParseTrustedProxies() {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "192.168.1.1", "::1")
+	suite.Require().NoError(err, "Failed parsing the trusted proxies")
+	suite.Assert().Len(proxies, 3)
+
+	_, err = ParseTrustedProxies("10.0.0.0/8", "not-an-ip")
+	suite.Require().Error(err, "Should have failed parsing an invalid proxy")
+}
+
+func (suite *ServerSuite) TestCanResolveClientFromXForwardedHeaders() {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	suite.Require().NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:4567"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7, 10.0.0.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "www.acme.com")
+	info := proxies.Resolve(req)
+	suite.Assert().Equal("203.0.113.7", info.IP.String(), "Should stop at the first untrusted hop")
+	suite.Assert().Equal("https", info.Scheme)
+	suite.Assert().Equal("www.acme.com", info.Host)
+
+	// Headers from untrusted clients are ignored
+	req.RemoteAddr = "198.51.100.1:4567"
+	info = proxies.Resolve(req)
+	suite.Assert().Equal("198.51.100.1", info.IP.String())
+	suite.Assert().Equal("http", info.Scheme)
+	suite.Assert().Equal("example.com", info.Host)
+}
+
+func (suite *ServerSuite) TestCanResolveClientFromForwardedHeader() {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "2001:db8::/32")
+	suite.Require().NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "[2001:db8::1]:4567"
+	req.Header.Set("Forwarded", `for=192.0.2.60;proto=https;host=www.acme.com, for="[2001:db8::2]:1234";proto=http`)
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+	info := proxies.Resolve(req)
+	suite.Assert().Equal("192.0.2.60", info.IP.String())
+	suite.Assert().Equal("https", info.Scheme)
+	suite.Assert().Equal("www.acme.com", info.Host)
+}
+
+func (suite *ServerSuite) TestCanUseClientInfoInServer() {
+	server := NewServer(ServerOptions{
+		Logger:          suite.Logger,
+		TrustedProxies:  []string{"10.0.0.0/8"},
+		RedirectToHTTPS: true,
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/ip", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ClientIP(r) + " " + r.RemoteAddr))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = "10.0.0.2:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("203.0.113.7 203.0.113.7", res.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/ip?x=1", nil)
+	req.RemoteAddr = "10.0.0.2:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("X-Forwarded-Proto", "http")
+	req.Header.Set("X-Forwarded-Host", "www.acme.com")
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusPermanentRedirect, res.Code)
+	suite.Assert().Equal("https://www.acme.com/ip?x=1", res.Header().Get("Location"))
+}
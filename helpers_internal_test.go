@@ -0,0 +1,32 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// cookieTestClient sends requests to the server, keeping the cookies
+type cookieTestClient struct {
+	server  *Server
+	cookies map[string]*http.Cookie
+}
+
+func (client *cookieTestClient) send(method, target string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+	for _, cookie := range client.cookies {
+		req.AddCookie(cookie)
+	}
+	res := httptest.NewRecorder()
+	client.server.webserver.Handler.ServeHTTP(res, req)
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(client.cookies, cookie.Name)
+		} else {
+			client.cookies[cookie.Name] = cookie
+		}
+	}
+	return res
+}
@@ -0,0 +1,266 @@
+package wess
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+)
+
+// IPAccessList allows or denies the requests by client IP address
+//
+// A request is denied if its client IP matches a deny rule,
+// or if there are allow rules and none of them matches.
+//
+// The client IP is resolved through the trusted proxies (See ServerOptions.TrustedProxies).
+//
+// The rules can be replaced at runtime with Update or Reload, it is safe for concurrent use.
+type IPAccessList struct {
+	// Logger logs the reloads and the denied requests that are not handled by a route.
+	// Default: the server's logger for the denied requests with ServerOptions.IPAccessList, nothing otherwise
+	Logger *logger.Logger
+
+	mutex    sync.RWMutex
+	allow    []ipAccessRule
+	deny     []ipAccessRule
+	filename string
+	modified time.Time
+}
+
+// ipAccessRule is an allow or deny rule
+type ipAccessRule struct {
+	prefix netip.Prefix
+	source string // the rule as written, with its file and line if any
+}
+
+// NewIPAccessList creates a new IPAccessList with the given CIDRs (or IP addresses)
+func NewIPAccessList(allow, deny []string) (*IPAccessList, error) {
+	list := &IPAccessList{}
+	if err := list.Update(allow, deny); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// LoadIPAccessList creates a new IPAccessList from a file
+//
+// The file contains one rule per line, "allow <cidr>" or "deny <cidr>".
+// Empty lines and lines starting with # are ignored.
+//
+// The file can be reloaded with Reload or Watch.
+func LoadIPAccessList(filename string) (*IPAccessList, error) {
+	list := &IPAccessList{filename: filename}
+	if err := list.Reload(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Update replaces the rules of the list
+//
+// If a CIDR is invalid, the rules are not changed.
+func (list *IPAccessList) Update(allow, deny []string) error {
+	allowRules, err := parseIPAccessRules("allow", allow)
+	if err != nil {
+		return err
+	}
+	denyRules, err := parseIPAccessRules("deny", deny)
+	if err != nil {
+		return err
+	}
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.allow, list.deny = allowRules, denyRules
+	return nil
+}
+
+// Reload reloads the rules from the file of the list
+//
+// If the file is invalid, the rules are not changed.
+func (list *IPAccessList) Reload() error {
+	if len(list.filename) == 0 {
+		return errors.ArgumentMissing.With("filename")
+	}
+	file, err := os.Open(list.filename)
+	if err != nil {
+		return errors.NotFound.With("file", list.filename)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	allow, deny, err := readIPAccessRules(file, list.filename)
+	if err != nil {
+		return err
+	}
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.allow, list.deny = allow, deny
+	list.modified = stat.ModTime()
+	return nil
+}
+
+// Watch reloads the file of the list when it changes, until the context is done
+//
+// The file is checked every interval. Default: 10 seconds
+func (list *IPAccessList) Watch(context context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	log := logger.Must(logger.FromContext(context, list.logger())).Child("ipaccess", "watch")
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-context.Done():
+				return
+			case <-ticker.C:
+				stat, err := os.Stat(list.filename)
+				if err != nil {
+					log.Warnf("Failed to check the IP access list %s: %s", list.filename, err)
+					continue
+				}
+				list.mutex.RLock()
+				modified := list.modified
+				list.mutex.RUnlock()
+				if stat.ModTime().Equal(modified) {
+					continue
+				}
+				if err := list.Reload(); err != nil {
+					log.Errorf("Failed to reload the IP access list %s, keeping the current rules", list.filename, err)
+					continue
+				}
+				log.Infof("Reloaded the IP access list %s", list.filename)
+			}
+		}
+	}()
+}
+
+// Check tells if the address is allowed
+//
+// returns the rule that matched, or "default deny" if the address did not match any allow rule.
+// When there are allow rules, an invalid address is denied.
+func (list *IPAccessList) Check(address netip.Addr) (allowed bool, rule string) {
+	address = address.Unmap()
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	for _, deny := range list.deny {
+		if deny.prefix.Contains(address) {
+			return false, deny.source
+		}
+	}
+	if len(list.allow) == 0 {
+		return true, ""
+	}
+	if !address.IsValid() {
+		return false, "unknown client address"
+	}
+	for _, allow := range list.allow {
+		if allow.prefix.Contains(address) {
+			return true, allow.source
+		}
+	}
+	return false, "default deny"
+}
+
+// Middleware denies the requests whose client IP is not allowed with a 403 Forbidden Problem
+//
+// Every denied request is logged with the rule that matched.
+func (list *IPAccessList) Middleware() func(http.Handler) http.Handler {
+	return list.middleware(list.logger())
+}
+
+// WithIPAccessList allows or denies the requests of a route by client IP (See IPAccessList.Middleware)
+func WithIPAccessList(list *IPAccessList) RouteOption {
+	return WithMiddleware(list.Middleware())
+}
+
+// middleware denies the requests that are not allowed and logs them with the given logger,
+// except those whose path is one of the excluded paths
+func (list *IPAccessList) middleware(log *logger.Logger, excluded ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range excluded {
+				if len(path) > 0 && r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			address := GetClientInfo(r).IP
+			if allowed, rule := list.Check(address); !allowed {
+				log := logger.Must(logger.FromContext(r.Context(), log)).Child("ipaccess", "deny")
+				log.Record("rule", rule).Warnf("Access denied to %s for %s %s, rule: %s", address, r.Method, r.URL.Path, rule)
+				WriteProblem(w, r, NewProblem(http.StatusForbidden, "Access denied"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// logger gives the logger of the list
+func (list *IPAccessList) logger() *logger.Logger {
+	if list.Logger != nil {
+		return list.Logger
+	}
+	return nilLogger
+}
+
+// parseIPAccessRules parses a list of CIDRs or IP addresses
+func parseIPAccessRules(action string, cidrs []string) ([]ipAccessRule, error) {
+	rules := make([]ipAccessRule, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes, err := ParseTrustedProxies(cidr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, ipAccessRule{prefix: prefixes[0], source: action + " " + strings.TrimSpace(cidr)})
+	}
+	return rules, nil
+}
+
+// readIPAccessRules reads the rules of an IP access list file
+func readIPAccessRules(reader io.Reader, filename string) (allow []ipAccessRule, deny []ipAccessRule, err error) {
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if comment := strings.Index(line, "#"); comment > 0 {
+			line = strings.TrimSpace(line[:comment])
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, errors.ArgumentInvalid.With(fmt.Sprintf("%s:%d", filename, number), line)
+		}
+		prefixes, err := ParseTrustedProxies(fields[1])
+		if err != nil {
+			return nil, nil, errors.ArgumentInvalid.With(fmt.Sprintf("%s:%d", filename, number), line)
+		}
+		rule := ipAccessRule{prefix: prefixes[0], source: fmt.Sprintf("%s %s (%s:%d)", strings.ToLower(fields[0]), fields[1], filename, number)}
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			allow = append(allow, rule)
+		case "deny":
+			deny = append(deny, rule)
+		default:
+			return nil, nil, errors.ArgumentInvalid.With(fmt.Sprintf("%s:%d", filename, number), line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.RuntimeError.Wrap(err)
+	}
+	return allow, deny, nil
+}
@@ -0,0 +1,92 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+)
+
+func (suite *ServerSuite) TestCanDenyClientIPsGlobally() {
+	list, err := NewIPAccessList(nil, []string{"203.0.113.0/24"})
+	suite.Require().NoError(err)
+	server := NewServer(ServerOptions{
+		Logger:         suite.Logger,
+		ProbePort:      80,
+		TrustedProxies: []string{"10.0.0.0/8"},
+		IPAccessList:   list,
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/hello", func(w http.ResponseWriter, r *http.Request) {})
+	send := func(path, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.2:4567"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	suite.Assert().Equal(http.StatusForbidden, send("/hello", "203.0.113.7"), "Denied clients behind a trusted proxy should be refused")
+	suite.Assert().Equal(http.StatusForbidden, send("/unknown", "203.0.113.7"), "Denied clients should be refused on all paths")
+	suite.Assert().Equal(http.StatusOK, send("/hello", "198.51.100.1"))
+	suite.Assert().NotEqual(http.StatusForbidden, send("/healthz/liveness", "203.0.113.7"), "Health routes should not be denied")
+
+	server.healthRoutes(server.proberouter)
+	suite.Assert().NotEqual(http.StatusForbidden, send("/healthz/readiness", "203.0.113.7"), "The probes should not be denied")
+	suite.Assert().Equal(http.StatusForbidden, send("/healthz/metrics", "203.0.113.7"), "The other health routes should be denied")
+	suite.Assert().Equal(http.StatusForbidden, send("/healthz/livenessx", "203.0.113.7"))
+}
+
+func (suite *ServerSuite) TestShouldNotExemptRoutesFromIPAccessListWithoutProbes() {
+	list, err := NewIPAccessList([]string{"198.51.100.0/24"}, nil)
+	suite.Require().NoError(err)
+	server := NewServer(ServerOptions{Logger: suite.Logger, IPAccessList: list})
+	server.AddRouteWithFunc(http.MethodGet, "/readiness", func(w http.ResponseWriter, r *http.Request) {})
+	server.AddRouteWithFunc(http.MethodGet, "/liveness", func(w http.ResponseWriter, r *http.Request) {})
+	for _, path := range []string{"/readiness", "/liveness"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.7:4567"
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		suite.Assert().Equal(http.StatusForbidden, res.Code, "%s is not a probe without a ProbePort", path)
+	}
+	suite.Assert().Nil(list.Logger, "The server should not change the logger of the list")
+}
+
+func (suite *ServerSuite) TestCanReloadIPAccessListFromFile() {
+	filename := filepath.Join(suite.T().TempDir(), "admin.acl")
+	suite.Require().NoError(os.WriteFile(filename, []byte("# Office VPN\nallow 192.168.0.0/16\ndeny 192.168.66.0/24 # Guests\n"), 0o600))
+	list, err := LoadIPAccessList(filename)
+	suite.Require().NoError(err)
+
+	allowed, rule := list.Check(netip.MustParseAddr("192.168.66.6"))
+	suite.Assert().False(allowed)
+	suite.Assert().Equal("deny 192.168.66.0/24 ("+filename+":3)", rule)
+	allowed, rule = list.Check(netip.MustParseAddr("172.16.0.1"))
+	suite.Assert().False(allowed)
+	suite.Assert().Equal("default deny", rule)
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRouteWithFunc(http.MethodGet, "/hello", func(w http.ResponseWriter, r *http.Request) {})
+	server.AddRouteWithFunc(http.MethodGet, "/admin", func(w http.ResponseWriter, r *http.Request) {}, WithIPAccessList(list))
+	send := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res.Code
+	}
+	suite.Assert().Equal(http.StatusOK, send("/admin", "192.168.1.1:1234"))
+	suite.Assert().Equal(http.StatusForbidden, send("/admin", "172.16.0.1:1234"))
+	suite.Assert().Equal(http.StatusOK, send("/hello", "172.16.0.1:1234"), "Other routes should not be restricted")
+
+	suite.Require().NoError(os.WriteFile(filename, []byte("allow 172.16.0.0/12\n"), 0o600))
+	suite.Require().NoError(list.Reload())
+	suite.Assert().Equal(http.StatusOK, send("/admin", "172.16.0.1:1234"), "The reloaded rules should be used")
+	suite.Assert().Equal(http.StatusForbidden, send("/admin", "192.168.1.1:1234"))
+
+	suite.Require().NoError(os.WriteFile(filename, []byte("allow not-a-cidr\n"), 0o600))
+	suite.Assert().Error(list.Reload())
+	suite.Assert().Equal(http.StatusOK, send("/admin", "172.16.0.1:1234"), "Invalid files should not change the rules")
+}
@@ -0,0 +1,155 @@
+package wess
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+)
+
+// StatusCoder is implemented by the responses of JSON handlers that choose their HTTP status
+//
+// Default: http.StatusOK
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// NoContent is the response of JSON handlers that do not send a body, it is sent with 204 No Content
+type NoContent struct{}
+
+// JSON creates an HTTP handler from a typed handler
+//
+// The request is bound to a Req (See Bind) and validated (See Validate),
+// then the handler is called and its response is sent as JSON, or in the media type the client prefers (See Render).
+// The status of the response is 200 OK, unless it implements StatusCoder or is a NoContent.
+//
+// If the request cannot be bound, a 400 Bad Request Problem is sent,
+// if it is not valid, a 422 Unprocessable Entity Problem is sent, both with the invalid fields in their "errors" member.
+// If the handler returns an error, it is written with WriteError.
+// If the client does not accept any of the media types of the renderers, a 406 Not Acceptable Problem is sent
+// and the handler is not called.
+//
+// The handler can get the HTTP request with RequestFromContext and set response headers with ResponseHeader.
+//
+// Example:
+//
+//	server.AddRoute("POST", "/users/{group}", wess.JSON(func(context context.Context, request CreateUser) (User, error) {
+//	  return users.Create(context, request.Group, request.Name)
+//	}))
+//
+// When the handler is given to AddRoute or a RouteGroup, the route documents its request and response types (See AddOpenAPI).
+//
+// JSON panics if the validate tags of Req have unknown rules, rules with invalid arguments,
+// or rules that cannot be applied to their field (like min on a bool).
+func JSON[Req any, Resp any](handler func(context context.Context, request Req) (Resp, error)) http.Handler {
+	validateRules(reflect.TypeFor[Req](), map[reflect.Type]bool{})
+	return jsonHandler[Req, Resp](handler)
+}
+
+// jsonHandler is the http.Handler created by JSON
+type jsonHandler[Req any, Resp any] func(context context.Context, request Req) (Resp, error)
+
+// ServeHTTP binds, validates, calls the handler and sends its response
+//
+// implements http.Handler
+func (handler jsonHandler[Req, Resp]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !canRender(r) {
+		WriteProblem(w, r, NewProblem(http.StatusNotAcceptable, "The response cannot be sent in the accepted media types").With("available", renderableMediaTypes()))
+		return
+	}
+	var request Req
+	if err := Bind(r, &request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if err := Validate(&request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	context := context.WithValue(r.Context(), jsonHandlerContextKey{}, jsonHandlerContext{request: r, header: w.Header()})
+	response, err := handler(context, request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if _, noContent := any(response).(NoContent); noContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	status := http.StatusOK
+	if coder, ok := any(response).(StatusCoder); ok && coder.StatusCode() > 0 {
+		status = coder.StatusCode()
+	}
+	Render(w, r, status, response)
+}
+
+// bodyTypes gives the types of the request and response bodies, for the documentation of the route
+func (handler jsonHandler[Req, Resp]) bodyTypes() (request reflect.Type, responses map[int]reflect.Type) {
+	if hasJSONBody(reflect.TypeFor[Req]()) {
+		request = reflect.TypeFor[Req]()
+	}
+	responses = map[int]reflect.Type{
+		http.StatusBadRequest:          reflect.TypeFor[Problem](),
+		http.StatusUnprocessableEntity: reflect.TypeFor[Problem](),
+	}
+	responseType := reflect.TypeFor[Resp]()
+	switch {
+	case responseType == reflect.TypeFor[NoContent]():
+		responses[http.StatusNoContent] = nil
+	case responseType.Kind() != reflect.Pointer && responseType.Kind() != reflect.Interface && responseType.Implements(reflect.TypeFor[StatusCoder]()):
+		var zero Resp
+		responses[any(zero).(StatusCoder).StatusCode()] = responseType
+	default:
+		responses[http.StatusOK] = responseType
+	}
+	return
+}
+
+// hasJSONBody tells if a request type is bound from the request body
+//
+// Structs whose fields are all bound from the path, query or headers have no body.
+func hasJSONBody(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if !isBoundField(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonHandlerContext is the HTTP request and response of a JSON handler
+type jsonHandlerContext struct {
+	request *http.Request
+	header  http.Header
+}
+
+// jsonHandlerContextKey is the context key of the jsonHandlerContext
+type jsonHandlerContextKey struct{}
+
+// RequestFromContext gives the HTTP request of a JSON handler (See JSON)
+//
+// returns nil if the context does not come from a JSON handler
+func RequestFromContext(context context.Context) *http.Request {
+	if handlerContext, ok := context.Value(jsonHandlerContextKey{}).(jsonHandlerContext); ok {
+		return handlerContext.request
+	}
+	return nil
+}
+
+// ResponseHeader gives the response headers of a JSON handler (See JSON)
+//
+// returns an empty header if the context does not come from a JSON handler, changes to it are lost
+func ResponseHeader(context context.Context) http.Header {
+	if handlerContext, ok := context.Value(jsonHandlerContextKey{}).(jsonHandlerContext); ok {
+		return handlerContext.header
+	}
+	return http.Header{}
+}
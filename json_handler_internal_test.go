@@ -0,0 +1,213 @@
+package wess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gildas/go-errors"
+)
+
+type jsonTestRequest struct {
+	Group  string   `path:"group"`
+	Tenant string   `header:"X-Tenant" validate:"required"`
+	Page   int      `query:"page" validate:"omitempty,min=1"`
+	Sort   []string `query:"sort"`
+	Name   string   `json:"name" validate:"required,max=8"`
+	Email  string   `json:"email" validate:"omitempty,email"`
+	Role   string   `json:"role" validate:"omitempty,oneof=admin user"`
+	Items  []struct {
+		Quantity int `json:"quantity" validate:"min=1"`
+	} `json:"items"`
+}
+
+type jsonTestResponse struct {
+	Group  string   `json:"group"`
+	Tenant string   `json:"tenant"`
+	Page   int      `json:"page"`
+	Sort   []string `json:"sort"`
+	Name   string   `json:"name"`
+}
+
+func (response jsonTestResponse) StatusCode() int {
+	return http.StatusCreated
+}
+
+func (request jsonTestRequest) Validate() error {
+	if request.Name == "forbidden" {
+		return errors.ArgumentInvalid.With("name", request.Name)
+	}
+	return nil
+}
+
+func (suite *ServerSuite) TestCanHandleTypedJSONRequests() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRoute(http.MethodPost, "/groups/{group}/users", JSON(func(context context.Context, request jsonTestRequest) (jsonTestResponse, error) {
+		if request.Name == "missing" {
+			return jsonTestResponse{}, errors.NotFound.With("user", request.Name)
+		}
+		suite.Require().NotNil(RequestFromContext(context))
+		ResponseHeader(context).Set("X-Handled", "yes")
+		return jsonTestResponse{Group: request.Group, Tenant: request.Tenant, Page: request.Page, Sort: request.Sort, Name: request.Name}, nil
+	}), WithName("users.create"))
+	server.AddRoute(http.MethodDelete, "/users/{id}", JSON(func(context context.Context, request struct {
+		ID int `path:"id"`
+	}) (NoContent, error) {
+		return NoContent{}, nil
+	}))
+
+	send := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for index := 0; index+1 < len(headers); index += 2 {
+			req.Header.Set(headers[index], headers[index+1])
+		}
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+	fieldErrors := func(res *httptest.ResponseRecorder) []FieldError {
+		var problem struct {
+			Errors []FieldError `json:"errors"`
+		}
+		suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &problem))
+		return problem.Errors
+	}
+
+	res := send(http.MethodPost, "/groups/admins/users?page=2&sort=name&sort=-age", `{"name":"john","items":[{"quantity":1}]}`, "X-Tenant", "acme")
+	suite.Require().Equal(http.StatusCreated, res.Code, res.Body.String())
+	suite.Assert().Equal("application/json", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("yes", res.Header().Get("X-Handled"))
+	var response jsonTestResponse
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &response))
+	suite.Assert().Equal(jsonTestResponse{Group: "admins", Tenant: "acme", Page: 2, Sort: []string{"name", "-age"}, Name: "john"}, response)
+
+	res = send(http.MethodPost, "/groups/admins/users?page=two", `{"name":"john"}`, "X-Tenant", "acme")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code)
+	suite.Assert().Equal([]FieldError{{Field: "page", In: "query", Message: `must be an integer, not "two"`}}, fieldErrors(res))
+
+	res = send(http.MethodPost, "/groups/admins/users", `{"name":12}`, "X-Tenant", "acme")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code)
+	suite.Assert().Equal([]FieldError{{Field: "name", In: "body", Message: "must be a string, not number"}}, fieldErrors(res))
+
+	res = send(http.MethodPost, "/groups/admins/users", `{"name":`, "X-Tenant", "acme")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code)
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	res = send(http.MethodPost, "/groups/admins/users?page=-1", `{"name":"johnathan smith","email":"john","role":"root","items":[{"quantity":1},{"quantity":0}]}`)
+	suite.Assert().Equal(http.StatusUnprocessableEntity, res.Code)
+	suite.Assert().Equal([]FieldError{
+		{Field: "X-Tenant", In: "header", Message: "is required"},
+		{Field: "page", In: "query", Message: "must be at least 1"},
+		{Field: "name", In: "body", Message: "must have a length at most 8"},
+		{Field: "email", In: "body", Message: "must be an email address"},
+		{Field: "role", In: "body", Message: "must be one of admin, user"},
+		{Field: "items[1].quantity", In: "body", Message: "must be at least 1"},
+	}, fieldErrors(res))
+
+	res = send(http.MethodPost, "/groups/admins/users", `{"name":"forbidden"}`, "X-Tenant", "acme")
+	suite.Assert().Equal(http.StatusUnprocessableEntity, res.Code, "The Validator should be called")
+
+	res = send(http.MethodPost, "/groups/admins/users", `{"name":"missing"}`, "X-Tenant", "acme")
+	suite.Assert().Equal(http.StatusNotFound, res.Code, "The handler's errors should be written with WriteError")
+
+	res = send(http.MethodPost, "/groups/admins/users", `{"name":"john"}`, "X-Tenant", "acme", "Accept", "image/png")
+	suite.Assert().Equal(http.StatusNotAcceptable, res.Code)
+
+	res = send(http.MethodDelete, "/users/12", "")
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+	suite.Assert().Empty(res.Body.String())
+
+	res = send(http.MethodDelete, "/users/abc", "")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code)
+
+	document := server.OpenAPIDocument(OpenAPIOptions{})
+	create := document["paths"].(map[string]map[string]any)["/groups/{group}/users"]["post"].(map[string]any)
+	suite.Assert().Contains(create, "requestBody", "The JSON handler should document its request body")
+	suite.Assert().Contains(create["responses"], "201")
+	suite.Assert().Contains(create["responses"], "422")
+	remove := document["paths"].(map[string]map[string]any)["/users/{id}"]["delete"].(map[string]any)
+	suite.Assert().NotContains(remove, "requestBody", "Requests bound from the path only have no body")
+	suite.Assert().Contains(remove["responses"], "204")
+}
+
+func (suite *ServerSuite) TestShouldPanicWithUnknownValidationRules() {
+	suite.Assert().Panics(func() {
+		JSON(func(context context.Context, request struct {
+			Name string `json:"name" validate:"required,unknown"`
+		}) (NoContent, error) {
+			return NoContent{}, nil
+		})
+	})
+}
+
+type jsonTestInvalidRule struct {
+	Age int `json:"age" validate:"min=abc"`
+}
+
+type jsonTestRulesRequest struct {
+	Age   *int     `json:"age" validate:"omitempty,min=18"`
+	Tags  []string `json:"tags" validate:"max=3"`
+	Email string   `json:"email" validate:"email"`
+	Role  string   `json:"role" validate:"oneof=admin user"`
+}
+
+func (suite *ServerSuite) TestShouldPanicWithInvalidValidationRules() {
+	handler := func(context context.Context, request jsonTestRulesRequest) (NoContent, error) {
+		return NoContent{}, nil
+	}
+	suite.Assert().PanicsWithValue("wess: validation rule min=abc on wess.jsonTestInvalidRule.Age needs a number", func() {
+		JSON(func(context context.Context, request jsonTestInvalidRule) (NoContent, error) {
+			return NoContent{}, nil
+		})
+	})
+	suite.Assert().Panics(func() {
+		JSON(func(context context.Context, request struct {
+			Active bool `json:"active" validate:"max=1"`
+		}) (NoContent, error) {
+			return NoContent{}, nil
+		})
+	}, "min and max cannot be applied to bools")
+	suite.Assert().Panics(func() {
+		JSON(func(context context.Context, request struct {
+			Address struct{ City string } `json:"address" validate:"min=1"`
+		}) (NoContent, error) {
+			return NoContent{}, nil
+		})
+	}, "min and max cannot be applied to structs")
+	suite.Assert().Panics(func() {
+		JSON(func(context context.Context, request struct {
+			Count *int `json:"count" validate:"email"`
+		}) (NoContent, error) {
+			return NoContent{}, nil
+		})
+	}, "email can only be applied to strings")
+	suite.Assert().Panics(func() {
+		JSON(func(context context.Context, request struct {
+			Role string `json:"role" validate:"oneof="`
+		}) (NoContent, error) {
+			return NoContent{}, nil
+		})
+	})
+	suite.Assert().NotPanics(func() { JSON(handler) })
+}
+
+func (suite *ServerSuite) TestShouldNotBindRequestFieldsFromBody() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRouteWithFunc(http.MethodPost, "/groups/{group}/users", func(w http.ResponseWriter, r *http.Request) {
+		request := jsonTestRequest{Sort: []string{"name"}}
+		if err := Bind(r, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"group": request.Group, "tenant": request.Tenant, "sort": request.Sort, "name": request.Name})
+	})
+	req := httptest.NewRequest(http.MethodPost, "/groups/users/users", strings.NewReader(`{"Tenant":"victim","Group":"admins","Sort":["-age"],"name":"john"}`))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusOK, res.Code, res.Body.String())
+	suite.Assert().JSONEq(`{"group":"users","tenant":"","sort":["name"],"name":"john"}`, res.Body.String(), "The fields bound from the request should not be set by the body")
+}
@@ -0,0 +1,302 @@
+package wess
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+)
+
+// TimeoutMiddleware gives the handlers a deadline
+//
+// The deadline is propagated through the request context.
+// If the handler has not written its response when the deadline expires,
+// the client gets a 503 Service Unavailable Problem and later writes from the handler fail with http.ErrHandlerTimeout.
+// Handlers that return the context error through HandlerFuncWithError get a 504 Gateway Timeout.
+//
+// The read and write deadlines of the connection are extended to the handler deadline,
+// so a route can take longer than the server's ReadTimeout and WriteTimeout.
+// Connections hijacked before the deadline (WebSocket, upgrades) are not subject to it,
+// but the request context is still canceled.
+//
+// It can be used on subrouters with Use, or on routes (See WithTimeout).
+func TimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("timeout", "timeout")
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			deadline, _ := ctx.Deadline()
+			controller := http.NewResponseController(w)
+			_ = controller.SetReadDeadline(deadline)
+			_ = controller.SetWriteDeadline(deadline.Add(time.Second)) // leave some time to write the timeout response
+
+			writer := &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if recovered := recover(); recovered != nil {
+						panicked <- recovered
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(writer, r)
+			}()
+
+			select {
+			case recovered := <-panicked:
+				panic(recovered) // let the RecoveryMiddleware handle it
+			case <-done:
+				return
+			case <-ctx.Done():
+				writer.mutex.Lock()
+				if writer.hijacked {
+					// The timeout does not apply to the hijacked connections
+					writer.mutex.Unlock()
+					select {
+					case recovered := <-panicked:
+						panic(recovered)
+					case <-done:
+					}
+					return
+				}
+				defer writer.mutex.Unlock()
+				writer.timedOut = true
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return // the client went away
+				}
+				log.Warnf("Request %s %s timed out after %s", r.Method, r.URL.Path, timeout)
+				if !writer.wroteHeader {
+					WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, "The request timed out"))
+				}
+			}
+		})
+	}
+}
+
+// WithTimeout gives the handler of a route a deadline (See TimeoutMiddleware)
+func WithTimeout(timeout time.Duration) RouteOption {
+	return func(config *routeConfig) {
+		WithMiddleware(TimeoutMiddleware(timeout))(config)
+		config.metadata.Timeout = timeout
+	}
+}
+
+// MaxBodySizeMiddleware limits the size of the request bodies
+//
+// Requests with a Content-Length over the limit get a 413 Content Too Large Problem right away.
+// Otherwise, the body is read through http.MaxBytesReader and reading past the limit fails with an *http.MaxBytesError,
+// which HandlerFuncWithError handlers can return to get a 413.
+//
+// It can be used globally or on subrouters with Use, or on routes (See WithMaxBodySize).
+func MaxBodySizeMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("limits", "maxbodysize")
+				log.Warnf("Request body too large: %d bytes (limit: %d bytes)", r.ContentLength, limit)
+				WriteProblem(w, r, NewProblem(http.StatusRequestEntityTooLarge, "The request body is larger than "+strconv.FormatInt(limit, 10)+" bytes").With("limit", limit))
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithMaxBodySize limits the size of the request body of a route (See MaxBodySizeMiddleware)
+func WithMaxBodySize(limit int64) RouteOption {
+	return WithMiddleware(MaxBodySizeMiddleware(limit))
+}
+
+// MinUploadRateMiddleware requires the clients to send their request body at a minimum rate
+//
+// The rate is measured after a grace period, during which clients can send nothing.
+// When the client is too slow, reading the body fails with an errors.HTTPStatusRequestTimeout,
+// which HandlerFuncWithError handlers can return to get a 408 Request Timeout.
+//
+// The read deadline of the connection is moved as the body is read,
+// so slow clients cannot hold a connection forever and fast uploads are not cut by the server's ReadTimeout.
+//
+// It can be used globally or on subrouters with Use, or on routes (See WithMinUploadRate).
+func MinUploadRateMiddleware(bytesPerSecond int64, grace time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if bytesPerSecond <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			controller := http.NewResponseController(w)
+			r.Body = &minRateReader{
+				ReadCloser: r.Body,
+				rate:       float64(bytesPerSecond),
+				grace:      grace,
+				start:      time.Now(),
+				controller: controller,
+			}
+			defer func() { _ = controller.SetReadDeadline(time.Time{}) }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithMinUploadRate requires the clients of a route to send their request body at a minimum rate (See MinUploadRateMiddleware)
+func WithMinUploadRate(bytesPerSecond int64, grace time.Duration) RouteOption {
+	return WithMiddleware(MinUploadRateMiddleware(bytesPerSecond, grace))
+}
+
+// minRateReader fails when the body is read slower than the given rate
+type minRateReader struct {
+	io.ReadCloser
+	rate       float64 // bytes per second
+	grace      time.Duration
+	start      time.Time
+	read       int64
+	controller *http.ResponseController
+}
+
+// Read reads from the body and checks the upload rate
+//
+// implements io.Reader
+func (reader *minRateReader) Read(p []byte) (int, error) {
+	// The next byte must arrive before the average rate falls under the minimum
+	_ = reader.controller.SetReadDeadline(reader.deadline(reader.read + 1))
+	n, err := reader.ReadCloser.Read(p)
+	reader.read += int64(n)
+	if err != nil && err != io.EOF {
+		if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+			return n, errors.HTTPStatusRequestTimeout.Wrap(err)
+		}
+		return n, err
+	}
+	if time.Now().After(reader.deadline(reader.read)) {
+		return n, errors.HTTPStatusRequestTimeout.With("rate", strconv.FormatInt(int64(reader.rate), 10)+" bytes/s")
+	}
+	return n, err
+}
+
+// deadline gives the time by which the given number of bytes must have been read
+func (reader *minRateReader) deadline(bytes int64) time.Time {
+	return reader.start.Add(reader.grace + time.Duration(math.Ceil(float64(bytes)/reader.rate*float64(time.Second))))
+}
+
+// timeoutWriter is a ResponseWriter that stops writing once the handler timed out
+type timeoutWriter struct {
+	http.ResponseWriter
+	mutex       sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+	hijacked    bool
+}
+
+// Header returns the header map of the handler
+//
+// The headers are copied to the response when the handler writes its header.
+//
+// implements http.ResponseWriter
+func (writer *timeoutWriter) Header() http.Header {
+	return writer.header
+}
+
+// WriteHeader writes the header, unless the handler timed out
+//
+// implements http.ResponseWriter
+func (writer *timeoutWriter) WriteHeader(statusCode int) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.timedOut || writer.wroteHeader {
+		return
+	}
+	writer.writeHeader(statusCode)
+}
+
+// Write writes the data, unless the handler timed out
+//
+// implements http.ResponseWriter
+func (writer *timeoutWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !writer.wroteHeader {
+		writer.writeHeader(http.StatusOK)
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// Flush flushes the response, unless the handler timed out
+//
+// implements http.Flusher
+func (writer *timeoutWriter) Flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.timedOut {
+		return
+	}
+	if !writer.wroteHeader {
+		writer.writeHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection, unless the handler timed out
+//
+// The deadlines of the connection are cleared, the timeout does not apply to the hijacked connection.
+//
+// implements http.Hijacker
+func (writer *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, buffer, err := http.NewResponseController(writer.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, errors.NotImplemented.Wrap(err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	writer.wroteHeader, writer.hijacked = true, true
+	return conn, buffer, nil
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (writer *timeoutWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// writeHeader copies the headers of the handler and writes the status, the caller must hold the lock
+func (writer *timeoutWriter) writeHeader(statusCode int) {
+	header := writer.ResponseWriter.Header()
+	for key, values := range writer.header {
+		header[key] = values
+	}
+	writer.wroteHeader = true
+	writer.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,167 @@
+package wess
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+func (suite *ServerSuite) TestCanTimeoutRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	lateWrite := make(chan error, 1)
+	server.AddRouteWithFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}, WithTimeout(50*time.Millisecond))
+	server.AddRouteWithFunc(http.MethodGet, "/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "fast")
+		w.WriteHeader(http.StatusCreated)
+	}, WithTimeout(time.Second))
+	server.AddRoute(http.MethodGet, "/deadline", HandlerFuncWithError(func(w http.ResponseWriter, r *http.Request) error {
+		deadline, ok := r.Context().Deadline()
+		suite.Assert().True(ok, "The request context should have a deadline")
+		suite.Assert().WithinDuration(time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+		return context.DeadlineExceeded
+	}), WithTimeout(time.Second))
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	suite.Assert().Equal(http.StatusServiceUnavailable, res.Code)
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+	suite.Assert().ErrorIs(<-lateWrite, http.ErrHandlerTimeout)
+	suite.Assert().NotContains(res.Body.String(), "too late")
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	suite.Assert().Equal(http.StatusCreated, res.Code)
+	suite.Assert().Equal("fast", res.Header().Get("X-Test"))
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/deadline", nil))
+	suite.Assert().Equal(http.StatusGatewayTimeout, res.Code)
+}
+
+func (suite *ServerSuite) TestShouldRecoverPanicsInTimedOutRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRouteWithFunc(http.MethodGet, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}, WithTimeout(time.Second))
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	suite.Assert().Equal(http.StatusInternalServerError, res.Code)
+}
+
+func (suite *ServerSuite) TestCanHijackConnectionsOfTimedOutRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	lateHijack := make(chan error, 1)
+	server.AddRouteWithFunc(http.MethodGet, "/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		conn, buffer, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		_ = buffer.Flush()
+		time.Sleep(100 * time.Millisecond)
+		_, _ = conn.Write([]byte("late"))
+	}, WithTimeout(50*time.Millisecond))
+	server.AddRouteWithFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+		_, _, err := http.NewResponseController(w).Hijack()
+		lateHijack <- err
+	}, WithTimeout(50*time.Millisecond))
+	front := httptest.NewServer(server.webserver.Handler)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	suite.Require().NoError(err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: www.acme.com\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n"))
+	suite.Require().NoError(err)
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusSwitchingProtocols, res.StatusCode)
+	late := make([]byte, 4)
+	_, err = io.ReadFull(reader, late)
+	suite.Require().NoError(err, "The timeout should not apply to the hijacked connection")
+	suite.Assert().Equal("late", string(late))
+
+	response, err := http.Get(front.URL + "/slow")
+	suite.Require().NoError(err)
+	_ = response.Body.Close()
+	suite.Assert().Equal(http.StatusServiceUnavailable, response.StatusCode)
+	suite.Assert().ErrorIs(<-lateHijack, http.ErrHandlerTimeout, "The connection should not be hijacked after the timeout")
+}
+
+func (suite *ServerSuite) TestCanLimitBodySize() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRoute(http.MethodPost, "/upload", HandlerFuncWithError(func(w http.ResponseWriter, r *http.Request) error {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}), WithMaxBodySize(10))
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("small")))
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("this body is too large")))
+	suite.Assert().Equal(http.StatusRequestEntityTooLarge, res.Code)
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	// Without Content-Length, the limit is enforced while reading
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(strings.NewReader("this body is too large")))
+	req.ContentLength = -1
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusRequestEntityTooLarge, res.Code)
+}
+
+// slowReader sends one byte every delay
+type slowReader struct {
+	delay time.Duration
+	left  int
+}
+
+func (reader *slowReader) Read(p []byte) (int, error) {
+	if reader.left == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(reader.delay)
+	reader.left--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func (suite *ServerSuite) TestCanRequireMinimumUploadRate() {
+	var readErr error
+	handler := MinUploadRateMiddleware(100, 10*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("x", 1000)))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	suite.Assert().NoError(readErr)
+
+	req = httptest.NewRequest(http.MethodPost, "/upload", &slowReader{delay: 30 * time.Millisecond, left: 10})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	suite.Require().Error(readErr)
+	suite.Assert().ErrorIs(readErr, errors.HTTPStatusRequestTimeout)
+	suite.Assert().Equal(http.StatusRequestTimeout, ErrorStatus(readErr))
+}
@@ -0,0 +1,207 @@
+package wess
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gildas/go-logger"
+)
+
+// MetricType is the type of a metric
+type MetricType string
+
+const (
+	// CounterMetric is a metric that only goes up
+	CounterMetric MetricType = "counter"
+	// GaugeMetric is a metric that can go up and down
+	GaugeMetric MetricType = "gauge"
+)
+
+// Metrics collects the metrics of a server
+//
+// The metrics can be exposed in the Prometheus text format (See WriteTo),
+// they are served on the health probe routes at <HealthRootPath>/metrics.
+//
+// Labels are given as key, value pairs.
+type Metrics struct {
+	mutex    sync.RWMutex
+	families map[string]*metricFamily
+}
+
+// metricFamily contains all the series of a metric
+type metricFamily struct {
+	Name   string
+	Help   string
+	Type   MetricType
+	series map[string]*metricSeries
+}
+
+// metricSeries is a metric with a given set of labels
+type metricSeries struct {
+	labels string
+	value  float64
+}
+
+// NewMetrics creates a new Metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{families: map[string]*metricFamily{}}
+}
+
+// Describe sets the type and the help text of a metric
+func (metrics *Metrics) Describe(name string, metricType MetricType, help string) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	family := metrics.family(name, metricType)
+	family.Type = metricType
+	family.Help = help
+}
+
+// Inc increments a counter
+func (metrics *Metrics) Inc(name string, labels ...string) {
+	metrics.Add(name, 1, labels...)
+}
+
+// Add adds the given value to a counter
+func (metrics *Metrics) Add(name string, value float64, labels ...string) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.family(name, CounterMetric).get(labels).value += value
+}
+
+// Set sets the value of a gauge
+func (metrics *Metrics) Set(name string, value float64, labels ...string) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.family(name, GaugeMetric).get(labels).value = value
+}
+
+// Get gets the value of a metric
+//
+// returns 0 if the metric does not exist
+func (metrics *Metrics) Get(name string, labels ...string) float64 {
+	if metrics == nil {
+		return 0
+	}
+	metrics.mutex.RLock()
+	defer metrics.mutex.RUnlock()
+	if family, found := metrics.families[name]; found {
+		if series, found := family.series[formatLabels(labels)]; found {
+			return series.value
+		}
+	}
+	return 0
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+//
+// implements io.WriterTo
+func (metrics *Metrics) WriteTo(w io.Writer) (int64, error) {
+	metrics.mutex.RLock()
+	defer metrics.mutex.RUnlock()
+
+	names := make([]string, 0, len(metrics.families))
+	for name := range metrics.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output := strings.Builder{}
+	for _, name := range names {
+		family := metrics.families[name]
+		if len(family.series) == 0 {
+			continue
+		}
+		if len(family.Help) > 0 {
+			fmt.Fprintf(&output, "# HELP %s %s\n", name, strings.ReplaceAll(family.Help, "\n", " "))
+		}
+		fmt.Fprintf(&output, "# TYPE %s %s\n", name, family.Type)
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			fmt.Fprintf(&output, "%s%s %s\n", name, series.labels, formatMetricValue(series.value))
+		}
+	}
+	written, err := io.WriteString(w, output.String())
+	return int64(written), err
+}
+
+// family gets or creates the family of a metric, the caller must hold the lock
+func (metrics *Metrics) family(name string, metricType MetricType) *metricFamily {
+	family, found := metrics.families[name]
+	if !found {
+		family = &metricFamily{Name: name, Type: metricType, series: map[string]*metricSeries{}}
+		metrics.families[name] = family
+	}
+	return family
+}
+
+// get gets or creates the series of the given labels
+func (family *metricFamily) get(labels []string) *metricSeries {
+	key := formatLabels(labels)
+	series, found := family.series[key]
+	if !found {
+		series = &metricSeries{labels: key}
+		family.series[key] = series
+	}
+	return series
+}
+
+// labelValueEscaper escapes label values for the Prometheus text format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats key, value pairs as Prometheus labels
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for index := 0; index+1 < len(labels); index += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[index], labelValueEscaper.Replace(labels[index+1])))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatMetricValue formats a value for the Prometheus text format
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func metricsHandler(metrics *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("metrics", "metrics")
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := metrics.WriteTo(w); err != nil {
+			log.Errorf("Failed to write the metrics", err)
+		}
+	})
+}
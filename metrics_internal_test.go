@@ -0,0 +1,47 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *ServerSuite) TestCanWriteMetrics() {
+	metrics := NewMetrics()
+	metrics.Describe("test_requests_total", CounterMetric, "Number of requests")
+	metrics.Inc("test_requests_total", "path", "/a")
+	metrics.Add("test_requests_total", 2, "path", "/b\"")
+	metrics.Set("test_inflight", 3)
+	suite.Assert().Equal(float64(1), metrics.Get("test_requests_total", "path", "/a"))
+	suite.Assert().Equal(float64(0), metrics.Get("test_unknown"))
+
+	output := strings.Builder{}
+	_, err := metrics.WriteTo(&output)
+	suite.Require().NoError(err)
+	expected := `# TYPE test_inflight gauge
+test_inflight 3
+# HELP test_requests_total Number of requests
+# TYPE test_requests_total counter
+test_requests_total{path="/a"} 1
+test_requests_total{path="/b\""} 2
+`
+	suite.Assert().Equal(expected, output.String())
+}
+
+func (suite *ServerSuite) TestCanServeMetrics() {
+	metrics := NewMetrics()
+	metrics.Inc("test_total")
+	res := httptest.NewRecorder()
+	metricsHandler(metrics).ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz/metrics", nil))
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Contains(res.Header().Get("Content-Type"), "version=0.0.4")
+	suite.Assert().Equal("# TYPE test_total counter\ntest_total 1\n", res.Body.String())
+}
+
+func (suite *ServerSuite) TestShouldNotServeMetricsOnWebPort() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, ProbePort: 80})
+	server.healthRoutes(server.proberouter)
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz/metrics", nil))
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+}
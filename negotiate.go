@@ -0,0 +1,129 @@
+package wess
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptedValue is a value of an Accept-like header with its quality
+type acceptedValue struct {
+	Value   string
+	Quality float64
+}
+
+// parseAccept parses an Accept-like header (Accept, Accept-Encoding, ...)
+//
+// The values are sorted by decreasing quality, then by their order in the header.
+// Values with a quality of 0 are kept, so callers can tell they are refused.
+func parseAccept(header string) []acceptedValue {
+	values := []acceptedValue{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		value := acceptedValue{Quality: 1.0}
+		params := strings.Split(part, ";")
+		value.Value = strings.ToLower(strings.TrimSpace(params[0]))
+		for _, param := range params[1:] {
+			key, raw, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.TrimSpace(strings.ToLower(key)) != "q" {
+				continue
+			}
+			if quality, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil && quality >= 0 && quality <= 1 {
+				value.Quality = quality
+			}
+		}
+		values = append(values, value)
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i].Quality != values[j].Quality {
+			return values[i].Quality > values[j].Quality
+		}
+		return mediaTypeSpecificity(values[i].Value) > mediaTypeSpecificity(values[j].Value)
+	})
+	return values
+}
+
+// mediaTypeSpecificity tells how specific a media range is (*/* < type/* < type/subtype)
+func mediaTypeSpecificity(mediaRange string) int {
+	switch {
+	case mediaRange == "*/*" || mediaRange == "*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// mediaTypeMatches tells if the given media type matches the media range
+func mediaTypeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == "*" || mediaRange == mediaType {
+		return true
+	}
+	if prefix, found := strings.CutSuffix(mediaRange, "/*"); found {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return false
+}
+
+// negotiateContentType finds the best offer for the given Accept header
+//
+// If the header is empty, the first offer is returned.
+// If no offer is acceptable, an empty string is returned.
+func negotiateContentType(accept string, offers ...string) string {
+	if acceptable := negotiateContentTypes(accept, offers...); len(acceptable) > 0 {
+		return acceptable[0]
+	}
+	return ""
+}
+
+// negotiateContentTypes gives the acceptable offers for the given Accept header, the best first
+//
+// If the header is empty, all the offers are acceptable, in their order.
+func negotiateContentTypes(accept string, offers ...string) []string {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offers
+	}
+	type candidate struct {
+		offer       string
+		quality     float64
+		specificity int
+	}
+	accepted := parseAccept(accept)
+	candidates := make([]candidate, 0, len(offers))
+	for _, offer := range offers {
+		if quality, specificity := offerQuality(accepted, offer); quality > 0 {
+			candidates = append(candidates, candidate{offer, quality, specificity})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].quality != candidates[j].quality {
+			return candidates[i].quality > candidates[j].quality
+		}
+		return candidates[i].specificity > candidates[j].specificity
+	})
+	acceptable := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		acceptable = append(acceptable, candidate.offer)
+	}
+	return acceptable
+}
+
+// offerQuality gives the quality of an offer given the accepted values
+//
+// The most specific accepted value that matches the offer wins.
+func offerQuality(accepted []acceptedValue, offer string) (quality float64, specificity int) {
+	specificity = -1
+	for _, value := range accepted {
+		if !mediaTypeMatches(value.Value, offer) {
+			continue
+		}
+		if current := mediaTypeSpecificity(value.Value); current > specificity {
+			quality, specificity = value.Quality, current
+		}
+	}
+	return
+}
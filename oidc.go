@@ -0,0 +1,595 @@
+package wess
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+)
+
+// OIDCOptions defines the options of the OpenID Connect relying party
+type OIDCOptions struct {
+	// Issuer is the URL of the OpenID Provider,
+	// its configuration is discovered at <Issuer>/.well-known/openid-configuration
+	Issuer string
+
+	// ClientID is the client identifier registered with the provider
+	ClientID string
+
+	// ClientSecret is the client secret registered with the provider, if any
+	ClientSecret string
+
+	// RedirectURL is the callback URL registered with the provider.
+	// Default: the CallbackPath on the host of the request
+	RedirectURL string
+
+	// Scopes are the scopes requested to the provider.
+	// Default: openid, profile, email
+	Scopes []string
+
+	// LoginPath is the path that starts the login flow, it accepts a return_to query parameter.
+	// Default: "/auth/login"
+	LoginPath string
+
+	// CallbackPath is the path the provider redirects to after the login.
+	// Default: "/auth/callback"
+	CallbackPath string
+
+	// LogoutPath is the path that ends the session.
+	// Default: "/auth/logout"
+	LogoutPath string
+
+	// PostLogoutRedirectURL is where the client goes after the logout.
+	// Default: "/"
+	PostLogoutRedirectURL string
+
+	// CookieName is the name of the session cookie.
+	// Default: "wess_oidc"
+	CookieName string
+
+	// CookieKeys are the AES keys (16, 24 or 32 bytes) used to encrypt the session cookie.
+	// The first key encrypts, all keys decrypt, so keys can be rotated.
+	// If empty, a random key is generated and the sessions do not survive a restart.
+	CookieKeys [][]byte
+
+	// Store keeps the sessions, and their tokens, on the server (See MemorySessionStore and FileSessionStore).
+	// The session cookie then only carries the reference given by the store.
+	// Default: the session is kept in the encrypted cookie, which fails when it exceeds 4KB
+	Store SessionStore
+
+	// SessionDuration is the maximum duration of a session.
+	// Default: 8 hours
+	SessionDuration time.Duration
+
+	// Client is the HTTP client used to talk to the provider.
+	// Default: a client with a 10 seconds timeout
+	Client *http.Client
+}
+
+// OIDC is an OpenID Connect relying party
+//
+// It logs users in with the authorization code flow and PKCE,
+// and keeps their session in an encrypted cookie or in a SessionStore.
+type OIDC struct {
+	options   OIDCOptions
+	codec     *cookieCodec
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	verifier  *JWTAuthenticator
+}
+
+// oidcDiscovery is the configuration of the OpenID Provider
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcSession is the content of the session cookie, or of the stored session
+type oidcSession struct {
+	Subject      string         `json:"sub"`
+	Claims       map[string]any `json:"claims,omitempty"`
+	Scopes       []string       `json:"scopes,omitempty"`
+	AccessToken  string         `json:"at,omitempty"`
+	RefreshToken string         `json:"rt,omitempty"`
+	TokenExpiry  time.Time      `json:"tx,omitempty"`
+	Expires      time.Time      `json:"x"`
+	stored       *Session       // the session of the store, if any
+}
+
+// oidcStoreKey is the key of the OIDC session in the sessions of the store
+const oidcStoreKey = "oidc"
+
+// oidcLoginState is the content of the state cookie, kept during the login flow
+type oidcLoginState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	ReturnTo string `json:"r"`
+}
+
+// oidcTokens is the response of the token endpoint
+type oidcTokens struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+}
+
+// oidcSessionContextKey is the context key of the OIDC session
+type oidcSessionContextKey struct{}
+
+// NewOIDC creates a new OpenID Connect relying party
+//
+// The provider configuration is discovered on first use.
+func NewOIDC(options OIDCOptions) (*OIDC, error) {
+	if len(options.Issuer) == 0 {
+		return nil, errors.ArgumentMissing.With("Issuer")
+	}
+	if len(options.ClientID) == 0 {
+		return nil, errors.ArgumentMissing.With("ClientID")
+	}
+	options.Issuer = strings.TrimRight(options.Issuer, "/")
+	if len(options.Scopes) == 0 {
+		options.Scopes = []string{"openid", "profile", "email"}
+	}
+	if len(options.LoginPath) == 0 {
+		options.LoginPath = "/auth/login"
+	}
+	if len(options.CallbackPath) == 0 {
+		options.CallbackPath = "/auth/callback"
+	}
+	if len(options.LogoutPath) == 0 {
+		options.LogoutPath = "/auth/logout"
+	}
+	if len(options.PostLogoutRedirectURL) == 0 {
+		options.PostLogoutRedirectURL = "/"
+	}
+	if len(options.CookieName) == 0 {
+		options.CookieName = "wess_oidc"
+	}
+	if options.SessionDuration <= 0 {
+		options.SessionDuration = 8 * time.Hour
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	codec, err := newCookieCodec(options.CookieKeys...)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDC{options: options, codec: codec}, nil
+}
+
+// AddOIDC adds the login, callback and logout routes of an OpenID Connect relying party to the server
+//
+// The logout route only accepts POST, so other sites cannot log users out with a link.
+//
+// Use the returned OIDC to protect the frontend and the API routes (See OIDC.Middleware and WithOIDC).
+func (server Server) AddOIDC(options OIDCOptions) (*OIDC, error) {
+	oidc, err := NewOIDC(options)
+	if err != nil {
+		return nil, err
+	}
+	server.AddRouteWithFunc(http.MethodGet, oidc.options.LoginPath, oidc.login)
+	server.AddRouteWithFunc(http.MethodGet, oidc.options.CallbackPath, oidc.callback)
+	server.AddRouteWithFunc(http.MethodPost, oidc.options.LogoutPath, oidc.logout)
+	return oidc, nil
+}
+
+// Middleware requires the requests to have a valid session
+//
+// The Principal of the session is stored in the request context (See GetPrincipal),
+// the access token is refreshed when it expires.
+//
+// Without a session, browser navigations are redirected to the login route,
+// other requests get a 401 Unauthorized Problem.
+func (oidc *OIDC) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("oidc", "session")
+			session, err := oidc.session(w, r)
+			if err != nil {
+				log.Debugf("No valid session: %s", err)
+				if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+					http.Redirect(w, r, oidc.options.LoginPath+"?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `OIDC login="`+oidc.options.LoginPath+`"`)
+				WriteProblem(w, r, NewProblem(http.StatusUnauthorized, "Authentication required"))
+				return
+			}
+			principal := &Principal{Subject: session.Subject, Method: "oidc", Scopes: session.Scopes, Claims: session.Claims}
+			ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+			ctx = context.WithValue(ctx, oidcSessionContextKey{}, session)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithOIDC requires the requests of a route to have a valid session (See OIDC.Middleware)
+func WithOIDC(oidc *OIDC) RouteOption {
+	return func(config *routeConfig) {
+		WithMiddleware(oidc.Middleware())(config)
+		config.metadata.AuthenticationRequired = true
+		if !slices.Contains(config.metadata.Authentication, "OIDC") {
+			config.metadata.Authentication = append(config.metadata.Authentication, "OIDC")
+		}
+	}
+}
+
+// AccessToken gives the access token of the session of the request
+//
+// It can be used to call APIs on behalf of the user.
+//
+// returns an empty string if the request has no session
+func (oidc *OIDC) AccessToken(r *http.Request) string {
+	if session, ok := r.Context().Value(oidcSessionContextKey{}).(*oidcSession); ok {
+		return session.AccessToken
+	}
+	return ""
+}
+
+// session gives the session of the request, refreshing its tokens if needed
+func (oidc *OIDC) session(w http.ResponseWriter, r *http.Request) (*oidcSession, error) {
+	session, err := oidc.loadSession(r)
+	if err != nil {
+		return nil, err
+	}
+	if session.TokenExpiry.IsZero() || time.Now().Add(30*time.Second).Before(session.TokenExpiry) {
+		return session, nil
+	}
+	if len(session.RefreshToken) == 0 {
+		return session, nil // the session is valid, even if the access token is not
+	}
+	log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("oidc", "refresh")
+	discovery, err := oidc.discover(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := oidc.exchange(r.Context(), discovery, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {session.RefreshToken},
+	})
+	if err != nil {
+		log.Warnf("Failed to refresh the tokens of %s: %s", session.Subject, err)
+		oidc.deleteSession(w, r, session)
+		return nil, err
+	}
+	log.Debugf("Refreshed the tokens of %s", session.Subject)
+	session.AccessToken = tokens.AccessToken
+	if len(tokens.RefreshToken) > 0 {
+		session.RefreshToken = tokens.RefreshToken
+	}
+	session.TokenExpiry = tokenExpiry(tokens)
+	if len(tokens.Scope) > 0 {
+		session.Scopes = strings.Fields(tokens.Scope)
+	}
+	if err := oidc.saveSession(w, r, session); err != nil {
+		log.Errorf("Failed to save the session of %s", session.Subject, err)
+		return nil, err
+	}
+	return session, nil
+}
+
+// login starts the authorization code flow
+func (oidc *OIDC) login(w http.ResponseWriter, r *http.Request) {
+	log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("oidc", "login")
+	discovery, err := oidc.discover(r.Context())
+	if err != nil {
+		log.Errorf("Failed to discover the OpenID Provider", err)
+		WriteProblem(w, r, NewProblem(http.StatusBadGateway, "The identity provider is not available"))
+		return
+	}
+	state := oidcLoginState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+	}
+	cookie := http.Cookie{Name: oidc.options.CookieName + "_state", Path: oidc.options.CallbackPath, SameSite: http.SameSiteLaxMode}
+	if err := oidc.codec.setCookie(w, r, cookie, state, time.Now().Add(10*time.Minute)); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.options.ClientID},
+		"redirect_uri":          {oidc.redirectURL(r)},
+		"scope":                 {strings.Join(oidc.options.Scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, discovery.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// callback exchanges the authorization code for tokens and creates the session
+func (oidc *OIDC) callback(w http.ResponseWriter, r *http.Request) {
+	log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("oidc", "callback")
+	stateCookie := oidc.options.CookieName + "_state"
+	state := oidcLoginState{}
+	if err := oidc.codec.readCookie(r, stateCookie, &state); err != nil {
+		log.Warnf("Missing or invalid login state: %s", err)
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, "The login session is missing or expired"))
+		return
+	}
+	clearCookie(w, stateCookie, oidc.options.CallbackPath)
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		log.Warnf("Invalid login state")
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, "Invalid login state"))
+		return
+	}
+	if providerError := query.Get("error"); len(providerError) > 0 {
+		log.Warnf("The provider refused the login: %s %s", providerError, query.Get("error_description"))
+		WriteProblem(w, r, NewProblem(http.StatusUnauthorized, "The login was refused").With("error", providerError))
+		return
+	}
+	discovery, err := oidc.discover(r.Context())
+	if err != nil {
+		log.Errorf("Failed to discover the OpenID Provider", err)
+		WriteProblem(w, r, NewProblem(http.StatusBadGateway, "The identity provider is not available"))
+		return
+	}
+	tokens, err := oidc.exchange(r.Context(), discovery, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {oidc.redirectURL(r)},
+		"code_verifier": {state.Verifier},
+	})
+	if err != nil {
+		log.Errorf("Failed to exchange the authorization code", err)
+		WriteProblem(w, r, NewProblem(http.StatusBadGateway, "The identity provider did not issue tokens"))
+		return
+	}
+	claims, err := oidc.verifier.Verify(r, tokens.IDToken)
+	if err != nil {
+		log.Warnf("Invalid ID Token: %s", err)
+		WriteProblem(w, r, NewProblem(http.StatusUnauthorized, "Invalid ID Token"))
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(nonce), []byte(state.Nonce)) != 1 {
+		log.Warnf("Invalid ID Token nonce")
+		WriteProblem(w, r, NewProblem(http.StatusUnauthorized, "Invalid ID Token"))
+		return
+	}
+
+	session := &oidcSession{
+		Claims:       map[string]any{},
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenExpiry:  tokenExpiry(tokens),
+		Expires:      time.Now().Add(oidc.options.SessionDuration),
+	}
+	session.Subject, _ = claims["sub"].(string)
+	for _, name := range []string{"sub", "name", "preferred_username", "email", "email_verified", "groups", "roles"} {
+		if value, found := claims[name]; found {
+			session.Claims[name] = value
+		}
+	}
+	if len(tokens.Scope) > 0 {
+		session.Scopes = strings.Fields(tokens.Scope)
+	}
+	if err := oidc.saveSession(w, r, session); err != nil {
+		log.Errorf("Failed to save the session of %s, use a Store if the session is too large", session.Subject, err)
+		WriteProblem(w, r, NewProblem(http.StatusInternalServerError, "The session could not be saved"))
+		return
+	}
+	log.Infof("User %s logged in", session.Subject)
+	http.Redirect(w, r, state.ReturnTo, http.StatusFound)
+}
+
+// logout ends the session, and the provider session if the provider supports it
+func (oidc *OIDC) logout(w http.ResponseWriter, r *http.Request) {
+	log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("oidc", "logout")
+	if session, err := oidc.loadSession(r); err == nil {
+		log.Infof("User %s logged out", session.Subject)
+		oidc.deleteSession(w, r, session)
+	} else {
+		clearCookie(w, oidc.options.CookieName, "/")
+	}
+
+	redirect := oidc.options.PostLogoutRedirectURL
+	if discovery, err := oidc.discover(r.Context()); err == nil && len(discovery.EndSessionEndpoint) > 0 {
+		postLogout := redirect
+		if location, err := url.Parse(redirect); err == nil && !location.IsAbs() {
+			info := GetClientInfo(r)
+			postLogout = info.Scheme + "://" + info.Host + redirect
+		}
+		query := url.Values{"client_id": {oidc.options.ClientID}, "post_logout_redirect_uri": {postLogout}}
+		redirect = discovery.EndSessionEndpoint + "?" + query.Encode()
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// loadSession reads the session of the request, from the cookie or from the store
+func (oidc *OIDC) loadSession(r *http.Request) (*oidcSession, error) {
+	session := &oidcSession{}
+	if oidc.options.Store == nil {
+		if err := oidc.codec.readCookie(r, oidc.options.CookieName, session); err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+	var reference string
+	if err := oidc.codec.readCookie(r, oidc.options.CookieName, &reference); err != nil {
+		return nil, err
+	}
+	stored, err := oidc.options.Store.Load(r.Context(), reference)
+	if err != nil {
+		return nil, err
+	}
+	value, found := stored.Get(oidcStoreKey)
+	if !found {
+		return nil, errors.NotFound.With("session", oidcStoreKey)
+	}
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.JSONMarshalError.Wrap(err)
+	}
+	if err := json.Unmarshal(payload, session); err != nil {
+		return nil, errors.JSONUnmarshalError.Wrap(err)
+	}
+	session.stored = stored
+	return session, nil
+}
+
+// saveSession writes the session in the cookie, or in the store
+//
+// Without a store, the session cookie fails to be written if it is larger than what browsers accept.
+func (oidc *OIDC) saveSession(w http.ResponseWriter, r *http.Request, session *oidcSession) error {
+	if oidc.options.Store == nil {
+		return oidc.codec.setCookie(w, r, oidc.sessionCookie(), session, session.Expires)
+	}
+	if session.stored == nil {
+		session.stored = newSession()
+	}
+	session.stored.Set(oidcStoreKey, session)
+	reference, err := oidc.options.Store.Save(r.Context(), session.stored, session.Expires)
+	if err != nil {
+		return err
+	}
+	return oidc.codec.setCookie(w, r, oidc.sessionCookie(), reference, session.Expires)
+}
+
+// deleteSession removes the session from the store, if any, and from the client
+func (oidc *OIDC) deleteSession(w http.ResponseWriter, r *http.Request, session *oidcSession) {
+	if oidc.options.Store != nil && session.stored != nil {
+		if err := oidc.options.Store.Delete(r.Context(), session.stored.ID()); err != nil {
+			logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("oidc", "session").Warnf("Failed to delete the session of %s: %s", session.Subject, err)
+		}
+	}
+	clearCookie(w, oidc.options.CookieName, "/")
+}
+
+// discover fetches the provider configuration, once
+func (oidc *OIDC) discover(context context.Context) (*oidcDiscovery, error) {
+	oidc.mutex.Lock()
+	defer oidc.mutex.Unlock()
+	if oidc.discovery != nil {
+		return oidc.discovery, nil
+	}
+	req, err := http.NewRequestWithContext(context, http.MethodGet, oidc.options.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, errors.InvalidURL.With(oidc.options.Issuer)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := oidc.options.Client.Do(req)
+	if err != nil {
+		return nil, errors.RuntimeError.Wrap(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.FromHTTPStatusCode(res.StatusCode)
+	}
+	discovery := &oidcDiscovery{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(discovery); err != nil {
+		return nil, errors.JSONUnmarshalError.Wrap(err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != oidc.options.Issuer {
+		return nil, errors.Invalid.With("issuer", discovery.Issuer, oidc.options.Issuer)
+	}
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSURI) == 0 {
+		return nil, errors.ArgumentMissing.With("openid-configuration endpoints")
+	}
+	jwks := NewJWKS(discovery.JWKSURI, time.Hour)
+	jwks.Client = oidc.options.Client
+	oidc.verifier = &JWTAuthenticator{
+		Issuer:   discovery.Issuer,
+		Audience: oidc.options.ClientID,
+		JWKS:     jwks,
+		Leeway:   time.Minute,
+	}
+	if len(oidc.options.ClientSecret) > 0 {
+		oidc.verifier.Secret = []byte(oidc.options.ClientSecret)
+	}
+	oidc.discovery = discovery
+	return discovery, nil
+}
+
+// exchange calls the token endpoint
+func (oidc *OIDC) exchange(context context.Context, discovery *oidcDiscovery, form url.Values) (*oidcTokens, error) {
+	form.Set("client_id", oidc.options.ClientID)
+	req, err := http.NewRequestWithContext(context, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.InvalidURL.With(discovery.TokenEndpoint)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(oidc.options.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(oidc.options.ClientID), url.QueryEscape(oidc.options.ClientSecret))
+	}
+	res, err := oidc.options.Client.Do(req)
+	if err != nil {
+		return nil, errors.RuntimeError.Wrap(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.FromHTTPStatusCode(res.StatusCode)
+	}
+	tokens := &oidcTokens{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(tokens); err != nil {
+		return nil, errors.JSONUnmarshalError.Wrap(err)
+	}
+	return tokens, nil
+}
+
+// redirectURL gives the callback URL sent to the provider
+func (oidc *OIDC) redirectURL(r *http.Request) string {
+	if len(oidc.options.RedirectURL) > 0 {
+		return oidc.options.RedirectURL
+	}
+	info := GetClientInfo(r)
+	return info.Scheme + "://" + info.Host + oidc.options.CallbackPath
+}
+
+// sessionCookie gives the template of the session cookie
+func (oidc *OIDC) sessionCookie() http.Cookie {
+	return http.Cookie{Name: oidc.options.CookieName, Path: "/", SameSite: http.SameSiteLaxMode}
+}
+
+// tokenExpiry gives the expiration time of the access token
+func tokenExpiry(tokens *oidcTokens) time.Time {
+	if tokens.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+}
+
+// randomToken generates a random URL safe token
+func randomToken() string {
+	token := make([]byte, 32)
+	_, _ = rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// safeReturnTo makes sure the return URL stays on this server
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
@@ -0,0 +1,276 @@
+package wess
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mockOIDCProvider is a minimal OpenID Provider for the tests
+type mockOIDCProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	mutex     sync.Mutex
+	codes     map[string]url.Values // code -> authorization request
+	refreshes int32
+	expiresIn int64
+	claims    map[string]any // extra claims of the ID Token
+}
+
+// newMockOIDCProvider starts a mock OpenID Provider
+func newMockOIDCProvider() *mockOIDCProvider {
+	provider := &mockOIDCProvider{codes: map[string]url.Values{}, expiresIn: 3600}
+	provider.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.URL,
+			"authorization_endpoint": provider.URL + "/authorize",
+			"token_endpoint":         provider.URL + "/token",
+			"jwks_uri":               provider.URL + "/jwks",
+			"end_session_endpoint":   provider.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(value []byte) string { return base64.RawURLEncoding.EncodeToString(value) }
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "mock", "alg": "RS256",
+			"n": encode(provider.key.N.Bytes()), "e": encode(big.NewInt(int64(provider.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := randomToken()
+		provider.mutex.Lock()
+		provider.codes[code] = query
+		provider.mutex.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if clientID, secret, _ := r.BasicAuth(); clientID != "wess" || secret != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		response := map[string]any{"access_token": randomToken(), "token_type": "Bearer", "expires_in": provider.expiresIn, "scope": "openid profile"}
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			provider.mutex.Lock()
+			authorization, found := provider.codes[r.PostForm.Get("code")]
+			delete(provider.codes, r.PostForm.Get("code"))
+			provider.mutex.Unlock()
+			challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if !found || authorization.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) || authorization.Get("redirect_uri") != r.PostForm.Get("redirect_uri") {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			response["refresh_token"] = randomToken()
+			claims := map[string]any{
+				"iss": provider.URL, "aud": "wess", "sub": "john", "name": "John Doe",
+				"nonce": authorization.Get("nonce"), "exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+			}
+			for name, value := range provider.claims {
+				claims[name] = value
+			}
+			response["id_token"] = signTestJWT("RS256", "mock", provider.key, claims)
+		case "refresh_token":
+			atomic.AddInt32(&provider.refreshes, 1)
+			response["expires_in"] = 3600
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	provider.Server = httptest.NewServer(mux)
+	return provider
+}
+
+// oidcTestClient sends requests to the server, keeping the cookies
+type oidcTestClient struct {
+	server  *Server
+	cookies map[string]*http.Cookie
+}
+
+func (client *oidcTestClient) send(method, target string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+	for _, cookie := range client.cookies {
+		req.AddCookie(cookie)
+	}
+	res := httptest.NewRecorder()
+	client.server.webserver.Handler.ServeHTTP(res, req)
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(client.cookies, cookie.Name)
+		} else {
+			client.cookies[cookie.Name] = cookie
+		}
+	}
+	return res
+}
+
+func (suite *ServerSuite) TestCanLoginWithOIDC() {
+	provider := newMockOIDCProvider()
+	defer provider.Close()
+	provider.expiresIn = 1 // expires right away to test the refresh
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	oidc, err := server.AddOIDC(OIDCOptions{
+		Issuer:       provider.URL,
+		ClientID:     "wess",
+		ClientSecret: "secret",
+		CookieKeys:   [][]byte{[]byte("0123456789abcdef0123456789abcdef")},
+	})
+	suite.Require().NoError(err)
+	server.AddRouteWithFunc(http.MethodGet, "/api/me", func(w http.ResponseWriter, r *http.Request) {
+		suite.Assert().NotEmpty(oidc.AccessToken(r))
+		_, _ = w.Write([]byte(GetPrincipal(r).Subject + ":" + GetPrincipal(r).Claims["name"].(string)))
+	}, WithOIDC(oidc))
+	client := &oidcTestClient{server: server, cookies: map[string]*http.Cookie{}}
+
+	res := client.send(http.MethodGet, "/api/me", "application/json")
+	suite.Assert().Equal(http.StatusUnauthorized, res.Code)
+
+	res = client.send(http.MethodGet, "/api/me", "text/html")
+	suite.Require().Equal(http.StatusFound, res.Code)
+	suite.Assert().Equal("/auth/login?return_to=%2Fapi%2Fme", res.Header().Get("Location"))
+
+	res = client.send(http.MethodGet, res.Header().Get("Location"), "text/html")
+	suite.Require().Equal(http.StatusFound, res.Code)
+	authorize, err := url.Parse(res.Header().Get("Location"))
+	suite.Require().NoError(err)
+	suite.Assert().Equal(provider.URL+"/authorize", authorize.Scheme+"://"+authorize.Host+authorize.Path)
+	suite.Assert().Equal("S256", authorize.Query().Get("code_challenge_method"))
+	suite.Assert().Equal("http://example.com/auth/callback", authorize.Query().Get("redirect_uri"))
+
+	// The browser goes to the provider, which sends it back to the callback
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	providerResponse, err := noRedirect.Get(authorize.String())
+	suite.Require().NoError(err)
+	providerResponse.Body.Close()
+	callback, _ := url.Parse(providerResponse.Header.Get("Location"))
+
+	res = client.send(http.MethodGet, callback.RequestURI(), "text/html")
+	suite.Require().Equal(http.StatusFound, res.Code, "Callback failed: %s", res.Body.String())
+	suite.Assert().Equal("/api/me", res.Header().Get("Location"))
+	suite.Require().Contains(client.cookies, "wess_oidc")
+	suite.Assert().NotContains(client.cookies, "wess_oidc_state")
+
+	res = client.send(http.MethodGet, "/api/me", "application/json")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("john:John Doe", res.Body.String())
+	suite.Assert().Equal(int32(1), atomic.LoadInt32(&provider.refreshes), "The expired access token should be refreshed")
+
+	res = client.send(http.MethodGet, "/api/me", "application/json")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal(int32(1), atomic.LoadInt32(&provider.refreshes), "The refreshed access token should be kept")
+
+	res = client.send(http.MethodGet, "/auth/logout", "text/html")
+	suite.Assert().Equal(http.StatusNotFound, res.Code, "Links should not log users out")
+	suite.Assert().Contains(client.cookies, "wess_oidc")
+
+	res = client.send(http.MethodPost, "/auth/logout", "text/html")
+	suite.Assert().Equal(http.StatusFound, res.Code)
+	suite.Assert().True(strings.HasPrefix(res.Header().Get("Location"), provider.URL+"/logout?"), "Should redirect to the provider logout")
+	suite.Assert().NotContains(client.cookies, "wess_oidc")
+
+	res = client.send(http.MethodGet, "/api/me", "application/json")
+	suite.Assert().Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (suite *ServerSuite) TestCanKeepOIDCSessionsInStore() {
+	provider := newMockOIDCProvider()
+	defer provider.Close()
+	groups := make([]string, 200)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("group-%03d-with-a-rather-long-name", i)
+	}
+	provider.claims = map[string]any{"groups": groups}
+	login := func(client *oidcTestClient) *httptest.ResponseRecorder {
+		res := client.send(http.MethodGet, "/auth/login", "text/html")
+		suite.Require().Equal(http.StatusFound, res.Code)
+		noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		providerResponse, err := noRedirect.Get(res.Header().Get("Location"))
+		suite.Require().NoError(err)
+		providerResponse.Body.Close()
+		callback, _ := url.Parse(providerResponse.Header.Get("Location"))
+		return client.send(http.MethodGet, callback.RequestURI(), "text/html")
+	}
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	_, err := server.AddOIDC(OIDCOptions{Issuer: provider.URL, ClientID: "wess", ClientSecret: "secret"})
+	suite.Require().NoError(err)
+	client := &oidcTestClient{server: server, cookies: map[string]*http.Cookie{}}
+	res := login(client)
+	suite.Assert().Equal(http.StatusInternalServerError, res.Code, "A session too large for its cookie should fail")
+	suite.Assert().NotContains(client.cookies, "wess_oidc", "No session cookie should be set")
+
+	store := NewMemorySessionStore()
+	server = NewServer(ServerOptions{Logger: suite.Logger})
+	oidc, err := server.AddOIDC(OIDCOptions{Issuer: provider.URL, ClientID: "wess", ClientSecret: "secret", Store: store})
+	suite.Require().NoError(err)
+	server.AddRouteWithFunc(http.MethodGet, "/api/me", func(w http.ResponseWriter, r *http.Request) {
+		suite.Assert().NotEmpty(oidc.AccessToken(r))
+		suite.Assert().Len(GetPrincipal(r).Claims["groups"], len(groups))
+		_, _ = w.Write([]byte(GetPrincipal(r).Subject))
+	}, WithOIDC(oidc))
+	client = &oidcTestClient{server: server, cookies: map[string]*http.Cookie{}}
+	res = login(client)
+	suite.Require().Equal(http.StatusFound, res.Code, "Callback failed: %s", res.Body.String())
+	suite.Require().Contains(client.cookies, "wess_oidc")
+	suite.Assert().Less(len(client.cookies["wess_oidc"].Value), 200, "The cookie should only carry the reference of the session")
+	suite.Assert().Equal(1, store.Len())
+
+	res = client.send(http.MethodGet, "/api/me", "application/json")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("john", res.Body.String())
+
+	res = client.send(http.MethodPost, "/auth/logout", "text/html")
+	suite.Assert().Equal(http.StatusFound, res.Code)
+	suite.Assert().Equal(0, store.Len(), "The session should be deleted from the store")
+	res = client.send(http.MethodGet, "/api/me", "application/json")
+	suite.Assert().Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (suite *ServerSuite) TestShouldRejectOIDCCallbackWithInvalidState() {
+	provider := newMockOIDCProvider()
+	defer provider.Close()
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	_, err := server.AddOIDC(OIDCOptions{Issuer: provider.URL, ClientID: "wess", ClientSecret: "secret"})
+	suite.Require().NoError(err)
+	client := &oidcTestClient{server: server, cookies: map[string]*http.Cookie{}}
+
+	res := client.send(http.MethodGet, "/auth/callback?code=abc&state=xyz", "")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code, "Callback without login state should fail")
+
+	res = client.send(http.MethodGet, "/auth/login?return_to=//evil.com", "")
+	suite.Require().Equal(http.StatusFound, res.Code)
+	res = client.send(http.MethodGet, "/auth/callback?code=abc&state=xyz", "")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code, "Callback with another state should fail")
+}
+
+func (suite *ServerSuite) TestShouldNotCreateOIDCWithoutIssuer() {
+	_, err := NewOIDC(OIDCOptions{ClientID: "wess"})
+	suite.Assert().Error(err)
+	_, err = NewOIDC(OIDCOptions{Issuer: "https://issuer", ClientID: "wess", CookieKeys: [][]byte{[]byte("short")}})
+	suite.Assert().Error(err)
+	suite.Assert().Equal("/", safeReturnTo("//evil.com"))
+	suite.Assert().Equal("/", safeReturnTo("https://evil.com"))
+	suite.Assert().Equal("/app?x=1", safeReturnTo("/app?x=1"))
+}
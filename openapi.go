@@ -0,0 +1,468 @@
+package wess
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// OpenAPIUI is the documentation page served with the OpenAPI document
+type OpenAPIUI string
+
+const (
+	// NoUI serves only the OpenAPI document
+	NoUI OpenAPIUI = ""
+	// SwaggerUI serves the document with Swagger UI
+	SwaggerUI OpenAPIUI = "swagger"
+	// RedocUI serves the document with Redoc
+	RedocUI OpenAPIUI = "redoc"
+)
+
+// OpenAPIOptions defines the options of the OpenAPI document
+type OpenAPIOptions struct {
+	// Path is the path of the OpenAPI document.
+	// Default: "/openapi.json"
+	Path string
+
+	// Title is the title of the API.
+	// Default: "API"
+	Title string
+
+	// Version is the version of the API.
+	// Default: "1.0.0"
+	Version string
+
+	// Description is the description of the API
+	Description string
+
+	// Servers are the URLs of the servers of the API.
+	// Default: the server of the document
+	Servers []string
+
+	// UI is the documentation page served at UIPath.
+	// Default: NoUI
+	UI OpenAPIUI
+
+	// UIPath is the path of the documentation page.
+	// Default: "/docs"
+	UIPath string
+
+	// UIBaseURL is the URL the scripts and the styles of the UI are loaded from,
+	// it can be a path on this server to serve embedded copies (See AddFrontend).
+	// Default: a pinned version of the UI on the jsDelivr CDN (See SwaggerUIBaseURL and RedocBaseURL)
+	UIBaseURL string
+
+	// UIIntegrity are the Subresource Integrity hashes of the files of the UI, by file name
+	// (e.g. "swagger-ui-bundle.js": "sha384-..."), browsers refuse the files that do not match.
+	UIIntegrity map[string]string
+}
+
+const (
+	// SwaggerUIBaseURL is the default location of the Swagger UI files
+	SwaggerUIBaseURL = "https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.17.14"
+	// RedocBaseURL is the default location of the Redoc files
+	RedocBaseURL = "https://cdn.jsdelivr.net/npm/redoc@2.1.5/bundles"
+)
+
+// withDefaults gives the options with their default values
+func (options OpenAPIOptions) withDefaults() OpenAPIOptions {
+	if len(options.Path) == 0 {
+		options.Path = "/openapi.json"
+	}
+	if len(options.Title) == 0 {
+		options.Title = "API"
+	}
+	if len(options.Version) == 0 {
+		options.Version = "1.0.0"
+	}
+	if len(options.UIPath) == 0 {
+		options.UIPath = "/docs"
+	}
+	if len(options.UIBaseURL) == 0 {
+		switch options.UI {
+		case SwaggerUI:
+			options.UIBaseURL = SwaggerUIBaseURL
+		case RedocUI:
+			options.UIBaseURL = RedocBaseURL
+		}
+	}
+	options.UIBaseURL = strings.TrimRight(options.UIBaseURL, "/")
+	return options
+}
+
+// AddOpenAPI serves the OpenAPI 3.1 document of the routes of the server, and optionally a documentation page
+//
+// The document is sent as JSON, or as YAML if the client prefers it (See Render).
+// It is generated from the routes and their metadata (See RouteMetadata) when it is requested,
+// so routes added later are documented as well.
+// The request and response bodies are described from their Go types (See WithRequestBody and WithResponse),
+// using their json tags, and their description tags if any.
+func (server Server) AddOpenAPI(options OpenAPIOptions) {
+	options = options.withDefaults()
+	server.AddRouteWithFunc(http.MethodGet, options.Path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		Render(w, r, http.StatusOK, server.OpenAPIDocument(options))
+	}, WithName("openapi.document"), WithHidden())
+	if options.UI != NoUI {
+		server.AddRouteWithFunc(http.MethodGet, options.UIPath, openAPIUIHandler(options), WithName("openapi.ui"), WithHidden())
+	}
+}
+
+// OpenAPIDocument generates the OpenAPI 3.1 document of the routes of the server
+//
+// Routes without methods (like frontends) and hidden routes (See WithHidden) are not documented.
+func (server Server) OpenAPIDocument(options OpenAPIOptions) map[string]any {
+	options = options.withDefaults()
+	generator := &openAPIGenerator{schemas: map[string]any{}, schemaNames: map[reflect.Type]string{}, securitySchemes: map[string]any{}}
+	paths := map[string]map[string]any{}
+
+	_ = server.webrouter.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		var metadata RouteMetadata
+		if config := server.routes.get(route); config != nil {
+			metadata = config.metadata
+		}
+		if metadata.Hidden {
+			return nil
+		}
+		path, parameters := openAPIPath(template)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		for _, method := range methods {
+			paths[path][strings.ToLower(method)] = generator.operation(method, path, parameters, metadata)
+		}
+		return nil
+	})
+
+	info := map[string]any{"title": options.Title, "version": options.Version}
+	if len(options.Description) > 0 {
+		info["description"] = options.Description
+	}
+	document := map[string]any{
+		"openapi": "3.1.0",
+		"info":    info,
+		"paths":   paths,
+	}
+	if len(options.Servers) > 0 {
+		servers := make([]map[string]any, 0, len(options.Servers))
+		for _, url := range options.Servers {
+			servers = append(servers, map[string]any{"url": url})
+		}
+		document["servers"] = servers
+	}
+	components := map[string]any{}
+	if len(generator.schemas) > 0 {
+		components["schemas"] = generator.schemas
+	}
+	if len(generator.securitySchemes) > 0 {
+		components["securitySchemes"] = generator.securitySchemes
+	}
+	if len(components) > 0 {
+		document["components"] = components
+	}
+	return document
+}
+
+// openAPIGenerator generates the operations and the schemas of an OpenAPI document
+type openAPIGenerator struct {
+	schemas         map[string]any
+	schemaNames     map[reflect.Type]string
+	securitySchemes map[string]any
+}
+
+// pathParameterPattern matches the variables of a gorilla/mux path template
+var pathParameterPattern = regexp.MustCompile(`\{([^{}:]+)(?::((?:[^{}]|\{[^{}]*\})+))?\}`)
+
+// operationIDPattern matches the characters of a path that are not allowed in default operation IDs
+var operationIDPattern = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// openAPIPath converts a gorilla/mux path template into an OpenAPI path and its parameters
+func openAPIPath(template string) (string, []map[string]any) {
+	parameters := []map[string]any{}
+	path := pathParameterPattern.ReplaceAllStringFunc(template, func(variable string) string {
+		match := pathParameterPattern.FindStringSubmatch(variable)
+		schema := map[string]any{"type": "string"}
+		if len(match[2]) > 0 {
+			schema["pattern"] = "^" + match[2] + "$"
+		}
+		parameters = append(parameters, map[string]any{"name": match[1], "in": "path", "required": true, "schema": schema})
+		return "{" + match[1] + "}"
+	})
+	return path, parameters
+}
+
+// operation generates the OpenAPI operation of a route
+func (generator *openAPIGenerator) operation(method, path string, parameters []map[string]any, metadata RouteMetadata) map[string]any {
+	operation := map[string]any{}
+	if len(metadata.Name) > 0 && metadata.Name != path && !strings.HasPrefix(metadata.Name, "/") {
+		operation["operationId"] = metadata.Name
+	} else {
+		operation["operationId"] = strings.ToLower(method) + "_" + strings.Trim(operationIDPattern.ReplaceAllString(path, "_"), "_")
+	}
+	if len(metadata.Summary) > 0 {
+		operation["summary"] = metadata.Summary
+	}
+	if len(metadata.Description) > 0 {
+		operation["description"] = metadata.Description
+	}
+	if len(metadata.Tags) > 0 {
+		operation["tags"] = metadata.Tags
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if metadata.RequestBody != nil {
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": generator.schema(metadata.RequestBody)}},
+		}
+	}
+
+	responses := map[string]any{}
+	for status, body := range metadata.Responses {
+		response := map[string]any{"description": http.StatusText(status)}
+		if body != nil {
+			response["content"] = map[string]any{"application/json": map[string]any{"schema": generator.schema(body)}}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	if len(responses) == 0 {
+		responses["200"] = map[string]any{"description": http.StatusText(http.StatusOK)}
+	}
+	problem := func(status int) {
+		if _, found := responses[strconv.Itoa(status)]; !found {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content":     map[string]any{"application/problem+json": map[string]any{"schema": generator.schema(reflect.TypeOf(Problem{}))}},
+			}
+		}
+	}
+	if metadata.AuthenticationRequired || len(metadata.Authentication) > 0 {
+		problem(http.StatusUnauthorized)
+	}
+	if len(metadata.Scopes) > 0 {
+		problem(http.StatusForbidden)
+	}
+	if metadata.Timeout > 0 {
+		problem(http.StatusServiceUnavailable)
+	}
+	operation["responses"] = responses
+
+	if len(metadata.Authentication) > 0 {
+		security := []map[string][]string{}
+		for _, scheme := range metadata.Authentication {
+			name := generator.securityScheme(scheme)
+			scopes := metadata.Scopes
+			if scopes == nil {
+				scopes = []string{}
+			}
+			security = append(security, map[string][]string{name: scopes})
+		}
+		operation["security"] = security
+	}
+	return operation
+}
+
+// securityScheme registers the security scheme of an authentication scheme and gives its name
+func (generator *openAPIGenerator) securityScheme(scheme string) string {
+	name := strings.ToLower(scheme)
+	switch name {
+	case "basic":
+		generator.securitySchemes[name] = map[string]any{"type": "http", "scheme": "basic"}
+	case "bearer":
+		generator.securitySchemes[name] = map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+	case "apikey":
+		generator.securitySchemes[name] = map[string]any{"type": "apiKey", "in": "header", "name": "X-Api-Key"}
+	case "oidc":
+		generator.securitySchemes[name] = map[string]any{"type": "apiKey", "in": "cookie", "name": "wess_oidc"}
+	default:
+		generator.securitySchemes[name] = map[string]any{"type": "http", "scheme": name}
+	}
+	return name
+}
+
+// schemaNamePattern matches the characters that are not allowed in schema names
+var schemaNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// schema generates the JSON Schema of a Go type
+//
+// Named structs are added to the components and referenced.
+func (generator *openAPIGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": generator.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": generator.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return generator.structSchema(t)
+		}
+		name, found := generator.schemaNames[t]
+		if !found {
+			name = generator.schemaName(t)
+			generator.schemaNames[t] = name
+			generator.schemas[name] = map[string]any{} // placeholder for recursive types
+			generator.schemas[name] = generator.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// schemaName gives the component name of a named type
+//
+// The name is qualified with the package path when another type already has it.
+func (generator *openAPIGenerator) schemaName(t reflect.Type) string {
+	name := schemaNamePattern.ReplaceAllString(t.Name(), "_")
+	if _, taken := generator.schemas[name]; taken {
+		name = schemaNamePattern.ReplaceAllString(t.PkgPath()+"."+t.Name(), "_")
+	}
+	return name
+}
+
+// structSchema generates the JSON Schema of a struct from its exported fields and their json tags
+func (generator *openAPIGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || len(field.Index) > 1 && !isPromotedJSONField(t, field) {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && len(options) == 0 {
+			continue
+		}
+		if field.Anonymous && len(name) == 0 && field.Type.Kind() == reflect.Struct {
+			continue // the fields of embedded structs are promoted
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		schema := generator.schema(field.Type)
+		if description := field.Tag.Get("description"); len(description) > 0 {
+			if _, isRef := schema["$ref"]; isRef {
+				schema = map[string]any{"allOf": []any{schema}, "description": description}
+			} else {
+				schema["description"] = description
+			}
+		}
+		properties[name] = schema
+		if !slices.Contains(strings.Split(options, ","), "omitempty") && !slices.Contains(strings.Split(options, ","), "omitzero") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// isPromotedJSONField tells if a field of an embedded struct is promoted in the JSON of the struct
+//
+// The fields of embedded structs are promoted only if the embedded struct has no json name.
+func isPromotedJSONField(t reflect.Type, field reflect.StructField) bool {
+	for depth := 1; depth < len(field.Index); depth++ {
+		parent := t.FieldByIndex(field.Index[:depth])
+		if name, _, _ := strings.Cut(parent.Tag.Get("json"), ","); !parent.Anonymous || len(name) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// openAPIUITemplate is the documentation page
+var openAPIUITemplate = template.Must(template.New("openapi").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Title}}</title>
+{{- if eq .UI "swagger"}}
+  <link rel="stylesheet" href="{{.UIBaseURL}}/swagger-ui.css"{{with index .UIIntegrity "swagger-ui.css"}} integrity="{{.}}" crossorigin="anonymous"{{end}}>
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.UIBaseURL}}/swagger-ui-bundle.js"{{with index .UIIntegrity "swagger-ui-bundle.js"}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>
+  <script nonce="{{.Nonce}}">window.ui = SwaggerUIBundle({ url: {{.Path}}, dom_id: "#swagger-ui" })</script>
+{{- else}}
+</head>
+<body>
+  <redoc spec-url="{{.Path}}"></redoc>
+  <script src="{{.UIBaseURL}}/redoc.standalone.js"{{with index .UIIntegrity "redoc.standalone.js"}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>
+{{- end}}
+</body>
+</html>
+`))
+
+// openAPIUIHandler serves the documentation page
+//
+// The page loads its scripts and styles from UIBaseURL,
+// its Content-Security-Policy allows only that location when the security headers are enabled.
+func openAPIUIHandler(options OpenAPIOptions) http.HandlerFunc {
+	assets := "" // files on this server are allowed by 'self'
+	if location, err := url.Parse(options.UIBaseURL); err == nil && location.IsAbs() {
+		assets = " " + options.UIBaseURL + "/"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		nonce := CSPNonce(r)
+		policy := "default-src 'self'; script-src 'self'" + assets + " 'nonce-" + nonce + "'; style-src 'self'" + assets + " https://fonts.googleapis.com 'unsafe-inline'; img-src 'self' data:" + assets + "; font-src 'self' https://fonts.gstatic.com; worker-src 'self' blob:; object-src 'none'; base-uri 'self'"
+		for _, header := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+			if len(w.Header().Get(header)) > 0 {
+				w.Header().Set(header, policy)
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = openAPIUITemplate.Execute(w, struct {
+			OpenAPIOptions
+			Nonce string
+		}{options, nonce})
+	}
+}
@@ -0,0 +1,145 @@
+package wess
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+type openAPITestAddress struct {
+	City string `json:"city"`
+}
+
+type openAPITestUser struct {
+	ID        string              `json:"id" description:"The user identifier"`
+	Name      string              `json:"name"`
+	Email     string              `json:"email,omitempty"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Address   *openAPITestAddress `json:"address,omitempty"`
+	Secret    string              `json:"-"`
+}
+
+func (suite *ServerSuite) TestCanGenerateOpenAPIDocument() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	authenticator := NewAPIKeyAuthenticator(map[string]Principal{"key": {Subject: "tester"}})
+	server.Group("/api").With(WithTags("users")).
+		Get("/users", handler, WithName("users.list"), WithSummary("List the users"), WithResponse(http.StatusOK, []openAPITestUser{})).
+		Post("/users", handler, WithName("users.create"), WithRequestBody(openAPITestUser{}), WithResponse(http.StatusCreated, openAPITestUser{}), WithAuthentication(authenticator), RequireScopes("users:write")).
+		Get("/users/{id:[0-9]+}", handler, WithResponse(http.StatusOK, &openAPITestUser{})).
+		Get("/internal", handler, WithHidden())
+	server.AddOpenAPI(OpenAPIOptions{Title: "Test API", Version: "2.0.0", UI: SwaggerUI})
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("application/json", res.Header().Get("Content-Type"))
+
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas         map[string]map[string]any `json:"schemas"`
+			SecuritySchemes map[string]map[string]any `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &document))
+	suite.Assert().Equal("3.1.0", document.OpenAPI)
+	suite.Assert().Equal("Test API", document.Info.Title)
+	suite.Assert().Equal("2.0.0", document.Info.Version)
+
+	suite.Assert().NotContains(document.Paths, "/api/internal", "Hidden routes should not be documented")
+	suite.Assert().NotContains(document.Paths, "/openapi.json", "The document should not be documented")
+	suite.Assert().NotContains(document.Paths, "/healthz/liveness", "Health routes should not be documented")
+
+	list := document.Paths["/api/users"]["get"]
+	suite.Require().NotNil(list)
+	suite.Assert().Equal("users.list", list["operationId"])
+	suite.Assert().Equal("List the users", list["summary"])
+	suite.Assert().Equal([]any{"users"}, list["tags"])
+
+	create := document.Paths["/api/users"]["post"]
+	suite.Require().NotNil(create)
+	suite.Assert().Contains(create, "requestBody")
+	suite.Assert().Contains(create["responses"], "201")
+	suite.Assert().Contains(create["responses"], "401")
+	suite.Assert().Contains(create["responses"], "403")
+	suite.Assert().Equal([]any{map[string]any{"apikey": []any{"users:write"}}}, create["security"])
+	suite.Assert().Equal("header", document.Components.SecuritySchemes["apikey"]["in"])
+
+	get := document.Paths["/api/users/{id}"]["get"]
+	suite.Require().NotNil(get, "The path variables should be converted")
+	suite.Assert().Equal("get_api_users_id", get["operationId"])
+	parameters, _ := get["parameters"].([]any)
+	suite.Require().Len(parameters, 1)
+	parameter := parameters[0].(map[string]any)
+	suite.Assert().Equal("id", parameter["name"])
+	suite.Assert().Equal("path", parameter["in"])
+	suite.Assert().Equal("^[0-9]+$", parameter["schema"].(map[string]any)["pattern"])
+
+	user := document.Components.Schemas["openAPITestUser"]
+	suite.Require().NotNil(user, "Named structs should be components")
+	properties := user["properties"].(map[string]any)
+	suite.Assert().ElementsMatch([]any{"createdAt", "id", "name"}, user["required"])
+	suite.Assert().NotContains(properties, "Secret")
+	suite.Assert().Equal("The user identifier", properties["id"].(map[string]any)["description"])
+	suite.Assert().Equal("date-time", properties["createdAt"].(map[string]any)["format"])
+	suite.Assert().Equal("#/components/schemas/openAPITestAddress", properties["address"].(map[string]any)["$ref"])
+	suite.Assert().Contains(document.Components.Schemas, "openAPITestAddress")
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().True(strings.HasPrefix(res.Header().Get("Content-Type"), "text/html"))
+	suite.Assert().Contains(res.Body.String(), "swagger-ui-bundle.js")
+	suite.Assert().Contains(res.Body.String(), `"/openapi.json"`)
+}
+
+// Cookie has the same name as http.Cookie
+type Cookie struct {
+	Flavor string `json:"flavor"`
+}
+
+func (suite *ServerSuite) TestShouldQualifyOpenAPISchemasWithSameName() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	server.Group("/api").
+		Get("/cookies", handler, WithResponse(http.StatusOK, []Cookie{})).
+		Get("/http-cookies", handler, WithResponse(http.StatusOK, []http.Cookie{})).
+		Post("/cookies", handler, WithRequestBody(Cookie{}))
+
+	document := server.OpenAPIDocument(OpenAPIOptions{})
+	schemas := document["components"].(map[string]any)["schemas"].(map[string]any)
+	suite.Require().Contains(schemas, "Cookie")
+	suite.Require().Contains(schemas, "net_http.Cookie", "The second type should be qualified with its package")
+	suite.Assert().Contains(schemas["Cookie"].(map[string]any)["properties"], "flavor")
+	suite.Assert().Contains(schemas["net_http.Cookie"].(map[string]any)["properties"], "Name")
+	suite.Assert().Len(schemas, 2)
+}
+
+func (suite *ServerSuite) TestShouldPinOpenAPIUIAssets() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, SecurityHeaders: &SecurityHeaders{}})
+	server.AddOpenAPI(OpenAPIOptions{UI: SwaggerUI, UIIntegrity: map[string]string{"swagger-ui-bundle.js": "sha384-abc"}})
+	server.AddOpenAPI(OpenAPIOptions{Path: "/redoc.json", UI: RedocUI, UIPath: "/redoc", UIBaseURL: "/assets/redoc/"})
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().Contains(res.Body.String(), `src="`+SwaggerUIBaseURL+`/swagger-ui-bundle.js" integrity="sha384-abc" crossorigin="anonymous"`)
+	suite.Assert().Contains(res.Body.String(), `href="`+SwaggerUIBaseURL+`/swagger-ui.css">`)
+	policy := res.Header().Get("Content-Security-Policy")
+	suite.Assert().Contains(policy, "script-src 'self' "+SwaggerUIBaseURL+"/ 'nonce-")
+	suite.Assert().NotContains(policy, "https://cdn.jsdelivr.net ", "The whole CDN should not be allowed")
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/redoc", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().Contains(res.Body.String(), `src="/assets/redoc/redoc.standalone.js"`)
+	suite.Assert().NotContains(res.Header().Get("Content-Security-Policy"), "jsdelivr")
+}
@@ -0,0 +1,264 @@
+package wess
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Problem describes an RFC 9457 Problem Details object
+//
+// See: https://www.rfc-editor.org/rfc/rfc9457
+type Problem struct {
+	// Type is a URI reference that identifies the problem type.
+	// When empty, "about:blank" is implied.
+	Type string `json:"type,omitempty"`
+
+	// Title is a short, human-readable summary of the problem type.
+	// When empty, the HTTP status text is used.
+	Title string `json:"title,omitempty"`
+
+	// Status is the HTTP status code
+	Status int `json:"status,omitempty"`
+
+	// Detail is a human-readable explanation specific to this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is a URI reference that identifies this occurrence of the problem.
+	// When empty, the request path is used.
+	Instance string `json:"instance,omitempty"`
+
+	// RequestID is the identifier of the request that caused the problem
+	RequestID string `json:"requestId,omitempty"`
+
+	// Extensions contains additional members of the problem
+	Extensions map[string]any `json:"-"`
+}
+
+const (
+	// ProblemContentType is the content type of a Problem Details JSON document
+	ProblemContentType = "application/problem+json"
+)
+
+// NewProblem creates a new Problem for the given HTTP status
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Status: status,
+		Title:  http.StatusText(status),
+		Detail: detail,
+	}
+}
+
+// With adds an extension member to the Problem
+func (problem Problem) With(key string, value any) Problem {
+	extensions := make(map[string]any, len(problem.Extensions)+1)
+	for k, v := range problem.Extensions {
+		extensions[k] = v
+	}
+	extensions[key] = value
+	problem.Extensions = extensions
+	return problem
+}
+
+// Error returns the string version of this Problem
+//
+// implements the error interface
+func (problem Problem) Error() string {
+	if len(problem.Detail) > 0 {
+		return fmt.Sprintf("%d %s: %s", problem.Status, problem.Title, problem.Detail)
+	}
+	return fmt.Sprintf("%d %s", problem.Status, problem.Title)
+}
+
+// MarshalJSON marshals this Problem into JSON
+//
+// implements json.Marshaler
+func (problem Problem) MarshalJSON() ([]byte, error) {
+	type surrogate Problem
+	data, err := json.Marshal(surrogate(problem))
+	if err != nil || len(problem.Extensions) == 0 {
+		return data, err
+	}
+	members := map[string]any{}
+	for key, value := range problem.Extensions {
+		members[key] = value
+	}
+	// The standard members always win over the extensions
+	standard := map[string]any{}
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return nil, err
+	}
+	for key, value := range standard {
+		members[key] = value
+	}
+	return json.Marshal(members)
+}
+
+// WriteProblem writes the given Problem to the response
+//
+// The format is negotiated with the Accept header of the request:
+// application/problem+json (the default), text/html, text/plain or the media types of the renderers (See RegisterRenderer).
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem Problem) {
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+	if len(problem.Title) == 0 {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if len(problem.Instance) == 0 && r != nil && r.URL != nil {
+		problem.Instance = r.URL.Path
+	}
+	if len(problem.RequestID) == 0 {
+		problem.RequestID = requestID(r)
+	}
+
+	var accept string
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	addVary(w.Header(), "Accept")
+	offers := []string{ProblemContentType, "application/json", "text/html", "text/plain"}
+	for _, mediaType := range renderableMediaTypes() {
+		if mediaType != "application/json" {
+			offers = append(offers, mediaType)
+		}
+	}
+	for _, mediaType := range negotiateContentTypes(accept, offers...) {
+		switch mediaType {
+		case ProblemContentType, "application/json":
+			writeProblemJSON(w, problem)
+		case "text/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(problem.Status)
+			_ = problemHTMLTemplate.Execute(w, struct {
+				Problem
+				Nonce string
+			}{problem, CSPNonce(r)})
+		case "text/plain":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(problem.Status)
+			_, _ = w.Write([]byte(problem.plainText()))
+		default:
+			renderer := rendererFor(mediaType)
+			buffer := bytes.Buffer{}
+			if renderer == nil || renderer.Render(&buffer, problem) != nil {
+				continue
+			}
+			w.Header().Set("Content-Type", contentTypeWithCharset(mediaType))
+			w.WriteHeader(problem.Status)
+			_, _ = w.Write(buffer.Bytes())
+		}
+		return
+	}
+	writeProblemJSON(w, problem)
+}
+
+// writeProblemJSON writes the Problem as application/problem+json
+func writeProblemJSON(w http.ResponseWriter, problem Problem) {
+	payload, err := json.Marshal(problem)
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(problem.Status)
+		_, _ = w.Write([]byte(problem.plainText()))
+		return
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	_, _ = w.Write(payload)
+}
+
+// plainText gives the text/plain representation of the Problem
+func (problem Problem) plainText() string {
+	text := strings.Builder{}
+	fmt.Fprintf(&text, "%d %s", problem.Status, problem.Title)
+	if len(problem.Detail) > 0 {
+		text.WriteString("\n")
+		text.WriteString(problem.Detail)
+	}
+	if len(problem.RequestID) > 0 {
+		text.WriteString("\nRequest ID: ")
+		text.WriteString(problem.RequestID)
+	}
+	return text.String()
+}
+
+// requestID gets the request identifier set by the logger middleware, or sent by the client
+func requestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if reqid, ok := r.Context().Value("reqid").(string); ok && len(reqid) > 0 {
+		return reqid
+	}
+	return r.Header.Get("X-Request-Id")
+}
+
+// problemInterceptor is an http.ResponseWriter that replaces error responses with Problems
+//
+// This is used for handlers we do not control, like http.FileServer.
+type problemInterceptor struct {
+	http.ResponseWriter
+	request     *http.Request
+	intercepted bool
+}
+
+// WriteHeader sends an HTTP response header with the provided status code
+//
+// If the status is an error, a Problem is written instead and further writes are discarded.
+func (w *problemInterceptor) WriteHeader(status int) {
+	if w.intercepted {
+		return
+	}
+	if status >= http.StatusBadRequest {
+		w.intercepted = true
+		WriteProblem(w.ResponseWriter, w.request, NewProblem(status, ""))
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the connection as part of an HTTP reply
+func (w *problemInterceptor) Write(data []byte) (int, error) {
+	if w.intercepted {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (w *problemInterceptor) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// problemHandler wraps a handler so its error responses are written as Problems
+func problemHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&problemInterceptor{ResponseWriter: w, request: r}, r)
+	})
+}
+
+var problemHTMLTemplate = template.Must(template.New("problem").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Status}} {{.Title}}</title>
+  <style{{if .Nonce}} nonce="{{.Nonce}}"{{end}}>
+    body { font-family: system-ui, sans-serif; margin: 4em auto; max-width: 40em; color: #333; }
+    h1 { font-size: 2em; }
+    small { color: #888; }
+  </style>
+</head>
+<body>
+  <h1>{{.Status}} {{.Title}}</h1>
+  {{if .Detail}}<p>{{.Detail}}</p>{{end}}
+  {{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body>
+</html>
+`))
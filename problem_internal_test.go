@@ -0,0 +1,88 @@
+package wess
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *ServerSuite) TestCanNegotiateContentType() {
+	offers := []string{ProblemContentType, "application/json", "text/html", "text/plain"}
+	suite.Assert().Equal(ProblemContentType, negotiateContentType("", offers...))
+	suite.Assert().Equal(ProblemContentType, negotiateContentType("*/*", offers...))
+	suite.Assert().Equal("application/json", negotiateContentType("application/json", offers...))
+	suite.Assert().Equal("text/html", negotiateContentType("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers...))
+	suite.Assert().Equal("text/plain", negotiateContentType("text/*;q=0.5, text/html;q=0", offers...))
+	suite.Assert().Equal("", negotiateContentType("image/png", offers...))
+}
+
+func (suite *ServerSuite) TestCanWriteProblemAsJSON() {
+	req := httptest.NewRequest(http.MethodGet, "/api/users/12", nil)
+	req.Header.Set("X-Request-Id", "1234")
+	res := httptest.NewRecorder()
+	WriteProblem(res, req, NewProblem(http.StatusConflict, "User already exists").With("user", "12"))
+	suite.Assert().Equal(http.StatusConflict, res.Code)
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	var payload map[string]any
+	err := json.Unmarshal(res.Body.Bytes(), &payload)
+	suite.Require().NoError(err, "Failed to unmarshal the problem")
+	suite.Assert().Equal(float64(http.StatusConflict), payload["status"])
+	suite.Assert().Equal("Conflict", payload["title"])
+	suite.Assert().Equal("User already exists", payload["detail"])
+	suite.Assert().Equal("/api/users/12", payload["instance"])
+	suite.Assert().Equal("1234", payload["requestId"])
+	suite.Assert().Equal("12", payload["user"])
+}
+
+func (suite *ServerSuite) TestCanWriteProblemAsHTMLAndText() {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	res := httptest.NewRecorder()
+	WriteProblem(res, req, NewProblem(http.StatusNotFound, "<nothing>"))
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	suite.Assert().Equal("text/html; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Assert().Contains(res.Body.String(), "404 Not Found")
+	suite.Assert().Contains(res.Body.String(), "&lt;nothing&gt;")
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	WriteProblem(res, req, NewProblem(http.StatusNotFound, ""))
+	suite.Assert().Equal("text/plain; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("404 Not Found", res.Body.String())
+}
+
+func (suite *ServerSuite) TestShouldAnswerMethodNotAllowedWithAllowHeader() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRouteWithFunc(http.MethodGet, "/test", func(w http.ResponseWriter, r *http.Request) {})
+	server.AddRouteWithFunc(http.MethodPost, "/test", func(w http.ResponseWriter, r *http.Request) {})
+	server.AddRouteWithFunc(http.MethodPatch, "/other", func(w http.ResponseWriter, r *http.Request) {})
+	subrouter := server.SubRouter("/api")
+	subrouter.Methods(http.MethodPut).Path("/test").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodDelete, "/test", nil))
+	suite.Assert().Equal(http.StatusMethodNotAllowed, res.Code)
+	suite.Assert().Equal("GET, POST", res.Header().Get("Allow"))
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api/test", nil))
+	suite.Assert().Equal(http.StatusMethodNotAllowed, res.Code)
+	suite.Assert().Equal("PUT", res.Header().Get("Allow"))
+}
+
+func (suite *ServerSuite) TestShouldAnswerNotFoundWithProblem() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	err := server.AddFrontend("/", frontendFS, "testdata/frontend-good")
+	suite.Require().NoError(err, "Failed adding the frontend")
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/private", nil)
+	req.Header.Set("Accept", "text/plain")
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	suite.Assert().True(strings.HasPrefix(res.Body.String(), "404 Not Found"), "Body should be a text problem, got %s", res.Body.String())
+}
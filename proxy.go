@@ -0,0 +1,491 @@
+package wess
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+	"github.com/gorilla/mux"
+)
+
+// LoadBalancing is the algorithm a Proxy uses to choose its upstreams
+type LoadBalancing string
+
+const (
+	// RoundRobin sends the requests to the upstreams in turn
+	RoundRobin LoadBalancing = "round-robin"
+
+	// LeastConnections sends the requests to the upstream with the fewest requests in flight
+	LeastConnections LoadBalancing = "least-connections"
+)
+
+// ProxyOptions defines the options of a Proxy (See Server.AddProxy)
+type ProxyOptions struct {
+	// LoadBalancing is the algorithm used to choose the upstreams.
+	// Default: RoundRobin
+	LoadBalancing LoadBalancing
+
+	// StripPrefix removes the prefix of the proxy from the path of the forwarded requests
+	StripPrefix bool
+
+	// PreserveHost sends the Host of the incoming requests to the upstreams, instead of the host of the upstreams
+	PreserveHost bool
+
+	// Retries is the number of other upstreams tried when an upstream fails
+	// (connection error, 502 Bad Gateway, 503 Service Unavailable or 504 Gateway Timeout).
+	// Only the requests with an idempotent method and no body are retried.
+	Retries int
+
+	// MaxFailures is the number of consecutive failures after which an upstream is taken out of the rotation.
+	// Default: 3
+	MaxFailures int
+
+	// FailureTimeout is how long an upstream stays out of the rotation after MaxFailures failures.
+	// Default: 30 seconds
+	FailureTimeout time.Duration
+
+	// HealthCheckPath is the path of the upstreams checked with a GET request every HealthCheckInterval.
+	// The upstreams that do not answer with a 2xx or 3xx status are taken out of the rotation until they do.
+	// If empty, the upstreams are not checked actively.
+	HealthCheckPath string
+
+	// HealthCheckInterval is the time between two health checks.
+	// Default: 10 seconds
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the maximum duration of a health check.
+	// Default: 2 seconds
+	HealthCheckTimeout time.Duration
+
+	// RequestHeaders are set on the forwarded requests, the headers with an empty value are removed
+	RequestHeaders map[string]string
+
+	// ResponseHeaders are set on the responses of the upstreams, the headers with an empty value are removed
+	ResponseHeaders map[string]string
+
+	// Transport is used to send the requests to the upstreams.
+	// Default: http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// Proxy forwards requests to a set of upstream servers (See Server.AddProxy)
+type Proxy struct {
+	prefix    string
+	options   ProxyOptions
+	upstreams []*proxyUpstream
+	next      atomic.Uint64
+	metrics   *Metrics
+	logger    *logger.Logger
+	handler   *httputil.ReverseProxy
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// proxyUpstream is an upstream server of a Proxy
+type proxyUpstream struct {
+	url       *url.URL
+	name      string
+	active    atomic.Int64
+	failures  atomic.Int32
+	downUntil atomic.Int64 // Unix nanoseconds, set by the passive health checks
+	unhealthy atomic.Bool  // set by the active health checks
+}
+
+// errNoUpstream is returned when no upstream is available
+var errNoUpstream = errors.HTTPServiceUnavailable.With("upstream")
+
+// AddProxy forwards the requests for the prefix and the paths under it to the upstreams
+//
+// "/legacy" matches "/legacy" and "/legacy/users", but not "/legacyfoo".
+//
+// The proxy is built on httputil.ReverseProxy: WebSocket upgrades and streamed responses (like Server-Sent Events)
+// are passed through, the X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers are set
+// from the client information (See GetClientInfo).
+//
+// The upstreams are chosen with the LoadBalancing algorithm, among the ones that are healthy.
+// If no upstream is available, a 503 Service Unavailable Problem is sent,
+// if the upstream fails, a 502 Bad Gateway Problem is sent.
+//
+// The requests are counted per upstream and status in the wess_proxy_requests_total metric.
+//
+// The active health checks stop when the server shuts down, or when the proxy is closed.
+//
+// Options can be given to configure the proxy route (See WithMiddleware, WithTimeout, WithRateLimit).
+//
+// Example:
+//
+//	_, err := server.AddProxy("/legacy", []string{"http://legacy-1:8080", "http://legacy-2:8080"}, wess.ProxyOptions{
+//	  LoadBalancing:   wess.LeastConnections,
+//	  StripPrefix:     true,
+//	  Retries:         1,
+//	  HealthCheckPath: "/health",
+//	})
+func (server Server) AddProxy(prefix string, upstreams []string, options ProxyOptions, routeOptions ...RouteOption) (*Proxy, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.ArgumentMissing.With("upstreams")
+	}
+	if len(options.LoadBalancing) == 0 {
+		options.LoadBalancing = RoundRobin
+	}
+	if options.MaxFailures <= 0 {
+		options.MaxFailures = 3
+	}
+	if options.FailureTimeout <= 0 {
+		options.FailureTimeout = 30 * time.Second
+	}
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = 10 * time.Second
+	}
+	if options.HealthCheckTimeout <= 0 {
+		options.HealthCheckTimeout = 2 * time.Second
+	}
+	if options.Transport == nil {
+		options.Transport = http.DefaultTransport
+	}
+	proxy := &Proxy{
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		options: options,
+		metrics: server.metrics,
+		logger:  server.logger.Child("proxy", "proxy", "prefix", prefix),
+		stop:    make(chan struct{}),
+	}
+	for _, raw := range upstreams {
+		upstreamURL, err := url.Parse(raw)
+		if err != nil || (upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https") || len(upstreamURL.Host) == 0 {
+			return nil, errors.ArgumentInvalid.With("upstream", raw)
+		}
+		proxy.upstreams = append(proxy.upstreams, &proxyUpstream{url: upstreamURL, name: upstreamURL.Host})
+	}
+	proxy.handler = &httputil.ReverseProxy{
+		Rewrite:        proxy.rewrite,
+		Transport:      proxyTransport{proxy},
+		ModifyResponse: proxy.modifyResponse,
+		ErrorHandler:   proxy.errorHandler,
+		ErrorLog:       proxy.logger.AsStandardLog(),
+	}
+	proxy.metrics.Describe("wess_proxy_requests_total", CounterMetric, "Number of requests forwarded, by proxy, upstream and status")
+	proxy.metrics.Describe("wess_proxy_request_duration_seconds_total", CounterMetric, "Total time spent waiting for the upstreams, by proxy and upstream")
+	proxy.metrics.Describe("wess_proxy_errors_total", CounterMetric, "Number of requests that failed to reach the upstreams, by proxy and upstream")
+	proxy.metrics.Describe("wess_proxy_active_requests", GaugeMetric, "Number of requests in flight, by proxy and upstream")
+	proxy.metrics.Describe("wess_proxy_upstream_up", GaugeMetric, "Whether the upstream is in the rotation (1) or not (0), by proxy and upstream")
+	for _, upstream := range proxy.upstreams {
+		proxy.metrics.Set("wess_proxy_upstream_up", 1, "proxy", proxy.prefix, "upstream", upstream.name)
+	}
+
+	config := newRouteConfig(routeOptions...)
+	route := server.webrouter.PathPrefix(prefix).MatcherFunc(func(r *http.Request, match *mux.RouteMatch) bool {
+		return proxy.matches(r.URL.Path)
+	})
+	server.register(route.Handler(config.wrap(proxy.handler)), config)
+	if len(options.HealthCheckPath) > 0 {
+		go proxy.checkHealth()
+	}
+	server.webserver.RegisterOnShutdown(proxy.Close)
+	return proxy, nil
+}
+
+// matches tells if the path is the prefix of the proxy or is under it
+func (proxy *Proxy) matches(path string) bool {
+	return len(proxy.prefix) == 0 || path == proxy.prefix || strings.HasPrefix(path, proxy.prefix+"/")
+}
+
+// Upstreams gives the URLs of the upstreams of the proxy
+func (proxy *Proxy) Upstreams() []string {
+	upstreams := make([]string, 0, len(proxy.upstreams))
+	for _, upstream := range proxy.upstreams {
+		upstreams = append(upstreams, upstream.url.String())
+	}
+	return upstreams
+}
+
+// Healthy gives the URLs of the upstreams that are in the rotation
+func (proxy *Proxy) Healthy() []string {
+	now := time.Now()
+	upstreams := []string{}
+	for _, upstream := range proxy.upstreams {
+		if upstream.available(now) {
+			upstreams = append(upstreams, upstream.url.String())
+		}
+	}
+	return upstreams
+}
+
+// Close stops the active health checks of the proxy
+func (proxy *Proxy) Close() {
+	proxy.stopOnce.Do(func() { close(proxy.stop) })
+}
+
+// ServeHTTP forwards the request to an upstream
+//
+// implements http.Handler
+func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	proxy.handler.ServeHTTP(w, r)
+}
+
+// rewrite prepares the forwarded request, the upstream is chosen by RoundTrip
+func (proxy *Proxy) rewrite(request *httputil.ProxyRequest) {
+	info := GetClientInfo(request.In)
+	if ip := ClientIP(request.In); len(ip) > 0 {
+		request.Out.Header.Set("X-Forwarded-For", ip)
+	}
+	request.Out.Header.Set("X-Forwarded-Host", info.Host)
+	request.Out.Header.Set("X-Forwarded-Proto", info.Scheme)
+	if proxy.options.StripPrefix {
+		path := strings.TrimPrefix(request.In.URL.Path, proxy.prefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		request.Out.URL.Path = path
+		request.Out.URL.RawPath = ""
+	}
+	for name, value := range proxy.options.RequestHeaders {
+		if len(value) == 0 {
+			request.Out.Header.Del(name)
+		} else {
+			request.Out.Header.Set(name, value)
+		}
+	}
+}
+
+// modifyResponse rewrites the headers of the responses of the upstreams
+func (proxy *Proxy) modifyResponse(response *http.Response) error {
+	for name, value := range proxy.options.ResponseHeaders {
+		if len(value) == 0 {
+			response.Header.Del(name)
+		} else {
+			response.Header.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// errorHandler writes the errors of the upstreams as Problems
+func (proxy *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log := logger.Must(logger.FromContext(r.Context(), proxy.logger)).Child("proxy", "error")
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Debugf("The client canceled the request")
+	case errors.Is(err, errors.HTTPServiceUnavailable):
+		log.Errorf("No upstream is available for %s %s", r.Method, r.URL.Path)
+		WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, "No upstream server is available"))
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Errorf("The upstream did not answer in time", err)
+		WriteProblem(w, r, NewProblem(http.StatusGatewayTimeout, "The upstream server did not answer in time"))
+	default:
+		log.Errorf("Failed to forward the request", err)
+		WriteProblem(w, r, NewProblem(http.StatusBadGateway, "The upstream server failed"))
+	}
+}
+
+// proxyTransport sends the requests of a Proxy to its upstreams
+type proxyTransport struct {
+	proxy *Proxy
+}
+
+// RoundTrip sends the request to an upstream, and to the other upstreams if it fails and can be retried
+//
+// implements http.RoundTripper
+func (transport proxyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	proxy := transport.proxy
+	attempts := 1
+	if proxy.options.Retries > 0 && isIdempotent(request.Method) && (request.Body == nil || request.Body == http.NoBody) {
+		attempts += proxy.options.Retries
+	}
+	tried := map[*proxyUpstream]bool{}
+	var lastErr error = errNoUpstream
+	for attempt := 0; attempt < attempts; attempt++ {
+		upstream := proxy.pick(tried)
+		if upstream == nil {
+			break
+		}
+		tried[upstream] = true
+		outgoing := request.Clone(request.Context())
+		outgoing.URL.Scheme = upstream.url.Scheme
+		outgoing.URL.Host = upstream.url.Host
+		outgoing.URL.Path = strings.TrimSuffix(upstream.url.Path, "/") + request.URL.Path
+		outgoing.URL.RawPath = ""
+		if !proxy.options.PreserveHost {
+			outgoing.Host = upstream.url.Host
+		}
+
+		proxy.metrics.Set("wess_proxy_active_requests", float64(upstream.active.Add(1)), "proxy", proxy.prefix, "upstream", upstream.name)
+		start := time.Now()
+		response, err := proxy.options.Transport.RoundTrip(outgoing)
+		proxy.metrics.Add("wess_proxy_request_duration_seconds_total", time.Since(start).Seconds(), "proxy", proxy.prefix, "upstream", upstream.name)
+		if err != nil {
+			proxy.release(upstream)
+			proxy.metrics.Inc("wess_proxy_errors_total", "proxy", proxy.prefix, "upstream", upstream.name)
+			if request.Context().Err() != nil {
+				return nil, err
+			}
+			proxy.failed(upstream)
+			lastErr = err
+			continue
+		}
+		proxy.metrics.Inc("wess_proxy_requests_total", "proxy", proxy.prefix, "upstream", upstream.name, "status", strconv.Itoa(response.StatusCode))
+		switch response.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			proxy.failed(upstream)
+			if attempt+1 < attempts && proxy.pick(tried) != nil {
+				_ = response.Body.Close()
+				proxy.release(upstream)
+				continue
+			}
+		default:
+			proxy.succeeded(upstream)
+		}
+		response.Body = proxy.releaseOnClose(upstream, response.Body)
+		return response, nil
+	}
+	return nil, lastErr
+}
+
+// pick chooses an available upstream that was not tried yet
+//
+// returns nil if no upstream is available
+func (proxy *Proxy) pick(tried map[*proxyUpstream]bool) *proxyUpstream {
+	now := time.Now()
+	count := len(proxy.upstreams)
+	start := int(proxy.next.Add(1)-1) % count
+	var chosen *proxyUpstream
+	for offset := 0; offset < count; offset++ {
+		upstream := proxy.upstreams[(start+offset)%count]
+		if tried[upstream] || !upstream.available(now) {
+			continue
+		}
+		if proxy.options.LoadBalancing != LeastConnections {
+			return upstream
+		}
+		if chosen == nil || upstream.active.Load() < chosen.active.Load() {
+			chosen = upstream
+		}
+	}
+	return chosen
+}
+
+// failed records a failure of an upstream, it is taken out of the rotation after MaxFailures consecutive failures
+func (proxy *Proxy) failed(upstream *proxyUpstream) {
+	if int(upstream.failures.Add(1)) < proxy.options.MaxFailures {
+		return
+	}
+	upstream.failures.Store(0)
+	upstream.downUntil.Store(time.Now().Add(proxy.options.FailureTimeout).UnixNano())
+	proxy.metrics.Set("wess_proxy_upstream_up", 0, "proxy", proxy.prefix, "upstream", upstream.name)
+	proxy.logger.Warnf("Upstream %s failed %d times, it is out of the rotation for %s", upstream.name, proxy.options.MaxFailures, proxy.options.FailureTimeout)
+}
+
+// succeeded records a success of an upstream
+func (proxy *Proxy) succeeded(upstream *proxyUpstream) {
+	upstream.failures.Store(0)
+	if !upstream.unhealthy.Load() {
+		proxy.metrics.Set("wess_proxy_upstream_up", 1, "proxy", proxy.prefix, "upstream", upstream.name)
+	}
+}
+
+// release records the end of a request sent to an upstream
+func (proxy *Proxy) release(upstream *proxyUpstream) {
+	proxy.metrics.Set("wess_proxy_active_requests", float64(upstream.active.Add(-1)), "proxy", proxy.prefix, "upstream", upstream.name)
+}
+
+// releaseOnClose releases the upstream when the response body is closed
+//
+// The bodies of upgraded connections (like WebSockets) stay writable.
+func (proxy *Proxy) releaseOnClose(upstream *proxyUpstream, body io.ReadCloser) io.ReadCloser {
+	release := sync.OnceFunc(func() { proxy.release(upstream) })
+	if readWriter, ok := body.(io.ReadWriteCloser); ok {
+		return &proxyUpgradedBody{ReadWriteCloser: readWriter, release: release}
+	}
+	return &proxyBody{ReadCloser: body, release: release}
+}
+
+// checkHealth checks the health of the upstreams every HealthCheckInterval, until the proxy is closed
+func (proxy *Proxy) checkHealth() {
+	ticker := time.NewTicker(proxy.options.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		proxy.checkUpstreams()
+		select {
+		case <-proxy.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkUpstreams checks the health of the upstreams once
+func (proxy *Proxy) checkUpstreams() {
+	client := &http.Client{Transport: proxy.options.Transport, Timeout: proxy.options.HealthCheckTimeout}
+	for _, upstream := range proxy.upstreams {
+		healthy := false
+		if response, err := client.Get(strings.TrimSuffix(upstream.url.String(), "/") + proxy.options.HealthCheckPath); err == nil {
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+			healthy = response.StatusCode < http.StatusBadRequest
+		}
+		if wasUnhealthy := upstream.unhealthy.Swap(!healthy); wasUnhealthy == healthy {
+			if healthy {
+				upstream.downUntil.Store(0)
+				upstream.failures.Store(0)
+				proxy.logger.Infof("Upstream %s is healthy", upstream.name)
+			} else {
+				proxy.logger.Warnf("Upstream %s is unhealthy, it is out of the rotation", upstream.name)
+			}
+		}
+		up := 0.0
+		if healthy {
+			up = 1
+		}
+		proxy.metrics.Set("wess_proxy_upstream_up", up, "proxy", proxy.prefix, "upstream", upstream.name)
+	}
+}
+
+// available tells if the upstream is in the rotation
+func (upstream *proxyUpstream) available(now time.Time) bool {
+	return !upstream.unhealthy.Load() && now.UnixNano() >= upstream.downUntil.Load()
+}
+
+// isIdempotent tells if requests with the given method can be retried safely
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// proxyBody releases its upstream when it is closed
+type proxyBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close closes the body and releases the upstream
+//
+// implements io.Closer
+func (body *proxyBody) Close() error {
+	defer body.release()
+	return body.ReadCloser.Close()
+}
+
+// proxyUpgradedBody is the body of an upgraded connection, it releases its upstream when it is closed
+type proxyUpgradedBody struct {
+	io.ReadWriteCloser
+	release func()
+}
+
+// Close closes the connection and releases the upstream
+//
+// implements io.Closer
+func (body *proxyUpgradedBody) Close() error {
+	defer body.release()
+	return body.ReadWriteCloser.Close()
+}
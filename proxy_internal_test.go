@@ -0,0 +1,172 @@
+package wess
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func (suite *ServerSuite) TestCanProxyRequestsToUpstreams() {
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", name)
+			w.Header().Set("Server", "legacy")
+			_, _ = w.Write([]byte(strings.Join([]string{name, r.URL.Path, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Api-Key"), r.Header.Get("Cookie")}, "|")))
+		}))
+	}
+	first, second := upstream("first"), upstream("second")
+	defer first.Close()
+	defer second.Close()
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	proxy, err := server.AddProxy("/legacy", []string{first.URL, second.URL + "/base"}, ProxyOptions{
+		StripPrefix:     true,
+		RequestHeaders:  map[string]string{"X-Api-Key": "secret", "Cookie": ""},
+		ResponseHeaders: map[string]string{"Server": ""},
+	})
+	suite.Require().NoError(err)
+	defer proxy.Close()
+	suite.Assert().Len(proxy.Upstreams(), 2)
+
+	bodies := []string{}
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "http://www.acme.com/legacy/users", nil)
+		req.RemoteAddr = "203.0.113.7:4567"
+		req.Header.Set("Cookie", "session=1")
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		suite.Require().Equal(http.StatusOK, res.Code)
+		suite.Assert().Empty(res.Header().Get("Server"), "The response headers should be rewritten")
+		bodies = append(bodies, res.Body.String())
+	}
+	suite.Assert().ElementsMatch([]string{
+		"first|/users|203.0.113.7|www.acme.com|http|secret|",
+		"second|/base/users|203.0.113.7|www.acme.com|http|secret|",
+	}, bodies, "The requests should be balanced in round-robin")
+	suite.Assert().Equal(float64(1), server.Metrics().Get("wess_proxy_requests_total", "proxy", "/legacy", "upstream", strings.TrimPrefix(first.URL, "http://"), "status", "200"))
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/legacyfoo", nil))
+	suite.Assert().Equal(http.StatusNotFound, res.Code, "Paths that only start with the prefix should not be forwarded")
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/legacy", nil))
+	suite.Assert().Equal(http.StatusOK, res.Code, "The prefix itself should be forwarded")
+
+	_, err = server.AddProxy("/nowhere", []string{}, ProxyOptions{})
+	suite.Assert().Error(err)
+	_, err = server.AddProxy("/nowhere", []string{"ftp://legacy"}, ProxyOptions{})
+	suite.Assert().Error(err)
+}
+
+func (suite *ServerSuite) TestCanRetryAndEvictFailingUpstreams() {
+	var failingCalls atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		failingCalls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("healthy"))
+	}))
+	defer healthy.Close()
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	proxy, err := server.AddProxy("/legacy", []string{failing.URL, healthy.URL}, ProxyOptions{Retries: 1, MaxFailures: 2})
+	suite.Require().NoError(err)
+	defer proxy.Close()
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(method, "/legacy/users", nil))
+		return res
+	}
+	for range 4 {
+		res := serve(http.MethodGet)
+		suite.Assert().Equal(http.StatusOK, res.Code, "Idempotent requests should be retried on another upstream")
+		suite.Assert().Equal("healthy", res.Body.String())
+	}
+	suite.Assert().Equal(int32(2), failingCalls.Load(), "The failing upstream should be out of the rotation after MaxFailures failures")
+	suite.Assert().Equal([]string{healthy.URL}, proxy.Healthy())
+
+	proxy.upstreams[0].downUntil.Store(0)
+	codes := []int{serve(http.MethodPost).Code, serve(http.MethodPost).Code}
+	suite.Assert().Contains(codes, http.StatusServiceUnavailable, "Non idempotent requests should not be retried")
+
+	proxy.options.HealthCheckPath = "/health"
+	proxy.upstreams[0].downUntil.Store(0)
+	proxy.checkUpstreams()
+	suite.Assert().Equal([]string{healthy.URL}, proxy.Healthy(), "The active health checks should take the failing upstream out of the rotation")
+	suite.Assert().Equal(float64(0), server.Metrics().Get("wess_proxy_upstream_up", "proxy", "/legacy", "upstream", strings.TrimPrefix(failing.URL, "http://")))
+
+	healthy.Close()
+	proxy.checkUpstreams()
+	res := serve(http.MethodGet)
+	suite.Assert().Equal(http.StatusServiceUnavailable, res.Code)
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+}
+
+func (suite *ServerSuite) TestCanBalanceProxyByLeastConnections() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	proxy, err := server.AddProxy("/legacy", []string{"http://legacy-1", "http://legacy-2", "http://legacy-3"}, ProxyOptions{LoadBalancing: LeastConnections})
+	suite.Require().NoError(err)
+	defer proxy.Close()
+	proxy.upstreams[0].active.Store(3)
+	proxy.upstreams[1].active.Store(1)
+	proxy.upstreams[2].active.Store(2)
+	for range 3 {
+		suite.Assert().Same(proxy.upstreams[1], proxy.pick(map[*proxyUpstream]bool{}))
+	}
+	suite.Assert().Same(proxy.upstreams[2], proxy.pick(map[*proxyUpstream]bool{proxy.upstreams[1]: true}))
+}
+
+func (suite *ServerSuite) TestCanProxyUpgradedConnections() {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, buffer, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		_ = buffer.Flush()
+		_, _ = io.Copy(conn, buffer)
+	}))
+	defer upstream.Close()
+
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	proxy, err := server.AddProxy("/echo", []string{upstream.URL}, ProxyOptions{})
+	suite.Require().NoError(err)
+	defer proxy.Close()
+	front := httptest.NewServer(server.webserver.Handler)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	suite.Require().NoError(err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: www.acme.com\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+	suite.Require().NoError(err)
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusSwitchingProtocols, res.StatusCode)
+
+	_, err = conn.Write([]byte("ping"))
+	suite.Require().NoError(err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(reader, echo)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("ping", string(echo))
+}
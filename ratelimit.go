@@ -0,0 +1,337 @@
+package wess
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gildas/go-logger"
+)
+
+// RateLimitAlgorithm is the algorithm used to rate limit requests
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of Burst requests, refilled at Limit requests per Window
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests in any Window, weighting the previous window
+	SlidingWindow
+)
+
+// String returns the string version of this RateLimitAlgorithm
+func (algorithm RateLimitAlgorithm) String() string {
+	switch algorithm {
+	case TokenBucket:
+		return "token-bucket"
+	case SlidingWindow:
+		return "sliding-window"
+	default:
+		return fmt.Sprintf("RateLimitAlgorithm(%d)", int(algorithm))
+	}
+}
+
+// RateLimitKeyFunc gives the key used to rate limit a request
+//
+// Requests with an empty key are not rate limited.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitPolicy defines how requests are rate limited
+type RateLimitPolicy struct {
+	// Name identifies the policy, keys are counted separately for each policy.
+	// Policies with the same Name and Store share their counters.
+	// Default: a name unique to each RateLimitMiddleware (or "default" when calling a RateLimitStore directly)
+	Name string
+
+	// Limit is the number of requests allowed per Window
+	Limit int
+
+	// Window is the duration of the rate limiting window.
+	// Default: 1 minute
+	Window time.Duration
+
+	// Burst is the size of the bucket when using the TokenBucket algorithm.
+	// Default: Limit
+	Burst int
+
+	// Algorithm is the rate limiting algorithm.
+	// Default: TokenBucket
+	Algorithm RateLimitAlgorithm
+
+	// Key gives the key used to rate limit a request.
+	// Default: RateLimitByIP
+	Key RateLimitKeyFunc
+
+	// Store stores the rate limiting counters.
+	// Default: a MemoryRateLimitStore shared by all policies
+	Store RateLimitStore
+}
+
+// RateLimitResult is the result of a rate limiting decision
+type RateLimitResult struct {
+	// Allowed tells if the request is allowed
+	Allowed bool
+	// Limit is the maximum number of requests
+	Limit int
+	// Remaining is the number of requests left
+	Remaining int
+	// Reset is the duration until the quota is fully restored
+	Reset time.Duration
+	// RetryAfter is the duration to wait before retrying, when the request is not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore stores the rate limiting counters
+//
+// Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	// Take consumes a request for the key of the given policy and tells if it is allowed
+	Take(context context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// RateLimitByIP rate limits requests by client IP address
+//
+// The client IP address is resolved through the trusted proxies (See ServerOptions.TrustedProxies).
+func RateLimitByIP() RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return ClientIP(r)
+	}
+}
+
+// RateLimitByHeader rate limits requests by the value of a header, like an API key
+func RateLimitByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// RateLimitByQuery rate limits requests by the value of a query parameter, like an API key
+func RateLimitByQuery(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+// rateLimitSequence numbers the unnamed policies of the middlewares
+var rateLimitSequence atomic.Int64
+
+// defaultRateLimitStore is used by the policies that do not have a Store
+var defaultRateLimitStore = NewMemoryRateLimitStore(10 * time.Minute)
+
+// withDefaults sets the default values of the policy
+func (policy RateLimitPolicy) withDefaults() RateLimitPolicy {
+	if len(policy.Name) == 0 {
+		policy.Name = "default"
+	}
+	if policy.Limit <= 0 {
+		policy.Limit = 1
+	}
+	if policy.Window <= 0 {
+		policy.Window = time.Minute
+	}
+	if policy.Burst <= 0 {
+		policy.Burst = policy.Limit
+	}
+	if policy.Key == nil {
+		policy.Key = RateLimitByIP()
+	}
+	if policy.Store == nil {
+		policy.Store = defaultRateLimitStore
+	}
+	return policy
+}
+
+// RateLimitMiddleware rate limits the requests according to the given policy
+//
+// It can be used globally (See ServerOptions.RateLimit), on subrouters with Use,
+// or on routes (See WithRateLimit).
+//
+// Responses carry the RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Requests over the limit get a 429 Too Many Requests Problem with a Retry-After header.
+//
+// If the store fails, the request is allowed.
+func RateLimitMiddleware(policy RateLimitPolicy) func(http.Handler) http.Handler {
+	if len(policy.Name) == 0 {
+		// Unnamed policies must not share their counters with the other middlewares
+		policy.Name = fmt.Sprintf("default-%d", rateLimitSequence.Add(1))
+	}
+	policy = policy.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := policy.Key(r)
+			if len(key) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("ratelimit", "ratelimit", "policy", policy.Name)
+			result, err := policy.Store.Take(r.Context(), policy.Name+":"+key, policy)
+			if err != nil {
+				log.Errorf("Failed to check the rate limit, allowing the request", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			header := w.Header()
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(math.Ceil(policy.Window.Seconds()))))
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+			if !result.Allowed {
+				retryAfter := int(math.Max(1, math.Ceil(result.RetryAfter.Seconds())))
+				log.Warnf("Too many requests for %s, retry after %ds", key, retryAfter)
+				header.Set("Retry-After", strconv.Itoa(retryAfter))
+				WriteProblem(w, r, NewProblem(http.StatusTooManyRequests, "Rate limit exceeded").With("retryAfter", retryAfter))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithRateLimit rate limits a route according to the given policy
+func WithRateLimit(policy RateLimitPolicy) RouteOption {
+	return WithMiddleware(RateLimitMiddleware(policy))
+}
+
+// MemoryRateLimitStore is a RateLimitStore that keeps the counters in memory
+//
+// Keys that are not used for IdleTimeout are evicted,
+// but never before two windows of their policy have passed.
+type MemoryRateLimitStore struct {
+	// IdleTimeout is the duration after which unused keys are evicted
+	IdleTimeout time.Duration
+
+	mutex     sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// rateLimitEntry holds the state of a key
+type rateLimitEntry struct {
+	lastSeen time.Time
+	window   time.Duration
+
+	// TokenBucket
+	tokens     float64
+	lastRefill time.Time
+
+	// SlidingWindow
+	windowStart   time.Time
+	currentCount  int
+	previousCount int
+}
+
+// NewMemoryRateLimitStore creates a new MemoryRateLimitStore
+//
+// Keys that are not used for idleTimeout are evicted. Default: 10 minutes
+func NewMemoryRateLimitStore(idleTimeout time.Duration) *MemoryRateLimitStore {
+	if idleTimeout <= 0 {
+		idleTimeout = 10 * time.Minute
+	}
+	return &MemoryRateLimitStore{
+		IdleTimeout: idleTimeout,
+		entries:     map[string]*rateLimitEntry{},
+		now:         time.Now,
+	}
+}
+
+// Len gives the number of keys in the store
+func (store *MemoryRateLimitStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.entries)
+}
+
+// Take consumes a request for the key of the given policy and tells if it is allowed
+//
+// implements RateLimitStore
+func (store *MemoryRateLimitStore) Take(context context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	policy = policy.withDefaults()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.now()
+	store.sweep(now)
+	entry, found := store.entries[key]
+	if !found {
+		entry = &rateLimitEntry{tokens: float64(policy.Burst), lastRefill: now, windowStart: now}
+		store.entries[key] = entry
+	}
+	entry.lastSeen = now
+	entry.window = policy.Window
+
+	if policy.Algorithm == SlidingWindow {
+		return entry.takeSlidingWindow(now, policy), nil
+	}
+	return entry.takeTokenBucket(now, policy), nil
+}
+
+// sweep evicts the idle keys, at most once per IdleTimeout
+func (store *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < store.IdleTimeout {
+		return
+	}
+	store.lastSweep = now
+	for key, entry := range store.entries {
+		if idle := now.Sub(entry.lastSeen); idle >= store.IdleTimeout && idle >= 2*entry.window {
+			delete(store.entries, key)
+		}
+	}
+}
+
+// takeTokenBucket consumes a token from the bucket
+func (entry *rateLimitEntry) takeTokenBucket(now time.Time, policy RateLimitPolicy) RateLimitResult {
+	rate := float64(policy.Limit) / policy.Window.Seconds() // tokens per second
+	entry.tokens = math.Min(float64(policy.Burst), entry.tokens+now.Sub(entry.lastRefill).Seconds()*rate)
+	entry.lastRefill = now
+
+	result := RateLimitResult{Limit: policy.Burst}
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - entry.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(math.Floor(entry.tokens))
+	result.Reset = time.Duration((float64(policy.Burst) - entry.tokens) / rate * float64(time.Second))
+	return result
+}
+
+// takeSlidingWindow counts a request in the current window
+func (entry *rateLimitEntry) takeSlidingWindow(now time.Time, policy RateLimitPolicy) RateLimitResult {
+	if elapsed := now.Sub(entry.windowStart); elapsed >= policy.Window {
+		windows := int64(elapsed / policy.Window)
+		if windows == 1 {
+			entry.previousCount = entry.currentCount
+		} else {
+			entry.previousCount = 0
+		}
+		entry.currentCount = 0
+		entry.windowStart = entry.windowStart.Add(time.Duration(windows) * policy.Window)
+	}
+	elapsed := now.Sub(entry.windowStart)
+	weight := 1 - elapsed.Seconds()/policy.Window.Seconds()
+	estimated := float64(entry.previousCount)*weight + float64(entry.currentCount)
+
+	result := RateLimitResult{Limit: policy.Limit, Reset: policy.Window - elapsed}
+	if estimated+1 <= float64(policy.Limit) {
+		entry.currentCount++
+		estimated++
+		result.Allowed = true
+	} else if entry.previousCount > 0 {
+		// Wait until enough of the previous window has slid out
+		needed := (estimated + 1 - float64(policy.Limit)) / float64(entry.previousCount)
+		result.RetryAfter = time.Duration(needed * float64(policy.Window))
+		if result.RetryAfter > result.Reset {
+			result.RetryAfter = result.Reset
+		}
+	} else {
+		result.RetryAfter = result.Reset
+	}
+	result.Remaining = int(math.Max(0, math.Floor(float64(policy.Limit)-estimated)))
+	return result
+}
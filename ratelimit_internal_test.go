@@ -0,0 +1,119 @@
+package wess
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (suite *ServerSuite) TestCanRateLimitWithTokenBucket() {
+	store := NewMemoryRateLimitStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	policy := RateLimitPolicy{Limit: 2, Window: time.Second}
+
+	for i := 0; i < 2; i++ {
+		result, err := store.Take(context.Background(), "key", policy)
+		suite.Require().NoError(err)
+		suite.Assert().True(result.Allowed, "Request %d should be allowed", i)
+	}
+	result, _ := store.Take(context.Background(), "key", policy)
+	suite.Assert().False(result.Allowed, "Request should not be allowed")
+	suite.Assert().Equal(500*time.Millisecond, result.RetryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	result, _ = store.Take(context.Background(), "key", policy)
+	suite.Assert().True(result.Allowed, "Request should be allowed after refill")
+}
+
+func (suite *ServerSuite) TestCanRateLimitWithSlidingWindow() {
+	store := NewMemoryRateLimitStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	policy := RateLimitPolicy{Limit: 4, Window: time.Minute, Algorithm: SlidingWindow}
+
+	for i := 0; i < 4; i++ {
+		result, _ := store.Take(context.Background(), "key", policy)
+		suite.Assert().True(result.Allowed, "Request %d should be allowed", i)
+	}
+	result, _ := store.Take(context.Background(), "key", policy)
+	suite.Assert().False(result.Allowed, "Request should not be allowed")
+
+	// Half of the previous window still counts: 4 * 0.5 = 2 requests
+	now = now.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		result, _ = store.Take(context.Background(), "key", policy)
+		suite.Assert().True(result.Allowed, "Request %d should be allowed in the next window", i)
+	}
+	result, _ = store.Take(context.Background(), "key", policy)
+	suite.Assert().False(result.Allowed, "Request should not be allowed")
+}
+
+func (suite *ServerSuite) TestShouldEvictIdleRateLimitKeys() {
+	store := NewMemoryRateLimitStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	_, _ = store.Take(context.Background(), "key1", RateLimitPolicy{Limit: 1})
+	_, _ = store.Take(context.Background(), "key2", RateLimitPolicy{Limit: 1})
+	suite.Assert().Equal(2, store.Len())
+	now = now.Add(2 * time.Minute)
+	_, _ = store.Take(context.Background(), "key3", RateLimitPolicy{Limit: 1})
+	suite.Assert().Equal(1, store.Len())
+}
+
+func (suite *ServerSuite) TestCanRateLimitRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	server.AddRouteWithFunc(http.MethodGet, "/limited", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, WithRateLimit(RateLimitPolicy{
+		Name:  "test-route",
+		Limit: 1,
+		Key:   RateLimitByHeader("X-Api-Key"),
+		Store: NewMemoryRateLimitStore(time.Minute),
+	}))
+
+	send := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.Header.Set("X-Api-Key", apiKey)
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+	res := send("key1")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("1", res.Header().Get("RateLimit-Limit"))
+	suite.Assert().Equal("0", res.Header().Get("RateLimit-Remaining"))
+	suite.Assert().Equal("1;w=60", res.Header().Get("RateLimit-Policy"))
+
+	res = send("key1")
+	suite.Assert().Equal(http.StatusTooManyRequests, res.Code)
+	suite.Assert().Equal("60", res.Header().Get("Retry-After"))
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	res = send("key2")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+}
+
+func (suite *ServerSuite) TestShouldCountUnnamedRateLimitPoliciesSeparately() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, RateLimit: &RateLimitPolicy{Limit: 100}})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	server.AddRouteWithFunc(http.MethodGet, "/limited", handler, WithRateLimit(RateLimitPolicy{Limit: 2}))
+	server.AddRouteWithFunc(http.MethodGet, "/other", handler)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.31:4567"
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+	suite.Assert().Equal(http.StatusOK, send("/limited").Code)
+	suite.Assert().Equal(http.StatusOK, send("/limited").Code, "The global policy should not count against the route policy")
+	suite.Assert().Equal(http.StatusTooManyRequests, send("/limited").Code)
+	res := send("/other")
+	suite.Assert().Equal(http.StatusOK, res.Code, "The route policy should not count against the other routes")
+	suite.Assert().Equal("96", res.Header().Get("RateLimit-Remaining"))
+}
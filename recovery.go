@@ -0,0 +1,61 @@
+package wess
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gildas/go-logger"
+)
+
+// PanicHandler is called after a panic in a handler has been recovered and logged
+//
+// It can be used to report the panic to an external service.
+// The response has already been written when it is called.
+type PanicHandler func(r *http.Request, recovered any, stack []byte)
+
+// RecoveryMiddleware recovers from panics in the handlers
+//
+// The panic and its stack are logged with the request Logger and
+// a 500 Internal Server Error Problem carrying the request ID is sent to the client.
+//
+// If the handler already started writing the response, the connection is aborted.
+//
+// Panics with http.ErrAbortHandler are not logged and let through,
+// so net/http can abort the connection silently.
+//
+// The optional onPanic is called after the panic has been logged.
+func RecoveryMiddleware(onPanic PanicHandler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writer := newResponseWriter(w)
+
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				stack := debug.Stack()
+				log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child(nil, "recovery")
+				log.Errorf("Panic while handling %s %s: %v\n%s", r.Method, r.URL.Path, recovered, stack)
+
+				started := writer.HeaderWritten()
+				if started {
+					log.Warnf("The response was already started, aborting the connection")
+				} else {
+					WriteProblem(writer, r, NewProblem(http.StatusInternalServerError, ""))
+				}
+				if onPanic != nil {
+					onPanic(r, recovered, stack)
+				}
+				if started {
+					panic(http.ErrAbortHandler)
+				}
+			}()
+
+			next.ServeHTTP(writer, r)
+		})
+	}
+}
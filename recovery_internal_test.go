@@ -0,0 +1,55 @@
+package wess
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+func (suite *ServerSuite) TestCanRecoverFromPanic() {
+	var recoveredValue any
+	server := NewServer(ServerOptions{
+		Logger: suite.Logger,
+		PanicHandler: func(r *http.Request, recovered any, stack []byte) {
+			recoveredValue = recovered
+			suite.Assert().NotEmpty(stack, "The stack should be given to the panic handler")
+		},
+	})
+	server.AddRouteWithFunc(http.MethodGet, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	suite.Assert().Equal(http.StatusInternalServerError, res.Code)
+	suite.Assert().Equal("something went wrong", recoveredValue)
+	var payload map[string]any
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &payload))
+	suite.Assert().NotEmpty(payload["requestId"], "The problem should carry the request ID")
+	suite.Assert().Equal(res.Header().Get("X-Request-Id"), payload["requestId"])
+}
+
+func (suite *ServerSuite) TestShouldLetAbortHandlerPanicThrough() {
+	called := false
+	handler := RecoveryMiddleware(func(r *http.Request, recovered any, stack []byte) {
+		called = true
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	suite.Assert().PanicsWithValue(http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	suite.Assert().False(called, "The panic handler should not be called for http.ErrAbortHandler")
+}
+
+func (suite *ServerSuite) TestShouldAbortWhenPanicAfterWrite() {
+	handler := RecoveryMiddleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		panic("too late")
+	}))
+
+	suite.Assert().PanicsWithValue(http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
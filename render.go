@@ -0,0 +1,272 @@
+package wess
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gildas/go-errors"
+)
+
+// Renderer encodes values in a media type (See RegisterRenderer and Render)
+//
+// The renderers of wess encode the values as their JSON representation,
+// so the json tags and the json.Marshaler implementations are honored in every format.
+//
+// If a renderer cannot encode a value (like a single number in CSV),
+// it should return an error that matches errors.Unsupported, so Render can try the next acceptable media type.
+type Renderer interface {
+	Render(w io.Writer, value any) error
+}
+
+// RendererFunc is a function that implements Renderer
+type RendererFunc func(w io.Writer, value any) error
+
+// Render encodes the value
+//
+// implements Renderer
+func (renderer RendererFunc) Render(w io.Writer, value any) error {
+	return renderer(w, value)
+}
+
+// rendererEntry associates a media type to a Renderer
+type rendererEntry struct {
+	MediaType string
+	Renderer  Renderer
+}
+
+// rendererRegistry contains the renderers, by order of preference
+type rendererRegistry struct {
+	mutex     sync.RWMutex
+	renderers []rendererEntry
+}
+
+var renderers = &rendererRegistry{
+	renderers: []rendererEntry{
+		{"application/json", JSONRenderer{}},
+		{"application/yaml", YAMLRenderer{}},
+		{"application/msgpack", MessagePackRenderer{}},
+		{"text/csv", CSVRenderer{}},
+		{"application/x-yaml", YAMLRenderer{}},
+		{"text/yaml", YAMLRenderer{}},
+		{"application/x-msgpack", MessagePackRenderer{}},
+		{"application/vnd.msgpack", MessagePackRenderer{}},
+	},
+}
+
+// RegisterRenderer registers the Renderer of a media type, replacing the existing one
+//
+// New media types are the least preferred when the client accepts several media types with the same quality.
+// JSON (application/json) is always preferred when the client accepts any media type.
+//
+// By default, JSON, YAML, MessagePack and CSV are registered.
+//
+// Example:
+//
+//	wess.RegisterRenderer("text/csv", wess.CSVRenderer{Comma: ';'})
+//	wess.RegisterRenderer("application/xml", wess.RendererFunc(func(w io.Writer, value any) error {
+//	  return xml.NewEncoder(w).Encode(value)
+//	}))
+func RegisterRenderer(mediaType string, renderer Renderer) {
+	mediaType = strings.ToLower(mediaType)
+	renderers.mutex.Lock()
+	defer renderers.mutex.Unlock()
+	for index, entry := range renderers.renderers {
+		if entry.MediaType == mediaType {
+			renderers.renderers[index].Renderer = renderer
+			return
+		}
+	}
+	renderers.renderers = append(renderers.renderers, rendererEntry{mediaType, renderer})
+}
+
+// acceptable gives the renderers acceptable for the given Accept header, the best first
+func (registry *rendererRegistry) acceptable(accept string) []rendererEntry {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	offers := make([]string, 0, len(registry.renderers))
+	byMediaType := make(map[string]Renderer, len(registry.renderers))
+	for _, entry := range registry.renderers {
+		offers = append(offers, entry.MediaType)
+		byMediaType[entry.MediaType] = entry.Renderer
+	}
+	acceptable := []rendererEntry{}
+	for _, mediaType := range negotiateContentTypes(accept, offers...) {
+		acceptable = append(acceptable, rendererEntry{mediaType, byMediaType[mediaType]})
+	}
+	return acceptable
+}
+
+// Render writes the value in the media type negotiated with the Accept header of the request
+//
+// The media type is the one of the registered renderers (See RegisterRenderer) that the client prefers,
+// JSON when the client accepts any media type.
+// If the renderer cannot encode the value, the next acceptable media type is tried.
+// If there is none, a 406 Not Acceptable Problem is written with the available media types.
+//
+// If the value cannot be encoded, the error is written with WriteError.
+// Responses with a 204 No Content or 304 Not Modified status have no body.
+func Render(w http.ResponseWriter, r *http.Request, status int, value any) {
+	addVary(w.Header(), "Accept")
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+	mediaType, payload, err := renderValue(r.Header.Get("Accept"), value)
+	if err != nil {
+		if errors.Is(err, errors.Unsupported) {
+			WriteProblem(w, r, NewProblem(http.StatusNotAcceptable, "The response cannot be sent in the accepted media types").With("available", renderableMediaTypes()))
+			return
+		}
+		WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeWithCharset(mediaType))
+	w.WriteHeader(status)
+	_, _ = w.Write(payload)
+}
+
+// renderValue encodes the value with the best acceptable renderer that can encode it
+//
+// returns an errors.Unsupported error if no acceptable renderer can encode the value
+func renderValue(accept string, value any) (mediaType string, payload []byte, err error) {
+	for _, entry := range renderers.acceptable(accept) {
+		buffer := bytes.Buffer{}
+		if err := entry.Renderer.Render(&buffer, value); err != nil {
+			if errors.Is(err, errors.Unsupported) {
+				continue
+			}
+			return "", nil, err
+		}
+		return entry.MediaType, buffer.Bytes(), nil
+	}
+	return "", nil, errors.Unsupported.With("media type", accept)
+}
+
+// canRender tells if the client accepts at least one of the registered media types
+func canRender(r *http.Request) bool {
+	return len(renderers.acceptable(r.Header.Get("Accept"))) > 0
+}
+
+// renderableMediaTypes gives the media types of the registered renderers
+func renderableMediaTypes() []string {
+	renderers.mutex.RLock()
+	defer renderers.mutex.RUnlock()
+	mediaTypes := make([]string, 0, len(renderers.renderers))
+	for _, entry := range renderers.renderers {
+		mediaTypes = append(mediaTypes, entry.MediaType)
+	}
+	return mediaTypes
+}
+
+// rendererFor gives the renderer of a media type
+//
+// returns nil if there is no renderer for the media type
+func rendererFor(mediaType string) Renderer {
+	renderers.mutex.RLock()
+	defer renderers.mutex.RUnlock()
+	for _, entry := range renderers.renderers {
+		if entry.MediaType == mediaType {
+			return entry.Renderer
+		}
+	}
+	return nil
+}
+
+// contentTypeWithCharset adds the charset to textual media types
+func contentTypeWithCharset(mediaType string) string {
+	if strings.HasPrefix(mediaType, "text/") {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+// JSONRenderer renders values as JSON
+type JSONRenderer struct {
+	// Indent indents the JSON documents, they are compact if empty
+	Indent string
+}
+
+// Render encodes the value as JSON
+//
+// implements Renderer
+func (renderer JSONRenderer) Render(w io.Writer, value any) error {
+	var payload []byte
+	var err error
+	if len(renderer.Indent) > 0 {
+		payload, err = json.MarshalIndent(value, "", renderer.Indent)
+	} else {
+		payload, err = json.Marshal(value)
+	}
+	if err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// orderedObject is a JSON object that keeps the order of its members
+type orderedObject struct {
+	keys   []string
+	values map[string]any
+}
+
+// toOrderedJSON converts a value to its JSON representation
+//
+// The objects are *orderedObject, the arrays are []any, the numbers are json.Number.
+func toOrderedJSON(value any) (any, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.RuntimeError.Wrap(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	result, err := decodeOrderedJSON(decoder)
+	if err != nil {
+		return nil, errors.RuntimeError.Wrap(err)
+	}
+	return result, nil
+}
+
+// decodeOrderedJSON decodes the next JSON value of the decoder
+func decodeOrderedJSON(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := &orderedObject{values: map[string]any{}}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if _, found := object.values[key.(string)]; !found {
+				object.keys = append(object.keys, key.(string))
+			}
+			object.values[key.(string)] = value
+		}
+		_, err = decoder.Token() // }
+		return object, err
+	case json.Delim('['):
+		array := []any{}
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token() // ]
+		return array, err
+	}
+	return token, nil
+}
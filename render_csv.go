@@ -0,0 +1,138 @@
+package wess
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/gildas/go-errors"
+)
+
+// CSVRenderer renders values as CSV
+//
+// The values must be arrays of objects (like slices of structs), arrays of arrays or single objects.
+// The columns of objects are their JSON members, in the order they are first seen, and the first row is the header.
+// Nested objects and arrays are written as JSON.
+// The strings that spreadsheets would run as formulas (starting with =, +, -, @, tab or carriage return)
+// are prefixed with a single quote, unless NoFormulaEscaping is set.
+//
+// Other values give an errors.Unsupported error, so Render tries the next acceptable media type.
+type CSVRenderer struct {
+	// Comma is the field delimiter.
+	// Default: ','
+	Comma rune
+
+	// NoHeader does not write the header row of arrays of objects
+	NoHeader bool
+
+	// NoFormulaEscaping writes the strings as they are, even if spreadsheets would run them as formulas
+	NoFormulaEscaping bool
+}
+
+// Render encodes the value as CSV
+//
+// implements Renderer
+func (renderer CSVRenderer) Render(w io.Writer, value any) error {
+	document, err := toOrderedJSON(value)
+	if err != nil {
+		return err
+	}
+	if object, ok := document.(*orderedObject); ok {
+		document = []any{object}
+	}
+	rows, ok := document.([]any)
+	if !ok {
+		return errors.Unsupported.With("value", "CSV")
+	}
+
+	records := [][]string{}
+	columns := []string{}
+	known := map[string]bool{}
+	for _, row := range rows {
+		if object, ok := row.(*orderedObject); ok {
+			for _, key := range object.keys {
+				if !known[key] {
+					known[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+	}
+	if len(columns) > 0 && !renderer.NoHeader {
+		header := make([]string, len(columns))
+		for index, column := range columns {
+			header[index] = renderer.cell(column)
+		}
+		records = append(records, header)
+	}
+	for _, row := range rows {
+		switch row := row.(type) {
+		case *orderedObject:
+			record := make([]string, len(columns))
+			for index, column := range columns {
+				record[index] = renderer.cell(row.values[column])
+			}
+			records = append(records, record)
+		case []any:
+			record := make([]string, len(row))
+			for index, cell := range row {
+				record[index] = renderer.cell(cell)
+			}
+			records = append(records, record)
+		default:
+			return errors.Unsupported.With("value", "CSV")
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	if renderer.Comma != 0 {
+		writer.Comma = renderer.Comma
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	return nil
+}
+
+// cell gives the CSV cell of a JSON value
+func (renderer CSVRenderer) cell(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if !renderer.NoFormulaEscaping && len(value) > 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			return "'" + value
+		}
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	default:
+		payload, _ := json.Marshal(plainJSON(value))
+		return string(payload)
+	}
+}
+
+// plainJSON converts a JSON representation with ordered objects to a value json.Marshal can encode
+func plainJSON(value any) any {
+	switch value := value.(type) {
+	case *orderedObject:
+		object := make(map[string]any, len(value.keys))
+		for _, key := range value.keys {
+			object[key] = plainJSON(value.values[key])
+		}
+		return object
+	case []any:
+		array := make([]any, len(value))
+		for index, item := range value {
+			array[index] = plainJSON(item)
+		}
+		return array
+	}
+	return value
+}
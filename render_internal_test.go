@@ -0,0 +1,109 @@
+package wess
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type renderTestItem struct {
+	Name     string   `json:"name"`
+	Quantity int      `json:"quantity"`
+	Price    float64  `json:"price"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+func (suite *ServerSuite) TestCanRenderInNegotiatedFormats() {
+	items := []renderTestItem{{Name: "apple", Quantity: 3, Price: 1.5}, {Name: "pear, green", Quantity: 1, Price: 2, Tags: []string{"fruit"}}}
+	render := func(accept string, value any) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		if len(accept) > 0 {
+			req.Header.Set("Accept", accept)
+		}
+		res := httptest.NewRecorder()
+		Render(res, req, http.StatusOK, value)
+		return res
+	}
+
+	res := render("", items)
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("application/json", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("Accept", res.Header().Get("Vary"))
+	suite.Assert().JSONEq(`[{"name":"apple","quantity":3,"price":1.5},{"name":"pear, green","quantity":1,"price":2,"tags":["fruit"]}]`, res.Body.String())
+
+	res = render("text/html,application/xhtml+xml,*/*;q=0.8", items)
+	suite.Assert().Equal("application/json", res.Header().Get("Content-Type"), "Browsers should get JSON")
+
+	res = render("application/json;q=0.5, application/yaml", items)
+	suite.Assert().Equal("application/yaml", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("- name: apple\n  quantity: 3\n  price: 1.5\n- name: pear, green\n  quantity: 1\n  price: 2\n  tags:\n    - fruit\n", res.Body.String(), "YAML should keep the order of the JSON members")
+
+	res = render("application/msgpack", items)
+	suite.Assert().Equal("application/msgpack", res.Header().Get("Content-Type"))
+	var decoded []map[string]any
+	suite.Require().NoError(msgpack.Unmarshal(res.Body.Bytes(), &decoded))
+	suite.Require().Len(decoded, 2)
+	suite.Assert().Equal("apple", decoded[0]["name"])
+	suite.Assert().EqualValues(3, decoded[0]["quantity"])
+	suite.Assert().Equal(1.5, decoded[0]["price"])
+
+	res = render("text/csv", items)
+	suite.Assert().Equal("text/csv; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("name,quantity,price,tags\napple,3,1.5,\n\"pear, green\",1,2,\"[\"\"fruit\"\"]\"\n", res.Body.String())
+
+	res = render("text/csv, application/yaml;q=0.5", 42)
+	suite.Assert().Equal("application/yaml", res.Header().Get("Content-Type"), "Values that cannot be sent as CSV should fall back to the next accepted type")
+	suite.Assert().Equal("42\n", res.Body.String())
+
+	res = render("text/csv", 42)
+	suite.Assert().Equal(http.StatusNotAcceptable, res.Code)
+
+	res = render("image/png", items)
+	suite.Assert().Equal(http.StatusNotAcceptable, res.Code)
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"))
+	var problem struct {
+		Available []string `json:"available"`
+	}
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &problem))
+	suite.Assert().Contains(problem.Available, "application/yaml")
+
+	RegisterRenderer("application/x-render-test", RendererFunc(func(w io.Writer, value any) error {
+		_, err := w.Write([]byte("test"))
+		return err
+	}))
+	res = render("application/x-render-test", items)
+	suite.Assert().Equal("application/x-render-test", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("test", res.Body.String())
+}
+
+func (suite *ServerSuite) TestShouldEscapeFormulasInCSV() {
+	items := []map[string]any{{"name": "=HYPERLINK(\"https://evil.com\")", "note": "+1", "total": -3}, {"name": "@SUM(A1)", "note": "-x", "total": 2}}
+	var output strings.Builder
+	suite.Require().NoError(CSVRenderer{}.Render(&output, items))
+	suite.Assert().Equal("name,note,total\n\"'=HYPERLINK(\"\"https://evil.com\"\")\",'+1,-3\n'@SUM(A1),'-x,2\n", output.String(), "Numbers should not be escaped")
+
+	output.Reset()
+	suite.Require().NoError(CSVRenderer{NoFormulaEscaping: true}.Render(&output, [][]any{{"=1+2", "\tx"}}))
+	suite.Assert().Equal("=1+2,\"\tx\"\n", output.String())
+}
+
+func (suite *ServerSuite) TestCanWriteProblemsInNegotiatedFormats() {
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("Accept", "application/yaml")
+	res := httptest.NewRecorder()
+	WriteProblem(res, req, NewProblem(http.StatusNotFound, "No such item").With("item", "apple"))
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	suite.Assert().Equal("application/yaml", res.Header().Get("Content-Type"))
+	suite.Assert().Contains(res.Body.String(), "status: 404\n")
+	suite.Assert().Contains(res.Body.String(), "detail: No such item\n")
+	suite.Assert().Contains(res.Body.String(), "item: apple\n")
+
+	req.Header.Set("Accept", "image/png")
+	res = httptest.NewRecorder()
+	WriteProblem(res, req, NewProblem(http.StatusNotFound, "No such item"))
+	suite.Assert().Equal(ProblemContentType, res.Header().Get("Content-Type"), "Problems should always be sent")
+}
@@ -0,0 +1,72 @@
+package wess
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/gildas/go-errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePackRenderer renders values as MessagePack
+//
+// The documents have the members of the JSON representation of the values, in the same order.
+type MessagePackRenderer struct{}
+
+// Render encodes the value as MessagePack
+//
+// implements Renderer
+func (renderer MessagePackRenderer) Render(w io.Writer, value any) error {
+	document, err := toOrderedJSON(value)
+	if err != nil {
+		return err
+	}
+	if err := encodeMessagePack(msgpack.NewEncoder(w), document); err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	return nil
+}
+
+// encodeMessagePack encodes a JSON representation as MessagePack
+func encodeMessagePack(encoder *msgpack.Encoder, value any) error {
+	switch value := value.(type) {
+	case *orderedObject:
+		if err := encoder.EncodeMapLen(len(value.keys)); err != nil {
+			return err
+		}
+		for _, key := range value.keys {
+			if err := encoder.EncodeString(key); err != nil {
+				return err
+			}
+			if err := encodeMessagePack(encoder, value.values[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		if err := encoder.EncodeArrayLen(len(value)); err != nil {
+			return err
+		}
+		for _, item := range value {
+			if err := encodeMessagePack(encoder, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case json.Number:
+		if number, err := value.Int64(); err == nil {
+			return encoder.EncodeInt(number)
+		}
+		number, err := value.Float64()
+		if err != nil {
+			return err
+		}
+		return encoder.EncodeFloat64(number)
+	case string:
+		return encoder.EncodeString(value)
+	case bool:
+		return encoder.EncodeBool(value)
+	default:
+		return encoder.EncodeNil()
+	}
+}
@@ -0,0 +1,70 @@
+package wess
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/gildas/go-errors"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLRenderer renders values as YAML
+//
+// The documents have the members of the JSON representation of the values, in the same order.
+type YAMLRenderer struct {
+	// Indent is the number of spaces to indent the documents with.
+	// Default: 2
+	Indent int
+}
+
+// Render encodes the value as YAML
+//
+// implements Renderer
+func (renderer YAMLRenderer) Render(w io.Writer, value any) error {
+	document, err := toOrderedJSON(value)
+	if err != nil {
+		return err
+	}
+	indent := renderer.Indent
+	if indent <= 0 {
+		indent = 2
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(yamlNode(document)); err != nil {
+		return errors.RuntimeError.Wrap(err)
+	}
+	return encoder.Close()
+}
+
+// yamlNode converts a JSON representation to a YAML node
+func yamlNode(value any) *yaml.Node {
+	switch value := value.(type) {
+	case *orderedObject:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range value.keys {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, yamlNode(value.values[key]))
+		}
+		return node
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range value {
+			node.Content = append(node.Content, yamlNode(item))
+		}
+		return node
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value.String()}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: value.String()}
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	case bool:
+		if value {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	}
+}
@@ -0,0 +1,92 @@
+package wess
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/gildas/go-errors"
+)
+
+// responseWriter is an http.ResponseWriter that keeps track of the status and of the written bytes
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+// newResponseWriter wraps the given http.ResponseWriter
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	if writer, ok := w.(*responseWriter); ok {
+		return writer
+	}
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader sends an HTTP response header with the provided status code
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 200 || status == http.StatusSwitchingProtocols {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the connection as part of an HTTP reply
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	written, err := w.ResponseWriter.Write(data)
+	w.written += int64(written)
+	return written, err
+}
+
+// Status gives the status that was sent
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+// Written gives the amount of bytes written in the body
+func (w *responseWriter) Written() int64 {
+	return w.written
+}
+
+// HeaderWritten tells if the header was already sent
+func (w *responseWriter) HeaderWritten() bool {
+	return w.wroteHeader
+}
+
+// Flush sends any buffered data to the client
+//
+// implements http.Flusher
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection
+//
+// implements http.Hijacker
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, errors.NotImplemented.Wrap(err)
+	}
+	w.wroteHeader = true
+	w.status = http.StatusSwitchingProtocols
+	return conn, buffer, nil
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -31,11 +31,42 @@ func (pfs protectedFileSystem) Open(filepath string) (http.File, error) {
 // AddFrontend adds a frontend to the server
 //
 // The frontend is a static website that will be served by the server.
-func (server Server) AddFrontend(path string, rootFS fs.FS, rootPath string) error {
-	websiteFS, err := fs.Sub(rootFS, rootPath)
+//
+// Errors (like 404 Not Found) are written as Problems (See WriteProblem).
+//
+// When the security headers are enabled, the CSP nonce of the request is injected
+// in the <script> tags of the HTML documents (See CSPNonce).
+//
+// Options can be given to configure the frontend route, like WithOIDC to require a login.
+func (server Server) AddFrontend(path string, rootFS fs.FS, rootPath string, options ...RouteOption) error {
+	handler, err := frontendHandler(path, rootFS, rootPath)
 	if err != nil {
 		return err
 	}
-	server.webrouter.PathPrefix(path).Handler(http.StripPrefix(path, http.FileServer(protectedFileSystem{http.FS(websiteFS)})))
+	config := newRouteConfig(options...)
+	server.register(server.webrouter.PathPrefix(path).Handler(config.wrap(handler)), config)
 	return nil
 }
+
+// AddFrontend adds a frontend to the group
+//
+// The frontend is served under the prefix of the group, for its host only when the group is scoped to a host (See Server.Host).
+// It behaves like the frontends of the server (See Server.AddFrontend).
+func (group *RouteGroup) AddFrontend(path string, rootFS fs.FS, rootPath string, options ...RouteOption) error {
+	handler, err := frontendHandler(group.prefix+path, rootFS, rootPath)
+	if err != nil {
+		return err
+	}
+	config := newRouteConfig(append(append([]RouteOption{}, group.options...), options...)...)
+	group.server.register(group.router.PathPrefix(path).Handler(config.wrap(handler)), config)
+	return nil
+}
+
+// frontendHandler serves the files of a frontend under the given path prefix
+func frontendHandler(path string, rootFS fs.FS, rootPath string) (http.Handler, error) {
+	websiteFS, err := fs.Sub(rootFS, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	return problemHandler(cspNonceHandler(http.StripPrefix(path, http.FileServer(protectedFileSystem{http.FS(websiteFS)})))), nil
+}
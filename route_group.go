@@ -0,0 +1,124 @@
+package wess
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteGroup is a group of routes that share a path prefix, middlewares and options
+//
+// The routes are added with Handle, HandleFunc, Get, Post, etc, which can be chained:
+//
+//	api := server.Group("/api/v1", authMiddleware).With(WithTags("api"))
+//	api.Get("/users", listUsers, WithName("users.list")).
+//	    Post("/users", createUser, WithName("users.create"), RequireScopes("users:write"))
+type RouteGroup struct {
+	server  Server
+	router  *mux.Router
+	prefix  string
+	host    string
+	options []RouteOption
+}
+
+// Group creates a group of routes under the given path prefix
+//
+// The middlewares are executed for the routes of the group only,
+// after the server's middlewares and before the middlewares of the routes.
+func (server Server) Group(prefix string, middlewares ...func(http.Handler) http.Handler) *RouteGroup {
+	route := server.webrouter.PathPrefix(prefix)
+	group := &RouteGroup{
+		server: server,
+		router: route.Subrouter(),
+		prefix: prefix,
+	}
+	for _, middleware := range middlewares {
+		group.router.Use(middleware)
+	}
+	server.routes.setRouter(route, middlewares)
+	return group
+}
+
+// Group creates a nested group of routes under the given path prefix
+//
+// The nested group inherits the middlewares, the options and the host of this group.
+func (group *RouteGroup) Group(prefix string, middlewares ...func(http.Handler) http.Handler) *RouteGroup {
+	route := group.router.PathPrefix(prefix)
+	nested := &RouteGroup{
+		server:  group.server,
+		router:  route.Subrouter(),
+		prefix:  group.prefix + prefix,
+		host:    group.host,
+		options: append([]RouteOption{}, group.options...),
+	}
+	for _, middleware := range middlewares {
+		nested.router.Use(middleware)
+	}
+	group.server.routes.setRouter(route, middlewares)
+	return nested
+}
+
+// With adds options to all the routes added to the group afterwards (See WithTags, RequireScopes, WithTimeout, etc)
+//
+// The options of the routes are applied after the options of the group.
+func (group *RouteGroup) With(options ...RouteOption) *RouteGroup {
+	group.options = append(group.options, options...)
+	return group
+}
+
+// Prefix gives the path prefix of the group
+func (group *RouteGroup) Prefix() string {
+	return group.prefix
+}
+
+// Router gives the gorilla/mux router of the group
+func (group *RouteGroup) Router() *mux.Router {
+	return group.router
+}
+
+// Handle adds a route to the group
+func (group *RouteGroup) Handle(method, path string, handler http.Handler, options ...RouteOption) *RouteGroup {
+	config := newRouteConfig(append(append([]RouteOption{}, group.options...), options...)...).describe(handler)
+	group.server.register(group.router.Methods(method).Path(path).Handler(config.wrap(handler)), config)
+	return group
+}
+
+// HandleFunc adds a route to the group
+func (group *RouteGroup) HandleFunc(method, path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(method, path, handlerFunc, options...)
+}
+
+// Get adds a GET route to the group
+func (group *RouteGroup) Get(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodGet, path, handlerFunc, options...)
+}
+
+// Head adds a HEAD route to the group
+func (group *RouteGroup) Head(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodHead, path, handlerFunc, options...)
+}
+
+// Post adds a POST route to the group
+func (group *RouteGroup) Post(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodPost, path, handlerFunc, options...)
+}
+
+// Put adds a PUT route to the group
+func (group *RouteGroup) Put(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodPut, path, handlerFunc, options...)
+}
+
+// Patch adds a PATCH route to the group
+func (group *RouteGroup) Patch(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodPatch, path, handlerFunc, options...)
+}
+
+// Delete adds a DELETE route to the group
+func (group *RouteGroup) Delete(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodDelete, path, handlerFunc, options...)
+}
+
+// Options adds an OPTIONS route to the group
+func (group *RouteGroup) Options(path string, handlerFunc http.HandlerFunc, options ...RouteOption) *RouteGroup {
+	return group.Handle(http.MethodOptions, path, handlerFunc, options...)
+}
@@ -0,0 +1,67 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (suite *ServerSuite) TestCanAddRoutesInGroups() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	tagger := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Group", "api")
+			next.ServeHTTP(w, r)
+		})
+	}
+	var metadata RouteMetadata
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		metadata = GetRouteMetadata(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+	api := server.Group("/api", tagger).With(WithTags("api"))
+	api.Get("/users", handler, WithName("users.list"), WithSummary("List the users")).
+		Post("/users", handler, WithName("users.create"), WithTimeout(5*time.Second))
+	api.Group("/admin").With(WithTags("admin"), RequireScopes("admin")).
+		Delete("/cache", handler)
+	server.AddRouteWithFunc(http.MethodGet, "/other", handler)
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(method, path, nil))
+		return res
+	}
+
+	res := send(http.MethodGet, "/api/users")
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+	suite.Assert().Equal("api", res.Header().Get("X-Group"))
+	suite.Assert().Equal("users.list", metadata.Name)
+	suite.Assert().Equal("List the users", metadata.Summary)
+	suite.Assert().Equal([]string{"api"}, metadata.Tags)
+
+	res = send(http.MethodPost, "/api/users")
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+	suite.Assert().Equal("users.create", metadata.Name)
+	suite.Assert().Equal(5*time.Second, metadata.Timeout)
+
+	res = send(http.MethodDelete, "/api/admin/cache")
+	suite.Assert().Equal(http.StatusUnauthorized, res.Code, "The nested group should require the admin scope")
+	suite.Assert().Equal("api", res.Header().Get("X-Group"), "The nested group should inherit the middlewares")
+	var admin RouteMetadata
+	for _, config := range server.routes.configs {
+		if config.metadata.Name == "/api/admin/cache" {
+			admin = config.metadata
+		}
+	}
+	suite.Assert().Equal([]string{"api", "admin"}, admin.Tags)
+	suite.Assert().Equal([]string{"admin"}, admin.Scopes)
+	suite.Assert().True(admin.AuthenticationRequired)
+
+	res = send(http.MethodGet, "/other")
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+	suite.Assert().Empty(res.Header().Get("X-Group"), "Other routes should not run the group middlewares")
+	suite.Assert().Equal("/other", metadata.Name, "The path template should be the default name")
+
+	suite.Assert().Equal(float64(1), server.Metrics().Get("wess_requests_total", "route", "users.list", "method", "GET", "status", "204"))
+	suite.Assert().Equal(float64(1), server.Metrics().Get("wess_requests_total", "route", "/api/admin/cache", "method", "DELETE", "status", "401"))
+}
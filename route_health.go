@@ -10,8 +10,19 @@ import (
 
 // healthRoutes adds the Health Routes to the given Router
 func (server *Server) healthRoutes(router *mux.Router) {
-	router.Methods("GET").Path("/liveness").Handler(healthHandler(server, "liveness"))
-	router.Methods("GET").Path("/readiness").Handler(healthHandler(server, "readiness"))
+	config := func(name string) *routeConfig {
+		return newRouteConfig(WithName(name), WithPriority(PriorityCritical), WithHidden())
+	}
+	server.routes.set(router.Methods("GET").Path("/liveness").Handler(healthHandler(server, "liveness")), config("health.liveness"))
+	server.routes.set(router.Methods("GET").Path("/readiness").Handler(healthHandler(server, "readiness")), config("health.readiness"))
+	if server.probeserver == nil {
+		// The metrics and the route table must not be served on the web port
+		return
+	}
+	server.routes.set(router.Methods("GET").Path("/metrics").Handler(metricsHandler(server.metrics)), config("health.metrics"))
+	if server.probeRoutes {
+		server.routes.set(router.Methods("GET").Path("/routes").Handler(routesHandler(server)), config("health.routes"))
+	}
 }
 
 // healthHandler handles the readiness probe
@@ -21,7 +32,12 @@ func healthHandler(server *Server, probename string) http.Handler {
 
 		if !server.IsReady() {
 			log.Errorf("Webserver not ready yet")
-			w.WriteHeader(http.StatusServiceUnavailable)
+			WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, "The server is not ready"))
+			return
+		}
+		if probename == "readiness" && server.limiter != nil && server.limiter.options.DegradeReadiness && server.limiter.IsShedding() {
+			log.Warnf("Webserver is shedding requests")
+			WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, "The server is overloaded"))
 			return
 		}
 		if core.GetEnvAsBool("TRACE_PROBE", false) {
@@ -0,0 +1,180 @@
+package wess
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Host creates a group of routes served only for the requests whose host matches the given pattern
+//
+// The pattern follows the gorilla/mux host templates, like "api.example.com" or "{tenant}.example.com".
+// The group can have its own frontend (See RouteGroup.AddFrontend), NotFound handler (See RouteGroup.SetNotFoundHandler),
+// CORS policy (See RouteGroup.SetCORS) and TLS certificate (See RouteGroup.SetCertificate).
+//
+// The routes of the server that are not scoped to a host are served for all the hosts,
+// the routes added first win.
+// The requests for hosts that match no group are given to ServerOptions.UnknownHostHandler, if any.
+//
+// The middlewares are executed for the routes of the group only,
+// after the server's middlewares and before the middlewares of the routes.
+func (server Server) Host(pattern string, middlewares ...func(http.Handler) http.Handler) *RouteGroup {
+	route := server.webrouter.Host(pattern)
+	group := &RouteGroup{
+		server: server,
+		router: route.Subrouter(),
+		host:   pattern,
+	}
+	for _, middleware := range middlewares {
+		group.router.Use(middleware)
+	}
+	server.routes.setRouter(route, middlewares)
+	server.hosts.add(pattern)
+	return group
+}
+
+// Host gives the host pattern of the group, empty if the group is not scoped to a host (See Server.Host)
+func (group *RouteGroup) Host() string {
+	return group.host
+}
+
+// SetNotFoundHandler sets the handler of the requests of the group that match none of its routes
+//
+// By default, these requests are matched against the other routes of the server,
+// and get the server's NotFound handler if none matches.
+func (group *RouteGroup) SetNotFoundHandler(handler http.Handler) *RouteGroup {
+	group.router.NotFoundHandler = handler
+	return group
+}
+
+// SetCORS attaches a CORS policy to the requests of the group
+//
+// The policies of the routes (See WithCORS) win over it.
+// The policy of a group scoped to a host wins over the policies attached to path prefixes (See Server.SetCORS).
+// If the policy is nil, it is detached.
+func (group *RouteGroup) SetCORS(policy *CORSPolicy) *RouteGroup {
+	group.server.cors.setPrefix(group.host, group.prefix, policy)
+	return group
+}
+
+// SetCertificate sets the TLS certificate sent to the clients that ask for the host of the group
+//
+// The certificate is selected with the Server Name Indication (SNI) of the TLS handshake,
+// the clients that ask for other hosts get the certificates of ServerOptions.TLSConfig.
+//
+// The certificate is used only if the server has a TLSConfig and ServeTLS, it is ignored if the group is not scoped to a host.
+func (group *RouteGroup) SetCertificate(certificate *tls.Certificate) *RouteGroup {
+	if len(group.host) > 0 {
+		group.server.hosts.setCertificate(group.host, certificate)
+	}
+	return group
+}
+
+// virtualHosts contains the hosts served by a server (See Server.Host)
+type virtualHosts struct {
+	mutex   sync.RWMutex
+	hosts   []*virtualHost
+	unknown http.Handler
+}
+
+// virtualHost is a host served by a server
+type virtualHost struct {
+	pattern     string
+	matcher     *mux.Route
+	certificate *tls.Certificate
+}
+
+// add adds a host, unless it exists already
+func (hosts *virtualHosts) add(pattern string) *virtualHost {
+	hosts.mutex.Lock()
+	defer hosts.mutex.Unlock()
+	for _, host := range hosts.hosts {
+		if host.pattern == pattern {
+			return host
+		}
+	}
+	host := &virtualHost{pattern: pattern, matcher: hostMatcher(pattern)}
+	hosts.hosts = append(hosts.hosts, host)
+	return host
+}
+
+// setCertificate sets the certificate of a host
+func (hosts *virtualHosts) setCertificate(pattern string, certificate *tls.Certificate) {
+	host := hosts.add(pattern)
+	hosts.mutex.Lock()
+	defer hosts.mutex.Unlock()
+	host.certificate = certificate
+}
+
+// find finds the host that matches the given name
+//
+// returns nil if no host matches
+func (hosts *virtualHosts) find(name string) *virtualHost {
+	hosts.mutex.RLock()
+	defer hosts.mutex.RUnlock()
+	for _, host := range hosts.hosts {
+		if matchHost(host.matcher, name) {
+			return host
+		}
+	}
+	return nil
+}
+
+// getCertificate gives the certificate of the host asked by the client
+//
+// If no host matches, the given getCertificate function is called, if any.
+// When both give no certificate, crypto/tls uses the certificates of the TLS configuration.
+func (hosts *virtualHosts) getCertificate(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if len(hello.ServerName) > 0 {
+			if host := hosts.find(hello.ServerName); host != nil {
+				hosts.mutex.RLock()
+				certificate := host.certificate
+				hosts.mutex.RUnlock()
+				if certificate != nil {
+					return certificate, nil
+				}
+			}
+		}
+		if getCertificate != nil {
+			return getCertificate(hello)
+		}
+		return nil, nil
+	}
+}
+
+// middleware gives the requests for unknown hosts to the UnknownHostHandler
+//
+// The health routes are never given to the UnknownHostHandler.
+func (hosts *virtualHosts) middleware(healthRootPath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(healthRootPath) > 0 && strings.HasPrefix(r.URL.Path, healthRootPath) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			hosts.mutex.RLock()
+			count := len(hosts.hosts)
+			hosts.mutex.RUnlock()
+			if count == 0 || hosts.find(r.Host) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			hosts.unknown.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hostMatcher creates a route that matches the given host pattern only
+func hostMatcher(pattern string) *mux.Route {
+	return mux.NewRouter().Host(pattern)
+}
+
+// matchHost tells if the host matches the host matcher
+func matchHost(matcher *mux.Route, host string) bool {
+	return matcher.Match(&http.Request{Host: host, URL: &url.URL{}}, &mux.RouteMatch{})
+}
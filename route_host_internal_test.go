@@ -0,0 +1,139 @@
+package wess
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing/fstest"
+)
+
+func (suite *ServerSuite) TestCanServeVirtualHosts() {
+	server := NewServer(ServerOptions{
+		Logger: suite.Logger,
+		UnknownHostHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			WriteProblem(w, r, NewProblem(http.StatusMisdirectedRequest, "Unknown host"))
+		}),
+	})
+	handler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(body)) }
+	}
+	server.AddRouteWithFunc(http.MethodGet, "/version", handler("1.0"))
+	api := server.Host("api.example.com").SetCORS(NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}))
+	api.Get("/users/{id}", handler("user"), WithName("api.users.get"))
+	api.SetNotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, "No such API"))
+	}))
+	admin := server.Host("admin.example.com")
+	admin.Get("/users/{id}", handler("admin user"))
+	app := server.Host("{tenant}.app.example.com")
+	suite.Require().NoError(app.AddFrontend("/", fstest.MapFS{"web/index.html": {Data: []byte("<html>app</html>")}}, "web"))
+
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve("api.example.com", "/users/1")
+	suite.Assert().Equal("user", res.Body.String())
+	suite.Assert().Equal("https://app.example.com", res.Header().Get("Access-Control-Allow-Origin"), "The host should have its own CORS policy")
+
+	res = serve("admin.example.com:8080", "/users/1")
+	suite.Assert().Equal("admin user", res.Body.String())
+	suite.Assert().Empty(res.Header().Get("Access-Control-Allow-Origin"), "The CORS policy of a host should not apply to the other hosts")
+
+	res = serve("acme.app.example.com", "/")
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Contains(res.Body.String(), "app")
+
+	res = serve("api.example.com", "/version")
+	suite.Assert().Equal("1.0", res.Body.String(), "The routes added before the hosts should be served for all the hosts")
+
+	res = serve("api.example.com", "/orders")
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	suite.Assert().Contains(res.Body.String(), "No such API")
+
+	res = serve("admin.example.com", "/orders")
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	suite.Assert().NotContains(res.Body.String(), "No such API")
+
+	res = serve("www.evil.com", "/users/1")
+	suite.Assert().Equal(http.StatusMisdirectedRequest, res.Code)
+
+	location, err := server.URLFor("api.users.get", "id", "42")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("http://api.example.com/users/42", location.String())
+
+	var info *RouteInfo
+	for _, route := range server.Routes() {
+		if route.Name == "api.users.get" {
+			info = &route
+		}
+	}
+	suite.Require().NotNil(info)
+	suite.Assert().Equal("api.example.com", info.Host)
+}
+
+func (suite *ServerSuite) TestCanSelectCertificatesOfVirtualHosts() {
+	fallback := &tls.Certificate{}
+	config := &tls.Config{Certificates: []tls.Certificate{*fallback}}
+	server := NewServer(ServerOptions{Logger: suite.Logger, Port: 443, TLSConfig: config, ServeTLS: true})
+	suite.Assert().Nil(config.GetCertificate, "The TLS configuration of the caller should not be changed")
+	apiCertificate, appCertificate := &tls.Certificate{}, &tls.Certificate{}
+	server.Host("api.example.com").SetCertificate(apiCertificate)
+	server.Host("{tenant}.app.example.com").SetCertificate(appCertificate)
+	server.Host("admin.example.com")
+
+	tlsConfig := server.webserver.TLSConfig
+	getCertificate := tlsConfig.GetCertificate
+	suite.Require().NotNil(getCertificate)
+	certificate, err := getCertificate(&tls.ClientHelloInfo{ServerName: "api.example.com"})
+	suite.Require().NoError(err)
+	suite.Assert().Same(apiCertificate, certificate)
+
+	certificate, err = getCertificate(&tls.ClientHelloInfo{ServerName: "acme.app.example.com"})
+	suite.Require().NoError(err)
+	suite.Assert().Same(appCertificate, certificate)
+
+	certificate, err = getCertificate(&tls.ClientHelloInfo{ServerName: "admin.example.com"})
+	suite.Require().NoError(err)
+	suite.Assert().Nil(certificate, "Hosts without certificates should get the certificates of the TLS configuration")
+
+	certificate, err = getCertificate(&tls.ClientHelloInfo{ServerName: "www.evil.com"})
+	suite.Require().NoError(err)
+	suite.Assert().Nil(certificate)
+
+	adminCertificate := &tls.Certificate{}
+	server.Host("admin.example.com").SetCertificate(adminCertificate)
+	suite.Assert().Same(tlsConfig, server.webserver.TLSConfig, "The TLS configuration should not be replaced, it is cloned when the server starts")
+	certificate, err = getCertificate(&tls.ClientHelloInfo{ServerName: "admin.example.com"})
+	suite.Require().NoError(err)
+	suite.Assert().Same(adminCertificate, certificate, "Certificates set later should be selected")
+}
+
+func (suite *ServerSuite) TestShouldKeepServingHTTPWithTLSConfig() {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	server := NewServer(ServerOptions{Logger: suite.Logger, Port: 9896, ProbePort: 9895, TLSConfig: config})
+	server.Host("api.example.com")
+	server.Host("www.example.com").SetCertificate(&tls.Certificate{})
+	suite.Assert().Same(config, server.webserver.TLSConfig, "The TLS configuration should not be changed without ServeTLS")
+	suite.Assert().Nil(server.webserver.TLSConfig.GetCertificate)
+	server.AddRouteWithFunc(http.MethodGet, "/version", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("1.0"))
+	})
+	shutdown, stop, err := server.Start(context.Background())
+	suite.Require().NoError(err, "Failed starting the server")
+
+	for _, target := range []string{"http://localhost:9896/version", "http://localhost:9895/healthz/liveness"} {
+		res, err := http.Get(target)
+		suite.Require().NoError(err, "The servers should be served over HTTP unless ServeTLS is set")
+		_ = res.Body.Close()
+		suite.Assert().Equal(http.StatusOK, res.StatusCode, target)
+	}
+	stop <- os.Interrupt
+	suite.Require().NoError(<-shutdown)
+}
@@ -2,18 +2,50 @@ package wess
 
 import (
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/gildas/go-logger"
+	"github.com/gorilla/mux"
 )
 
 // methodNotAllowedHandler is the handler for the 405 Method Not Allowed
-func methodNotAllowedHandler(log *logger.Logger) http.Handler {
+//
+// The Allow header is set with the methods of the routes of the given router that match the request.
+func methodNotAllowedHandler(log *logger.Logger, router *mux.Router) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := logger.Must(logger.FromContext(r.Context(), log)).Child(nil, "notallowed")
 
 		log.Debugf("Request Headers: %#+v", r.Header)
 		log.Errorf("Method Not Allowed: %s %s", r.Method, r.URL.String())
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_, _ = w.Write([]byte("405 Method Not Allowed"))
+		if methods := allowedMethods(router, r); len(methods) > 0 {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+		}
+		WriteProblem(w, r, NewProblem(http.StatusMethodNotAllowed, ""))
 	})
 }
+
+// allowedMethods walks the router and collects the methods of the routes that match the request
+func allowedMethods(router *mux.Router, r *http.Request) (methods []string) {
+	if router == nil {
+		return
+	}
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range routeMethods {
+			if slices.Contains(methods, method) {
+				continue
+			}
+			candidate := r.Clone(r.Context())
+			candidate.Method = method
+			if route.Match(candidate, &mux.RouteMatch{}) {
+				methods = append(methods, method)
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -13,7 +13,6 @@ func notFoundHandler(log *logger.Logger) http.Handler {
 
 		log.Debugf("Request Headers: %#+v", r.Header)
 		log.Errorf("Route not found: %s %s", r.Method, r.URL.String())
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = w.Write([]byte("404 Not Found"))
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ""))
 	})
 }
@@ -0,0 +1,314 @@
+package wess
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteOption configures a route added with AddRoute or AddRouteWithFunc
+type RouteOption func(*routeConfig)
+
+// routeConfig contains the configuration of a route
+type routeConfig struct {
+	middlewares []func(http.Handler) http.Handler
+	priority    Priority
+	cors        *CORSPolicy
+	metadata    RouteMetadata
+}
+
+// RouteMetadata describes a route
+//
+// The metadata is shown in the logs when the server starts, it labels the metrics of the route
+// and it documents the route.
+type RouteMetadata struct {
+	// Name identifies the route in the logs and the metrics.
+	// Default: the path template of the route
+	Name string `json:"name"`
+
+	// Summary is a short description of the route
+	Summary string `json:"summary,omitempty"`
+
+	// Description is a longer description of the route
+	Description string `json:"description,omitempty"`
+
+	// Tags group the routes in the documentation
+	Tags []string `json:"tags,omitempty"`
+
+	// Authentication is the list of authentication schemes accepted by the route (Basic, Bearer, APIKey, OIDC)
+	// (See WithAuthentication and WithOIDC)
+	Authentication []string `json:"authentication,omitempty"`
+
+	// AuthenticationRequired tells if the route requires an authenticated client
+	// (See RequireScopes and WithOIDC)
+	AuthenticationRequired bool `json:"authenticationRequired,omitempty"`
+
+	// Scopes is the list of scopes required by the route (See RequireScopes)
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Timeout is the deadline of the route's handler (See WithTimeout)
+	Timeout time.Duration `json:"-"`
+
+	// RequestBody is the type of the request body (See WithRequestBody)
+	RequestBody reflect.Type `json:"-"`
+
+	// Responses are the types of the response bodies, by status (See WithResponse).
+	// A nil type means the response has no body.
+	Responses map[int]reflect.Type `json:"-"`
+
+	// Hidden routes are not documented (See WithHidden)
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// MarshalJSON marshals the metadata into JSON
+//
+// The timeout is written as a duration string, like "5s", and the body types with their Go names.
+//
+// implements json.Marshaler
+func (metadata RouteMetadata) MarshalJSON() ([]byte, error) {
+	type surrogate RouteMetadata
+	var timeout, requestBody string
+	if metadata.Timeout > 0 {
+		timeout = metadata.Timeout.String()
+	}
+	if metadata.RequestBody != nil {
+		requestBody = metadata.RequestBody.String()
+	}
+	var responses map[string]string
+	if len(metadata.Responses) > 0 {
+		responses = make(map[string]string, len(metadata.Responses))
+		for status, body := range metadata.Responses {
+			responses[strconv.Itoa(status)] = ""
+			if body != nil {
+				responses[strconv.Itoa(status)] = body.String()
+			}
+		}
+	}
+	return json.Marshal(struct {
+		surrogate
+		Timeout     string            `json:"timeout,omitempty"`
+		RequestBody string            `json:"requestBody,omitempty"`
+		Responses   map[string]string `json:"responses,omitempty"`
+	}{
+		surrogate:   surrogate(metadata),
+		Timeout:     timeout,
+		RequestBody: requestBody,
+		Responses:   responses,
+	})
+}
+
+// WithMiddleware adds middlewares to a route
+//
+// The middlewares are executed in the given order, after the server's middlewares.
+func WithMiddleware(middlewares ...func(http.Handler) http.Handler) RouteOption {
+	return func(config *routeConfig) {
+		config.middlewares = append(config.middlewares, middlewares...)
+	}
+}
+
+// WithPriority sets the priority of a route
+//
+// When the server is overloaded, requests of lower priority routes are shed first
+// (See ServerOptions.ConcurrencyLimit).
+func WithPriority(priority Priority) RouteOption {
+	return func(config *routeConfig) {
+		config.priority = priority
+	}
+}
+
+// WithName sets the name of a route, used in the logs and the metrics
+func WithName(name string) RouteOption {
+	return func(config *routeConfig) {
+		config.metadata.Name = name
+	}
+}
+
+// WithSummary sets the summary of a route
+func WithSummary(summary string) RouteOption {
+	return func(config *routeConfig) {
+		config.metadata.Summary = summary
+	}
+}
+
+// WithDescription sets the description of a route
+func WithDescription(description string) RouteOption {
+	return func(config *routeConfig) {
+		config.metadata.Description = description
+	}
+}
+
+// WithTags adds tags to a route
+func WithTags(tags ...string) RouteOption {
+	return func(config *routeConfig) {
+		for _, tag := range tags {
+			if !slices.Contains(config.metadata.Tags, tag) {
+				config.metadata.Tags = append(config.metadata.Tags, tag)
+			}
+		}
+	}
+}
+
+// WithRequestBody documents the type of the request body of a route
+//
+// The value is only used for its type, e.g. WithRequestBody(User{}).
+func WithRequestBody(value any) RouteOption {
+	return func(config *routeConfig) {
+		config.metadata.RequestBody = reflect.TypeOf(value)
+	}
+}
+
+// WithResponse documents a response of a route
+//
+// The value is only used for its type, e.g. WithResponse(http.StatusOK, []User{}).
+// If the value is nil, the response has no body.
+func WithResponse(status int, value any) RouteOption {
+	return func(config *routeConfig) {
+		responses := make(map[int]reflect.Type, len(config.metadata.Responses)+1)
+		maps.Copy(responses, config.metadata.Responses)
+		responses[status] = reflect.TypeOf(value)
+		config.metadata.Responses = responses
+	}
+}
+
+// WithHidden hides a route from the documentation
+func WithHidden() RouteOption {
+	return func(config *routeConfig) {
+		config.metadata.Hidden = true
+	}
+}
+
+// GetRouteMetadata gets the metadata of the route matched by the request
+//
+// returns an empty RouteMetadata if the route was not added with AddRoute or a RouteGroup
+func GetRouteMetadata(r *http.Request) RouteMetadata {
+	if metadata, ok := r.Context().Value(routeMetadataContextKey{}).(RouteMetadata); ok {
+		return metadata
+	}
+	return RouteMetadata{}
+}
+
+// routeMetadataContextKey is the context key of the route metadata
+type routeMetadataContextKey struct{}
+
+// newRouteConfig creates a route configuration from the given options
+func newRouteConfig(options ...RouteOption) *routeConfig {
+	config := &routeConfig{}
+	for _, option := range options {
+		if option != nil {
+			option(config)
+		}
+	}
+	return config
+}
+
+// describe documents the request and response types of typed handlers (See JSON)
+//
+// The types given with WithRequestBody and WithResponse win.
+func (config *routeConfig) describe(handler http.Handler) *routeConfig {
+	typed, ok := handler.(interface {
+		bodyTypes() (reflect.Type, map[int]reflect.Type)
+	})
+	if !ok {
+		return config
+	}
+	request, responses := typed.bodyTypes()
+	if config.metadata.RequestBody == nil {
+		config.metadata.RequestBody = request
+	}
+	maps.Copy(responses, config.metadata.Responses)
+	config.metadata.Responses = responses
+	return config
+}
+
+// wrap wraps the handler with the middlewares of the route
+func (config routeConfig) wrap(handler http.Handler) http.Handler {
+	for index := len(config.middlewares) - 1; index >= 0; index-- {
+		handler = config.middlewares[index](handler)
+	}
+	return handler
+}
+
+// routeRegistry keeps the configuration of the routes added to a server
+type routeRegistry struct {
+	mutex   sync.RWMutex
+	configs map[*mux.Route]*routeConfig
+	routers map[*mux.Route][]string // the names of the middlewares of the subrouters, by their parent route
+}
+
+// newRouteRegistry creates a new routeRegistry
+func newRouteRegistry() *routeRegistry {
+	return &routeRegistry{configs: map[*mux.Route]*routeConfig{}, routers: map[*mux.Route][]string{}}
+}
+
+// setRouter stores the middlewares of the subrouter of a route
+func (registry *routeRegistry) setRouter(route *mux.Route, middlewares []func(http.Handler) http.Handler) {
+	if len(middlewares) == 0 {
+		return
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.routers[route] = middlewareNames(middlewares)
+}
+
+// routerMiddlewares gives the names of the middlewares of the subrouter of a route
+func (registry *routeRegistry) routerMiddlewares(route *mux.Route) []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.routers[route]
+}
+
+// set stores the configuration of a route
+func (registry *routeRegistry) set(route *mux.Route, config *routeConfig) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.configs[route] = config
+}
+
+// get gets the configuration of a route
+//
+// returns nil if the route was not registered
+func (registry *routeRegistry) get(route *mux.Route) *routeConfig {
+	if registry == nil || route == nil {
+		return nil
+	}
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.configs[route]
+}
+
+// middleware stores the metadata of the matched route in the request context
+// and collects the request metrics of the route
+func (registry *routeRegistry) middleware(metrics *Metrics) func(http.Handler) http.Handler {
+	metrics.Describe("wess_requests_total", CounterMetric, "Number of requests handled, by route, method and status")
+	metrics.Describe("wess_request_duration_seconds_total", CounterMetric, "Total time spent handling requests, by route and method")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var metadata RouteMetadata
+			if config := registry.forRequest(r); config != nil {
+				metadata = config.metadata
+			} else if route := mux.CurrentRoute(r); route != nil {
+				metadata.Name, _ = route.GetPathTemplate()
+			}
+			start := time.Now()
+			writer := newResponseWriter(w)
+			next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), routeMetadataContextKey{}, metadata)))
+			metrics.Inc("wess_requests_total", "route", metadata.Name, "method", r.Method, "status", strconv.Itoa(writer.Status()))
+			metrics.Add("wess_request_duration_seconds_total", time.Since(start).Seconds(), "route", metadata.Name, "method", r.Method)
+		})
+	}
+}
+
+// forRequest gets the configuration of the route matched by the request
+//
+// returns nil if the route was not registered
+func (registry *routeRegistry) forRequest(r *http.Request) *routeConfig {
+	return registry.get(mux.CurrentRoute(r))
+}
@@ -0,0 +1,113 @@
+package wess
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteInfo describes a route of the server (See Server.Routes)
+type RouteInfo struct {
+	// Router is the router of the route: "web" or "probe"
+	Router string `json:"router"`
+
+	// Methods are the HTTP methods of the route, empty if the route matches all methods
+	Methods []string `json:"methods,omitempty"`
+
+	// Host is the host template of the route, like "api.example.com", empty if the route is served for all the hosts
+	Host string `json:"host,omitempty"`
+
+	// Path is the path template of the route, like "/users/{id:[0-9]+}"
+	Path string `json:"path,omitempty"`
+
+	// Regexp is the regular expression that matches the paths of the route
+	Regexp string `json:"regexp,omitempty"`
+
+	// Name is the name of the route (See WithName)
+	Name string `json:"name,omitempty"`
+
+	// Middlewares are the middlewares executed before the handler of the route, in execution order:
+	// the middlewares of the server, of the subrouters and groups, and of the route (See WithMiddleware).
+	Middlewares []string `json:"middlewares,omitempty"`
+
+	// Metadata is the metadata of the route,
+	// nil if the route was not added with AddRoute, AddFrontend or a RouteGroup
+	Metadata *RouteMetadata `json:"metadata,omitempty"`
+}
+
+// Routes gives the route table of the server
+//
+// The routes of the probe server come after the routes of the webserver,
+// they are added when the server starts.
+// Subrouters (See SubRouter and Group) are listed before their routes.
+func (server Server) Routes() []RouteInfo {
+	routes := server.routeTable(server.webrouter, "web")
+	if server.probeserver != nil {
+		routes = append(routes, server.routeTable(server.probeserver.Handler.(*mux.Router), "probe")...)
+	}
+	return routes
+}
+
+// routeTable walks the router and describes its routes
+func (server Server) routeTable(router *mux.Router, name string) []RouteInfo {
+	var chain []string
+	if name == "web" {
+		chain = server.middlewares
+	} else if name == "probe" {
+		chain = []string{"logger", "recovery"}
+	}
+	routes := []RouteInfo{}
+	_ = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		info := RouteInfo{Router: name, Name: route.GetName()}
+		info.Methods, _ = route.GetMethods()
+		info.Host, _ = route.GetHostTemplate()
+		info.Path, _ = route.GetPathTemplate()
+		info.Regexp, _ = route.GetPathRegexp()
+		info.Middlewares = append(info.Middlewares, chain...)
+		for _, ancestor := range ancestors {
+			info.Middlewares = append(info.Middlewares, server.routes.routerMiddlewares(ancestor)...)
+		}
+		if config := server.routes.get(route); config != nil {
+			metadata := config.metadata
+			info.Name = metadata.Name
+			info.Metadata = &metadata
+			info.Middlewares = append(info.Middlewares, middlewareNames(config.middlewares)...)
+		}
+		routes = append(routes, info)
+		return nil
+	})
+	return routes
+}
+
+// routesHandler serves the route table of the server
+func routesHandler(server *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Render(w, r, http.StatusOK, server.Routes())
+	})
+}
+
+// closureSuffix matches the suffix of the names of closures and method values
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$|-fm$`)
+
+// middlewareNames gives the names of the functions of the middlewares
+//
+// Closures are named after the function that creates them, like "wess.AuthenticationMiddleware".
+func middlewareNames(middlewares []func(http.Handler) http.Handler) []string {
+	names := make([]string, 0, len(middlewares))
+	for _, middleware := range middlewares {
+		name := "unknown"
+		if function := runtime.FuncForPC(reflect.ValueOf(middleware).Pointer()); function != nil {
+			name = function.Name()
+			if slash := strings.LastIndex(name, "/"); slash >= 0 {
+				name = name[slash+1:]
+			}
+			name = closureSuffix.ReplaceAllString(name, "")
+		}
+		names = append(names, name)
+	}
+	return names
+}
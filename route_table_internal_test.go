@@ -0,0 +1,80 @@
+package wess
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (suite *ServerSuite) TestCanGetRouteTable() {
+	server := NewServer(ServerOptions{
+		Logger:      suite.Logger,
+		ProbePort:   8000,
+		ProbeRoutes: true,
+		RateLimit:   &RateLimitPolicy{Limit: 100, Window: time.Minute},
+	})
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	server.AddRouteWithFunc(http.MethodGet, "/users/{id:[0-9]+}", handler, WithName("users.get"), WithTimeout(5*time.Second))
+	server.Group("/api", MaxBodySizeMiddleware(1024)).Post("/orders", handler, WithSummary("Create an order"), WithTags("orders"))
+	server.healthRoutes(server.proberouter)
+
+	routes := server.Routes()
+	find := func(router, path string) *RouteInfo {
+		for _, route := range routes {
+			if route.Router == router && route.Path == path && len(route.Methods) > 0 {
+				return &route
+			}
+		}
+		return nil
+	}
+
+	user := find("web", "/users/{id:[0-9]+}")
+	suite.Require().NotNil(user)
+	suite.Assert().Equal([]string{http.MethodGet}, user.Methods)
+	suite.Assert().Equal("users.get", user.Name)
+	suite.Assert().Equal("^/users/(?P<v0>[0-9]+)[/]?$", user.Regexp)
+	suite.Assert().Equal([]string{"cors", "logger", "routes", "recovery", "ratelimit", "wess.TimeoutMiddleware"}, user.Middlewares)
+	suite.Require().NotNil(user.Metadata)
+	suite.Assert().Equal(5*time.Second, user.Metadata.Timeout)
+
+	order := find("web", "/api/orders")
+	suite.Require().NotNil(order)
+	suite.Assert().Equal("/api/orders", order.Name)
+	suite.Assert().Equal([]string{"cors", "logger", "routes", "recovery", "ratelimit", "wess.MaxBodySizeMiddleware"}, order.Middlewares)
+	suite.Assert().Equal("Create an order", order.Metadata.Summary)
+
+	liveness := find("probe", "/healthz/liveness")
+	suite.Require().NotNil(liveness, "The probe routes should be listed")
+	suite.Assert().Equal("health.liveness", liveness.Name)
+	suite.Assert().Equal([]string{"logger", "recovery"}, liveness.Middlewares)
+
+	res := httptest.NewRecorder()
+	server.probeserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz/routes", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("application/json", res.Header().Get("Content-Type"))
+	var table []map[string]any
+	suite.Require().NoError(json.Unmarshal(res.Body.Bytes(), &table))
+	suite.Assert().Len(table, len(server.Routes()))
+	for _, route := range table {
+		if route["name"] == "users.get" {
+			suite.Assert().Equal("5s", route["metadata"].(map[string]any)["timeout"])
+		}
+	}
+}
+
+func (suite *ServerSuite) TestShouldNotServeRouteTableByDefault() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, ProbePort: 8000})
+	server.healthRoutes(server.proberouter)
+	res := httptest.NewRecorder()
+	server.probeserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz/routes", nil))
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+}
+
+func (suite *ServerSuite) TestShouldNotServeRouteTableOnWebPort() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, ProbePort: 80, ProbeRoutes: true})
+	server.healthRoutes(server.proberouter)
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz/routes", nil))
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,353 @@
+package wess
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gildas/go-logger"
+)
+
+// SecurityHeaders defines the security headers sent with every response
+//
+// Empty fields get their default value, set a field to "-" to not send the header.
+//
+// In ContentSecurityPolicy, "{nonce}" is replaced with a nonce generated for each request (See CSPNonce).
+type SecurityHeaders struct {
+	// ContentTypeOptions is the value of the X-Content-Type-Options header.
+	// Default: "nosniff"
+	ContentTypeOptions string
+
+	// FrameOptions is the value of the X-Frame-Options header.
+	// Default: "DENY"
+	FrameOptions string
+
+	// ReferrerPolicy is the value of the Referrer-Policy header.
+	// Default: "strict-origin-when-cross-origin"
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the value of the Permissions-Policy header.
+	// Default: "camera=(), microphone=(), geolocation=(), payment=()"
+	PermissionsPolicy string
+
+	// CrossOriginOpenerPolicy is the value of the Cross-Origin-Opener-Policy header.
+	// Default: "same-origin"
+	CrossOriginOpenerPolicy string
+
+	// StrictTransportSecurity is the value of the Strict-Transport-Security header,
+	// it is sent only over HTTPS.
+	// Default: "max-age=63072000; includeSubDomains"
+	StrictTransportSecurity string
+
+	// ContentSecurityPolicy is the value of the Content-Security-Policy header.
+	// Default: DefaultContentSecurityPolicy
+	ContentSecurityPolicy string
+
+	// ContentSecurityPolicyReportOnly, if true, sends the policy in the
+	// Content-Security-Policy-Report-Only header, violations are reported but not blocked.
+	ContentSecurityPolicyReportOnly bool
+
+	// CSPReportPath is the path of the endpoint that receives and logs the CSP violation reports.
+	// When set, the report-uri and report-to directives are added to the policy.
+	// If empty, violations are not reported.
+	CSPReportPath string
+}
+
+// DefaultContentSecurityPolicy is the default Content-Security-Policy
+//
+// Scripts and styles must come from the server or carry the request nonce (See CSPNonce).
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// cspNonceContextKey is the context key of the CSP nonce
+type cspNonceContextKey struct{}
+
+// CSPNonce gives the Content-Security-Policy nonce of the request
+//
+// Templates can use it in the nonce attribute of their <script> and <style> tags.
+// The index.html files served by AddFrontend get it automatically.
+//
+// returns an empty string if the security headers are not enabled
+func CSPNonce(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if nonce, ok := r.Context().Value(cspNonceContextKey{}).(string); ok {
+		return nonce
+	}
+	return ""
+}
+
+// withDefaults sets the default values of the security headers
+func (headers SecurityHeaders) withDefaults() SecurityHeaders {
+	defaultValue := func(value *string, defaultValue string) {
+		if len(*value) == 0 {
+			*value = defaultValue
+		}
+	}
+	defaultValue(&headers.ContentTypeOptions, "nosniff")
+	defaultValue(&headers.FrameOptions, "DENY")
+	defaultValue(&headers.ReferrerPolicy, "strict-origin-when-cross-origin")
+	defaultValue(&headers.PermissionsPolicy, "camera=(), microphone=(), geolocation=(), payment=()")
+	defaultValue(&headers.CrossOriginOpenerPolicy, "same-origin")
+	defaultValue(&headers.StrictTransportSecurity, "max-age=63072000; includeSubDomains")
+	defaultValue(&headers.ContentSecurityPolicy, DefaultContentSecurityPolicy)
+	if len(headers.CSPReportPath) > 0 && headers.ContentSecurityPolicy != "-" {
+		headers.ContentSecurityPolicy = strings.TrimRight(headers.ContentSecurityPolicy, "; ") + "; report-uri " + headers.CSPReportPath + "; report-to csp-endpoint"
+	}
+	return headers
+}
+
+// SecurityHeadersMiddleware sends the security headers with every response
+//
+// It can be used on subrouters with Use, or on routes (See WithSecurityHeaders),
+// to override the headers set globally (See ServerOptions.SecurityHeaders).
+// The CSP nonce of the request is kept when overriding.
+func SecurityHeadersMiddleware(headers SecurityHeaders) func(http.Handler) http.Handler {
+	headers = headers.withDefaults()
+	usesNonce := strings.Contains(headers.ContentSecurityPolicy, "{nonce}")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := CSPNonce(r)
+			if len(nonce) == 0 && usesNonce {
+				nonce = newCSPNonce()
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceContextKey{}, nonce))
+			}
+
+			header := w.Header()
+			setHeader := func(name, value string) {
+				if value == "-" {
+					header.Del(name)
+				} else {
+					header.Set(name, value)
+				}
+			}
+			setHeader("X-Content-Type-Options", headers.ContentTypeOptions)
+			setHeader("X-Frame-Options", headers.FrameOptions)
+			setHeader("Referrer-Policy", headers.ReferrerPolicy)
+			setHeader("Permissions-Policy", headers.PermissionsPolicy)
+			setHeader("Cross-Origin-Opener-Policy", headers.CrossOriginOpenerPolicy)
+			if GetClientInfo(r).Scheme == "https" {
+				setHeader("Strict-Transport-Security", headers.StrictTransportSecurity)
+			}
+			policy := strings.ReplaceAll(headers.ContentSecurityPolicy, "{nonce}", nonce)
+			if headers.ContentSecurityPolicyReportOnly {
+				header.Del("Content-Security-Policy")
+				setHeader("Content-Security-Policy-Report-Only", policy)
+			} else {
+				header.Del("Content-Security-Policy-Report-Only")
+				setHeader("Content-Security-Policy", policy)
+			}
+			if len(headers.CSPReportPath) > 0 {
+				header.Set("Reporting-Endpoints", `csp-endpoint="`+headers.CSPReportPath+`"`)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithSecurityHeaders overrides the security headers of a route (See SecurityHeadersMiddleware)
+func WithSecurityHeaders(headers SecurityHeaders) RouteOption {
+	return WithMiddleware(SecurityHeadersMiddleware(headers))
+}
+
+// newCSPNonce generates a random nonce
+func newCSPNonce() string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// cspReportHandler logs the CSP violation reports
+//
+// Both the report-uri (application/csp-report) and the report-to (application/reports+json) formats are supported.
+func cspReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("csp", "report")
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		var reports []map[string]any
+		if strings.HasPrefix(strings.TrimSpace(string(payload)), "[") {
+			var entries []struct {
+				Type string         `json:"type"`
+				Body map[string]any `json:"body"`
+			}
+			if err := json.Unmarshal(payload, &entries); err != nil {
+				log.Warnf("Invalid CSP report: %s", err)
+				WriteProblem(w, r, NewProblem(http.StatusBadRequest, "Invalid CSP report"))
+				return
+			}
+			for _, entry := range entries {
+				if entry.Type == "csp-violation" {
+					reports = append(reports, entry.Body)
+				}
+			}
+		} else {
+			var entry struct {
+				Report map[string]any `json:"csp-report"`
+			}
+			if err := json.Unmarshal(payload, &entry); err != nil || entry.Report == nil {
+				log.Warnf("Invalid CSP report: %s", err)
+				WriteProblem(w, r, NewProblem(http.StatusBadRequest, "Invalid CSP report"))
+				return
+			}
+			reports = append(reports, entry.Report)
+		}
+
+		for _, report := range reports {
+			field := func(names ...string) string {
+				for _, name := range names {
+					if value, ok := report[name]; ok && value != nil {
+						switch value := value.(type) {
+						case string:
+							return value
+						case float64:
+							return strconv.FormatFloat(value, 'f', -1, 64)
+						}
+					}
+				}
+				return ""
+			}
+			log.Record("report", report).Warnf(
+				"CSP violation on %s: %s blocked %s",
+				field("document-uri", "documentURL"),
+				field("violated-directive", "effectiveDirective", "effective-directive"),
+				field("blocked-uri", "blockedURL"),
+			)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// nonceTagPattern matches the opening <script> and <style> tags
+var nonceTagPattern = regexp.MustCompile(`(?i)<(?:script|style)\b[^>]*>`)
+
+// injectCSPNonce adds the nonce attribute to the <script> and <style> tags of an HTML document
+func injectCSPNonce(document []byte, nonce string) []byte {
+	attribute := []byte(` nonce="` + nonce + `"`)
+	return nonceTagPattern.ReplaceAllFunc(document, func(tag []byte) []byte {
+		if bytes.Contains(bytes.ToLower(tag), []byte("nonce=")) {
+			return tag
+		}
+		// <script or <style + nonce + rest of the tag
+		name := bytes.IndexAny(tag, " \t\r\n/>")
+		return append(append(append([]byte{}, tag[:name]...), attribute...), tag[name:]...)
+	})
+}
+
+// cspNonceHandler injects the CSP nonce in the HTML documents served by the next handler
+//
+// The documents are not cacheable anymore since the nonce changes with every request,
+// the other files can still be 304 Not Modified.
+func cspNonceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := CSPNonce(r)
+		if len(nonce) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &nonceWriter{ResponseWriter: w, nonce: nonce}
+		defer func() { writer.Close() }()
+		next.ServeHTTP(writer, r)
+		if writer.notModified {
+			// A 304 Not Modified does not tell if the file is an HTML document that needs a new nonce,
+			// serve it again without the validators to find out
+			req := r.Clone(r.Context())
+			req.Header.Del("If-Modified-Since")
+			req.Header.Del("If-None-Match")
+			writer = &nonceWriter{ResponseWriter: w, nonce: nonce, revalidating: true}
+			next.ServeHTTP(writer, req)
+		}
+	})
+}
+
+// nonceWriter is an http.ResponseWriter that buffers HTML documents to inject a CSP nonce
+type nonceWriter struct {
+	http.ResponseWriter
+	nonce        string
+	status       int
+	wroteHeader  bool
+	html         bool
+	buffer       bytes.Buffer
+	notModified  bool // the next handler sent a 304 Not Modified, which was held back
+	revalidating bool // the file was not modified, only HTML documents are sent again
+	discard      bool // a 304 Not Modified was sent instead of the file
+}
+
+// WriteHeader sends an HTTP response header with the provided status code
+//
+// HTML documents are buffered until Close is called.
+//
+// implements http.ResponseWriter
+func (writer *nonceWriter) WriteHeader(status int) {
+	if writer.wroteHeader {
+		return
+	}
+	writer.wroteHeader = true
+	writer.status = status
+	if status == http.StatusNotModified && !writer.revalidating {
+		writer.notModified = true
+		return
+	}
+	header := writer.Header()
+	writer.html = status == http.StatusOK && len(header.Get("Content-Encoding")) == 0 && strings.HasPrefix(header.Get("Content-Type"), "text/html")
+	if !writer.html && writer.revalidating && status == http.StatusOK {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		writer.discard = true
+		writer.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if !writer.html {
+		writer.ResponseWriter.WriteHeader(status)
+		return
+	}
+	header.Del("Content-Length")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	header.Set("Cache-Control", "no-cache")
+}
+
+// Write writes the data to the connection as part of an HTTP reply
+//
+// implements http.ResponseWriter
+func (writer *nonceWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+	if writer.html {
+		return writer.buffer.Write(data)
+	}
+	if writer.discard || writer.notModified {
+		return len(data), nil
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// Close writes the buffered HTML document with the nonce injected
+func (writer *nonceWriter) Close() {
+	if !writer.html {
+		return
+	}
+	document := injectCSPNonce(writer.buffer.Bytes(), writer.nonce)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(document)))
+	writer.ResponseWriter.WriteHeader(writer.status)
+	_, _ = writer.ResponseWriter.Write(document)
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (writer *nonceWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
@@ -0,0 +1,131 @@
+package wess
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+)
+
+func (suite *ServerSuite) TestCanSendSecurityHeaders() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, SecurityHeaders: &SecurityHeaders{FrameOptions: "SAMEORIGIN", PermissionsPolicy: "-"}})
+	server.AddRouteWithFunc(http.MethodGet, "/test", func(w http.ResponseWriter, r *http.Request) {
+		suite.Assert().NotEmpty(CSPNonce(r), "The request should have a CSP nonce")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/test", nil))
+	suite.Assert().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("nosniff", res.Header().Get("X-Content-Type-Options"))
+	suite.Assert().Equal("SAMEORIGIN", res.Header().Get("X-Frame-Options"))
+	suite.Assert().Equal("strict-origin-when-cross-origin", res.Header().Get("Referrer-Policy"))
+	suite.Assert().Empty(res.Header().Get("Permissions-Policy"))
+	suite.Assert().Empty(res.Header().Get("Strict-Transport-Security"), "HSTS should not be sent over HTTP")
+	suite.Assert().Contains(res.Header().Get("Content-Security-Policy"), "script-src 'self' 'nonce-")
+	suite.Assert().NotContains(res.Header().Get("Content-Security-Policy"), "{nonce}")
+
+	// Not found responses get the headers too
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	req.TLS = &tls.ConnectionState{}
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+	suite.Assert().Equal("SAMEORIGIN", res.Header().Get("X-Frame-Options"))
+	suite.Assert().Equal("max-age=63072000; includeSubDomains", res.Header().Get("Strict-Transport-Security"))
+}
+
+func (suite *ServerSuite) TestCanOverrideSecurityHeadersOnRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, SecurityHeaders: &SecurityHeaders{}})
+	var nonce string
+	server.AddRouteWithFunc(http.MethodGet, "/embed", func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r)
+	}, WithSecurityHeaders(SecurityHeaders{FrameOptions: "-", ContentSecurityPolicy: "frame-ancestors *; script-src 'nonce-{nonce}'", ContentSecurityPolicyReportOnly: true}))
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/embed", nil))
+	suite.Assert().Empty(res.Header().Get("X-Frame-Options"))
+	suite.Assert().Empty(res.Header().Get("Content-Security-Policy"))
+	suite.Assert().Equal("frame-ancestors *; script-src 'nonce-"+nonce+"'", res.Header().Get("Content-Security-Policy-Report-Only"))
+}
+
+func (suite *ServerSuite) TestCanInjectCSPNonceInFrontend() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, SecurityHeaders: &SecurityHeaders{}})
+	err := server.AddFrontend("/", frontendFS, "testdata/frontend-csp")
+	suite.Require().NoError(err, "Failed adding the frontend")
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	suite.Require().Equal(http.StatusOK, res.Code)
+	policy := res.Header().Get("Content-Security-Policy")
+	start := strings.Index(policy, "'nonce-") + len("'nonce-")
+	nonce := policy[start : start+strings.Index(policy[start:], "'")]
+	suite.Assert().Contains(res.Body.String(), `<script nonce="`+nonce+`" type="module" src="/assets/index.js"></script>`)
+	suite.Assert().Contains(res.Body.String(), `<style nonce="`+nonce+`">p { color: teal; }</style>`)
+	suite.Assert().Contains(policy, "style-src 'self' 'nonce-"+nonce+"'")
+	suite.Assert().Equal("no-cache", res.Header().Get("Cache-Control"))
+	suite.Assert().Empty(res.Header().Get("Last-Modified"))
+	suite.Assert().Equal(len(res.Body.Bytes()), int(res.Result().ContentLength))
+}
+
+func (suite *ServerSuite) TestShouldKeepRevalidatingFrontendAssetsWithCSPNonce() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, SecurityHeaders: &SecurityHeaders{}})
+	err := server.AddFrontend("/", os.DirFS("testdata"), "frontend-csp") // embedded files have no modification time
+	suite.Require().NoError(err, "Failed adding the frontend")
+	send := func(path, modifiedSince string) (*httptest.ResponseRecorder, *http.Request) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if len(modifiedSince) > 0 {
+			req.Header.Set("If-Modified-Since", modifiedSince)
+		}
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res, req
+	}
+
+	res, _ := send("/assets/index.js", "")
+	suite.Require().Equal(http.StatusOK, res.Code)
+	lastModified := res.Header().Get("Last-Modified")
+	suite.Require().NotEmpty(lastModified)
+
+	res, req := send("/assets/index.js", lastModified)
+	suite.Assert().Equal(http.StatusNotModified, res.Code, "Assets should still be revalidated")
+	suite.Assert().Empty(res.Body.String())
+	suite.Assert().Equal(lastModified, req.Header.Get("If-Modified-Since"), "The request should not be changed")
+
+	res, _ = send("/", lastModified)
+	suite.Assert().Equal(http.StatusOK, res.Code, "HTML documents should get a new nonce")
+	suite.Assert().Contains(res.Body.String(), `<script nonce="`)
+	suite.Assert().Equal(len(res.Body.Bytes()), int(res.Result().ContentLength))
+	suite.Assert().Equal("no-cache", res.Header().Get("Cache-Control"))
+}
+
+func (suite *ServerSuite) TestCanInjectCSPNonce() {
+	document := injectCSPNonce([]byte(`<SCRIPT>a()</SCRIPT><script nonce="x">b()</script><scripts><Style media="print">p{}</Style><stylesheet>`), "abc")
+	suite.Assert().Equal(`<SCRIPT nonce="abc">a()</SCRIPT><script nonce="x">b()</script><scripts><Style nonce="abc" media="print">p{}</Style><stylesheet>`, string(document))
+}
+
+func (suite *ServerSuite) TestCanReceiveCSPReports() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, SecurityHeaders: &SecurityHeaders{CSPReportPath: "/csp-report"}})
+
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	suite.Assert().Contains(res.Header().Get("Content-Security-Policy"), "report-uri /csp-report; report-to csp-endpoint")
+	suite.Assert().Equal(`csp-endpoint="/csp-report"`, res.Header().Get("Reporting-Endpoints"))
+
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(`{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src","blocked-uri":"inline"}}`))
+	req.Header.Set("Content-Type", "application/csp-report")
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(`[{"type":"csp-violation","body":{"documentURL":"https://example.com/","effectiveDirective":"script-src-elem","blockedURL":"https://evil.com/x.js"}}]`))
+	req.Header.Set("Content-Type", "application/reports+json")
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal(http.StatusNoContent, res.Code)
+
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(`not json`)))
+	suite.Assert().Equal(http.StatusBadRequest, res.Code)
+}
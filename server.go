@@ -7,8 +7,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"syscall"
@@ -17,7 +19,6 @@ import (
 	"github.com/gildas/go-errors"
 	"github.com/gildas/go-logger"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 )
 
 // ServerOptions defines the options for the server
@@ -34,6 +35,10 @@ type ServerOptions struct {
 	// By default: "/healthz"
 	HealthRootPath string
 
+	// ProbeRoutes serves the route table of the server as JSON at HealthRootPath + "/routes" (See Server.Routes).
+	// The route table is served only if the ProbePort is set and differs from the Port.
+	ProbeRoutes bool
+
 	// DisableGeneralOptionsHandler, if true, passes "OPTIONS *"
 	// requests to the Handler, otherwise responds with 200 OK
 	// and Content-Length: 0.
@@ -48,6 +53,12 @@ type ServerOptions struct {
 	// instead.
 	TLSConfig *tls.Config
 
+	// ServeTLS serves the webserver over TLS with the certificates of the TLSConfig,
+	// the hosts of the server can have their own certificates (See RouteGroup.SetCertificate).
+	// The probe server is always served over HTTP.
+	// Default: false, the webserver is served over HTTP (e.g. behind a TLS terminating proxy)
+	ServeTLS bool
+
 	// ReadTimeout is the maximum duration for reading the entire
 	// request, including the body. A zero or negative value means
 	// there will be no timeout.
@@ -116,6 +127,60 @@ type ServerOptions struct {
 	// If nil, logging is done via the Logger defined above.
 	ErrorLog *log.Logger
 
+	// TrustedProxies is the list of CIDRs (or IP addresses) of the proxies
+	// (load balancers, ingress controllers, etc) in front of the server.
+	// When a request comes from a trusted proxy, the client IP, scheme and host
+	// are taken from the Forwarded or X-Forwarded-* headers.
+	// If empty, these headers are ignored.
+	TrustedProxies []string
+
+	// BaseURL is the public URL of the webserver, like "https://www.acme.com/app".
+	// It is used to build the URLs of the named routes (See Server.URLFor).
+	// If empty, the URL is built from the Address, the Port and the TLSConfig.
+	BaseURL string
+
+	// RedirectToHTTPS, if true, redirects the requests sent over HTTP to HTTPS.
+	// The scheme is resolved through the TrustedProxies.
+	// The health routes are never redirected.
+	RedirectToHTTPS bool
+
+	// RateLimit rate limits all the requests of the webserver.
+	// Subrouters and routes can have their own policies
+	// (See RateLimitMiddleware and WithRateLimit).
+	// If nil, the requests are not rate limited globally.
+	RateLimit *RateLimitPolicy
+
+	// ConcurrencyLimit limits the number of requests handled at the same time by the webserver.
+	// Requests over the limit are queued by priority (See WithPriority) and shed
+	// with a 503 Service Unavailable when the server is overloaded.
+	// If nil, the concurrency is not limited.
+	ConcurrencyLimit *ConcurrencyOptions
+
+	// SecurityHeaders sends security headers (Content-Security-Policy, X-Frame-Options, etc)
+	// with every response of the webserver.
+	// Subrouters and routes can override them (See SecurityHeadersMiddleware and WithSecurityHeaders).
+	// If nil, no security header is sent.
+	SecurityHeaders *SecurityHeaders
+
+	// IPAccessList allows or denies the requests of the webserver by client IP address.
+	// The client IP is resolved through the TrustedProxies.
+	// When the probes are served on the webserver (ProbePort equals Port), the liveness and readiness probes are never denied.
+	// Subrouters and routes can have their own lists (See IPAccessList.Middleware and WithIPAccessList).
+	// If nil, all client IP addresses are allowed.
+	IPAccessList *IPAccessList
+
+	// CSRF protects the routes of the webserver against Cross-Site Request Forgery.
+	// The origins allowed by CORS are trusted, unless CSRF.TrustedOrigins is set,
+	// and the token header is added to the allowed CORS headers.
+	// Routes can have their own protection (See CSRFMiddleware and WithCSRF).
+	// If nil, the requests are not protected.
+	CSRF *CSRFOptions
+
+	// PanicHandler is called when a handler panics,
+	// after the panic has been recovered and logged.
+	// It can be used to report panics to an external service.
+	PanicHandler PanicHandler
+
 	// RequestIDHeader is the name of the header
 	// used to fetch/set the request ID.
 	// Default: "X-Request-Id"
@@ -138,6 +203,11 @@ type ServerOptions struct {
 	// Configurable Handler to be used when no route matches.
 	NotFoundHandler http.Handler
 
+	// UnknownHostHandler handles the requests whose host matches none of the hosts of the server (See Server.Host).
+	// The health routes are never given to this handler.
+	// If nil, these requests are handled by the routes that are not scoped to a host.
+	UnknownHostHandler http.Handler
+
 	// Configurable Handler to be used when the request method
 	// does not match the route.
 	MethodNotAllowedHandler http.Handler
@@ -177,6 +247,11 @@ type ServerOptions struct {
 	// CORSOptionsSuccessStatus provides a status code to use for
 	// successful OPTIONS requests, instead of http.StatusNoContent (204)
 	CORSOptionsSuccessStatus int
+
+	// Compression enables the compression of the responses.
+	// Subrouters can override these options with CompressionMiddleware.
+	// If nil, responses are not compressed.
+	Compression *CompressionOptions
 }
 
 // Server defines a Web Server
@@ -191,6 +266,17 @@ type Server struct {
 	proberouter  *mux.Router
 	probeserver  *http.Server
 	logger       *logger.Logger
+	routes       *routeRegistry
+	metrics      *Metrics
+	limiter      *ConcurrencyLimiter
+	cors         *corsRouter
+	middlewares  []string // the middlewares of the webserver, in execution order
+	probeRoutes  bool
+	baseURL      *url.URL
+	serveTLS     bool
+	hosts        *virtualHosts
+	versions     *versionRegistry
+	websockets   *webSocketRegistry
 }
 
 // NewServer creates a new Web Server
@@ -213,11 +299,60 @@ func NewServer(options ServerOptions) *Server {
 	if options.Router == nil {
 		options.Router = mux.NewRouter().StrictSlash(true)
 	}
+	middlewares := []string{"logger", "routes", "recovery"}
 	if len(options.RequestIDHeader) == 0 {
 		options.Router.Use(options.Logger.HttpHandler())
 	} else {
 		options.Router.Use(options.Logger.HttpHandlerWithRequestIDHeader(options.RequestIDHeader))
 	}
+	routes := newRouteRegistry()
+	metrics := NewMetrics()
+	options.Router.Use(routes.middleware(metrics))
+	options.Router.Use(RecoveryMiddleware(options.PanicHandler))
+	var limiter *ConcurrencyLimiter
+	if options.ConcurrencyLimit != nil {
+		limiter = NewConcurrencyLimiter(*options.ConcurrencyLimit, metrics)
+		middlewares = append(middlewares, "concurrency")
+		options.Logger.Infof("Concurrency Limiting is enabled on the webserver: %d requests in flight (%s)", limiter.Limit(), options.ConcurrencyLimit.Algorithm)
+		options.Router.Use(limiter.Middleware(func(r *http.Request) Priority {
+			if config := routes.forRequest(r); config != nil {
+				return config.priority
+			}
+			return PriorityNormal
+		}))
+	}
+	if options.RateLimit != nil {
+		options.Logger.Infof("Rate Limiting is enabled on the webserver: %d requests per %s", options.RateLimit.Limit, options.RateLimit.Window)
+		options.Router.Use(RateLimitMiddleware(*options.RateLimit))
+		middlewares = append(middlewares, "ratelimit")
+	}
+	if options.CSRF != nil {
+		csrf := *options.CSRF
+		if len(csrf.TrustedOrigins) == 0 {
+			csrf.TrustedOrigins = options.AllowedCORSOrigins
+		}
+		if csrf.TrustedOriginFunc == nil {
+			csrf.TrustedOriginFunc = options.AllowOriginFunc
+		}
+		if options.SecurityHeaders != nil && len(options.SecurityHeaders.CSPReportPath) > 0 {
+			// Browsers send the CSP reports without token
+			csrf.ExemptPaths = append(slices.Clone(csrf.ExemptPaths), options.SecurityHeaders.CSPReportPath)
+		}
+		csrf = csrf.withDefaults()
+		if len(options.AllowedCORSOrigins) > 0 || options.AllowOriginFunc != nil {
+			if len(options.AllowedCORSHeaders) == 0 {
+				// Keep the default headers of rs/cors
+				options.AllowedCORSHeaders = []string{"Accept", "Content-Type", "X-Requested-With"}
+			}
+			if !slices.ContainsFunc(options.AllowedCORSHeaders, func(header string) bool { return strings.EqualFold(header, csrf.HeaderName) || header == "*" }) {
+				options.AllowedCORSHeaders = append(slices.Clone(options.AllowedCORSHeaders), csrf.HeaderName)
+			}
+		}
+		options.CSRF = &csrf
+		options.Logger.Infof("CSRF protection is enabled on the webserver")
+		options.Router.Use(CSRFMiddleware(csrf))
+		middlewares = append(middlewares, "csrf")
+	}
 
 	if options.NotFoundHandler != nil {
 		options.Router.NotFoundHandler = options.NotFoundHandler
@@ -227,7 +362,7 @@ func NewServer(options ServerOptions) *Server {
 	if options.MethodNotAllowedHandler != nil {
 		options.Router.MethodNotAllowedHandler = options.MethodNotAllowedHandler
 	} else {
-		options.Router.MethodNotAllowedHandler = methodNotAllowedHandler(options.Logger)
+		options.Router.MethodNotAllowedHandler = methodNotAllowedHandler(options.Logger, options.Router)
 	}
 
 	var probeserver *http.Server
@@ -242,8 +377,9 @@ func NewServer(options ServerOptions) *Server {
 		} else {
 			router := mux.NewRouter().StrictSlash(true)
 			router.Use(options.Logger.HttpHandler())
+			router.Use(RecoveryMiddleware(options.PanicHandler))
 			proberouter = router.PathPrefix(options.HealthRootPath).Subrouter()
-			proberouter.MethodNotAllowedHandler = methodNotAllowedHandler(options.Logger)
+			proberouter.MethodNotAllowedHandler = methodNotAllowedHandler(options.Logger, router)
 			proberouter.NotFoundHandler = notFoundHandler(options.Logger)
 			probeserver = &http.Server{
 				Addr:              fmt.Sprintf("%s:%d", options.Address, options.ProbePort),
@@ -263,8 +399,9 @@ func NewServer(options ServerOptions) *Server {
 		}
 	}
 
-	var webhandler http.Handler
-
+	corsDefaults := corsOptionsFromServerOptions(options)
+	versions := &versionRegistry{}
+	corsRouter := &corsRouter{router: options.Router, routes: routes, versions: versions, defaults: &corsDefaults, logger: options.Logger}
 	if len(options.AllowedCORSMethods) > 0 || len(options.AllowedCORSHeaders) > 0 || len(options.AllowedCORSOrigins) > 0 {
 		options.Logger.Infof("CORS is enabled on the webserver")
 		if len(options.AllowedCORSMethods) > 0 {
@@ -288,35 +425,99 @@ func NewServer(options ServerOptions) *Server {
 		if options.CORSOptionsSuccessStatus > 0 {
 			options.Logger.Debugf("CORS: Options Success Status: %d", options.CORSOptionsSuccessStatus)
 		}
-		corsMiddleware := cors.New(cors.Options{
-			AllowedOrigins:       options.AllowedCORSOrigins,
-			AllowedHeaders:       options.AllowedCORSHeaders,
-			AllowedMethods:       options.AllowedCORSMethods,
-			ExposedHeaders:       options.ExposedCORSHeaders,
-			MaxAge:               int(options.CORSMaxAge.Seconds()),
-			AllowOriginFunc:      options.AllowOriginFunc,
-			AllowCredentials:     options.CORSAllowCredentials,
-			AllowPrivateNetwork:  options.CORSAllowPrivateNetwork,
-			OptionsPassthrough:   options.CORSOptionsPasthrough,
-			OptionsSuccessStatus: options.CORSOptionsSuccessStatus,
-			Debug:                options.Logger.ShouldWrite(logger.DEBUG, "cors", "cors"),
-		})
-		corsMiddleware.Log = CorsLogger{options.Logger.Child("cors", "cors")}
-		webhandler = corsMiddleware.Handler(options.Router)
-	} else {
-		webhandler = options.Router
+		corsRouter.global = &CORSPolicy{options: corsDefaults}
+		corsRouter.global.bind(&corsDefaults, options.Logger)
 	}
+	hosts := &virtualHosts{unknown: options.UnknownHostHandler}
+	webhandler := versions.middleware(options.Router)
+	if options.UnknownHostHandler != nil {
+		webhandler = hosts.middleware(options.HealthRootPath)(webhandler)
+		middlewares = append([]string{"unknownHost"}, middlewares...)
+	}
+	webhandler = corsRouter.Handler(webhandler)
+	middlewares = append([]string{"cors"}, middlewares...)
 
-	return &Server{
+	if options.SecurityHeaders != nil {
+		options.Logger.Infof("Security Headers are enabled on the webserver")
+		webhandler = SecurityHeadersMiddleware(*options.SecurityHeaders)(webhandler)
+		middlewares = append([]string{"securityHeaders"}, middlewares...)
+	}
+
+	if options.Compression != nil && !options.Compression.Disabled {
+		options.Logger.Infof("Compression is enabled on the webserver")
+		webhandler = CompressionMiddleware(*options.Compression)(webhandler)
+		middlewares = append([]string{"compression"}, middlewares...)
+	}
+
+	if options.RedirectToHTTPS {
+		options.Logger.Infof("HTTP requests are redirected to HTTPS")
+		webhandler = httpsRedirectMiddleware(options.HealthRootPath)(webhandler)
+		middlewares = append([]string{"httpsRedirect"}, middlewares...)
+	}
+
+	if options.IPAccessList != nil {
+		options.Logger.Infof("IP Access Control is enabled on the webserver")
+		log := options.IPAccessList.Logger
+		if log == nil {
+			log = options.Logger
+		}
+		// Only the probes of the orchestrator are never denied, the other health routes can leak information
+		excluded := []string{}
+		if options.ProbePort > 0 && options.ProbePort == options.Port {
+			excluded = append(excluded, options.HealthRootPath+"/liveness", options.HealthRootPath+"/readiness")
+		}
+		webhandler = options.IPAccessList.middleware(log, excluded...)(webhandler)
+		middlewares = append([]string{"ipAccessList"}, middlewares...)
+	}
+
+	if len(options.TrustedProxies) > 0 {
+		trustedProxies, err := ParseTrustedProxies(options.TrustedProxies...)
+		if err != nil {
+			options.Logger.Errorf("Invalid trusted proxies, forwarded headers will be ignored", err)
+		} else {
+			options.Logger.Infof("Trusted Proxies: %s", strings.Join(options.TrustedProxies, ", "))
+			webhandler = trustedProxies.Middleware()(webhandler)
+			middlewares = append([]string{"trustedProxies"}, middlewares...)
+		}
+	}
+
+	tlsConfig := options.TLSConfig
+	if tlsConfig != nil && options.ServeTLS {
+		// The certificates of the hosts can be set at any time, even after the server started (See RouteGroup.SetCertificate)
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.GetCertificate = hosts.getCertificate(options.TLSConfig.GetCertificate)
+	}
+
+	baseURL := defaultBaseURL(options)
+	if len(options.BaseURL) > 0 {
+		if base, err := parseBaseURL(options.BaseURL); err != nil {
+			options.Logger.Errorf("Invalid base URL, using %s", baseURL, err)
+		} else {
+			baseURL = base
+		}
+	}
+
+	server := &Server{
 		ShutdownTimeout: options.ShutdownTimeout,
 		logger:          options.Logger,
+		routes:          routes,
+		metrics:         metrics,
+		limiter:         limiter,
+		cors:            corsRouter,
+		middlewares:     middlewares,
+		probeRoutes:     options.ProbeRoutes,
+		baseURL:         baseURL,
+		serveTLS:        options.ServeTLS,
+		hosts:           hosts,
+		versions:        versions,
+		websockets:      &webSocketRegistry{},
 		webrouter:       options.Router,
 		proberouter:     proberouter,
 		probeserver:     probeserver,
 		webserver: &http.Server{
 			Addr:              fmt.Sprintf("%s:%d", options.Address, options.Port),
 			Handler:           webhandler,
-			TLSConfig:         options.TLSConfig,
+			TLSConfig:         tlsConfig,
 			ReadTimeout:       options.ReadTimeout,
 			ReadHeaderTimeout: options.ReadHeaderTimeout,
 			WriteTimeout:      options.WriteTimeout,
@@ -329,6 +530,14 @@ func NewServer(options ServerOptions) *Server {
 			ConnContext:       options.ConnContext,
 		},
 	}
+
+	if options.SecurityHeaders != nil && len(options.SecurityHeaders.CSPReportPath) > 0 {
+		server.AddRoute(http.MethodPost, options.SecurityHeaders.CSPReportPath, cspReportHandler(), WithMaxBodySize(64*1024), WithPriority(PriorityLow))
+	}
+	if options.CSRF != nil && len(options.CSRF.TokenPath) > 0 {
+		server.AddRoute(http.MethodGet, options.CSRF.TokenPath, csrfTokenHandler())
+	}
+	return server
 }
 
 // IsReady tells if the server is ready
@@ -336,19 +545,71 @@ func (server Server) IsReady() bool {
 	return atomic.LoadInt32(&server.healthStatus) == 1
 }
 
+// Metrics gives the metrics collected by the server
+func (server Server) Metrics() *Metrics {
+	return server.metrics
+}
+
 // AddRoute adds a route to the server
-func (server Server) AddRoute(method, path string, handler http.Handler) {
-	server.webrouter.Methods(method).Path(path).Handler(handler)
+//
+// Options can be given to configure the route (See WithMiddleware, WithRateLimit, WithPriority).
+func (server Server) AddRoute(method, path string, handler http.Handler, options ...RouteOption) {
+	config := newRouteConfig(options...).describe(handler)
+	server.register(server.webrouter.Methods(method).Path(path).Handler(config.wrap(handler)), config)
+}
+
+// register registers the configuration of a route
+func (server Server) register(route *mux.Route, config *routeConfig) {
+	if len(config.metadata.Name) == 0 {
+		config.metadata.Name, _ = route.GetPathTemplate()
+	} else {
+		route.Name(config.metadata.Name)
+	}
+	server.routes.set(route, config)
+	if config.cors != nil {
+		server.cors.addRoute(config.cors)
+	}
 }
 
 // AddRouteWithFunc adds a route to the server
-func (server Server) AddRouteWithFunc(method, path string, handlerFunc http.HandlerFunc) {
-	server.webrouter.Methods(method).Path(path).HandlerFunc(handlerFunc)
+//
+// Options can be given to configure the route (See WithMiddleware, WithRateLimit, WithPriority).
+func (server Server) AddRouteWithFunc(method, path string, handlerFunc http.HandlerFunc, options ...RouteOption) {
+	server.AddRoute(method, path, handlerFunc, options...)
 }
 
 // SubRouter creates a subrouter
-func (server Server) SubRouter(path string) *mux.Router {
-	return server.webrouter.PathPrefix(path).Subrouter()
+//
+// Options can be given to configure the subrouter (See WithMiddleware and WithCORS).
+func (server Server) SubRouter(path string, options ...RouteOption) *mux.Router {
+	config := newRouteConfig(options...)
+	route := server.webrouter.PathPrefix(path)
+	router := route.Subrouter()
+	for _, middleware := range config.middlewares {
+		router.Use(middleware)
+	}
+	server.routes.setRouter(route, config.middlewares)
+	if config.cors != nil {
+		server.cors.setPrefix("", path, config.cors)
+	}
+	return router
+}
+
+// SetCORS attaches a CORS policy to the requests whose path starts with the given prefix
+//
+// The policy of the longest matching prefix is used, the policies of the routes (See WithCORS) win over it.
+// The policy of a prefix can be replaced at runtime, if the policy is nil, the prefix is detached.
+func (server Server) SetCORS(prefix string, policy *CORSPolicy) {
+	server.cors.setPrefix("", prefix, policy)
+}
+
+// CORS gives the CORS policy of the server, built from the CORS fields of the ServerOptions
+//
+// The policy can be updated at runtime (See CORSPolicy.Update).
+//
+// returns nil if CORS was not configured in the ServerOptions
+func (server Server) CORS() *CORSPolicy {
+	return server.cors.global
 }
 
 // Start starts the server
@@ -370,12 +631,12 @@ func (server *Server) Start(context context.Context) (shutdown chan error, stop
 		plog := log.Child("probeserver", nil)
 		plog.Infof("Health probes listening on %s", server.probeserver.Addr)
 		server.logRoutes(plog.ToContext(context), server.probeserver.Handler.(*mux.Router))
-		if err = server.waitForStart(plog.ToContext(context), server.probeserver); err != nil {
+		if err = server.waitForStart(plog.ToContext(context), server.probeserver, false); err != nil {
 			return nil, nil, err
 		}
 	}
 
-	if err = server.waitForStart(log.ToContext(context), server.webserver); err != nil {
+	if err = server.waitForStart(log.ToContext(context), server.webserver, server.serveTLS); err != nil {
 		return nil, nil, err
 	}
 	shutdown, stop = server.waitForShutdown(log.ToContext(context))
@@ -386,36 +647,73 @@ func (server *Server) Start(context context.Context) (shutdown chan error, stop
 func (server Server) logRoutes(context context.Context, router *mux.Router) {
 	log := server.getChildLogger(context, nil, "routes")
 	log.Infof("Serving routes:")
-	_ = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+	for _, route := range server.routeTable(router, "") {
 		message := strings.Builder{}
 		args := []interface{}{}
 
-		if methods, err := route.GetMethods(); err == nil {
+		if len(route.Methods) > 0 {
 			message.WriteString("%s ")
-			args = append(args, strings.Join(methods, ", "))
+			args = append(args, strings.Join(route.Methods, ", "))
+		}
+		if len(route.Host) > 0 {
+			message.WriteString("%s")
+			args = append(args, route.Host)
 		}
-		if path, err := route.GetPathTemplate(); err == nil {
+		if len(route.Path) > 0 {
 			message.WriteString("%s ")
-			args = append(args, path)
+			args = append(args, route.Path)
 		}
-		if path, err := route.GetPathRegexp(); err == nil {
+		if len(route.Regexp) > 0 {
 			message.WriteString("%s ")
-			args = append(args, path)
+			args = append(args, route.Regexp)
+		}
+		if metadata := route.Metadata; metadata != nil {
+			message.WriteString("[%s]")
+			args = append(args, metadata.Name)
+			if len(metadata.Summary) > 0 {
+				message.WriteString(" %s")
+				args = append(args, metadata.Summary)
+			}
+			if len(metadata.Tags) > 0 {
+				message.WriteString(" tags: %s")
+				args = append(args, strings.Join(metadata.Tags, ", "))
+			}
+			if len(metadata.Authentication) > 0 {
+				message.WriteString(" auth: %s")
+				args = append(args, strings.Join(metadata.Authentication, ", "))
+			} else if metadata.AuthenticationRequired {
+				message.WriteString(" auth: required")
+			}
+			if len(metadata.Scopes) > 0 {
+				message.WriteString(" scopes: %s")
+				args = append(args, strings.Join(metadata.Scopes, ", "))
+			}
+			if metadata.Timeout > 0 {
+				message.WriteString(" timeout: %s")
+				args = append(args, metadata.Timeout)
+			}
+			log.Record("route", *metadata).Infof(message.String(), args...)
+			continue
 		}
 		log.Infof(message.String(), args...)
-		return nil
-	})
+	}
 }
 
-// waitForStart waits for the server to start
-func (server *Server) waitForStart(context context.Context, httpserver *http.Server) error {
+// waitForStart waits for the server to start, over TLS if serveTLS is true
+func (server *Server) waitForStart(context context.Context, httpserver *http.Server, serveTLS bool) error {
 	log := server.getChildLogger(context, "webserver", "start")
 	started := make(chan error)
 
 	go func(started chan error) {
 		atomic.StoreInt32(&server.healthStatus, 1)
-		// In case of success, this func never returns
-		if err := httpserver.ListenAndServe(); err != nil {
+		// In case of success, these funcs never return
+		var err error
+		if serveTLS {
+			err = httpserver.ListenAndServeTLS("", "")
+		} else {
+			err = httpserver.ListenAndServe()
+		}
+		if err != nil {
 			atomic.StoreInt32(&server.healthStatus, 0)
 			if err.Error() != "http: Server closed" {
 				started <- err
@@ -478,6 +776,9 @@ func (server Server) waitForShutdown(ctx context.Context) (shutdown chan error,
 		} else {
 			log.Infof("WEB Server stopped")
 		}
+
+		// Closing the WebSocket connections, they are hijacked and ignored by http.Server.Shutdown
+		server.websockets.shutdown(context, log.Child("websocket", "shutdown"))
 		shutdown <- nil
 	}()
 	return shutdown, stop
@@ -0,0 +1,459 @@
+package wess
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+)
+
+// SessionOptions defines the options of the sessions middleware
+type SessionOptions struct {
+	// Store stores the sessions.
+	// Routes that share sessions must share the same Store.
+	// Default: a new MemorySessionStore
+	Store SessionStore
+
+	// CookieName is the name of the session cookie.
+	// Default: "wess_session"
+	CookieName string
+
+	// CookiePath is the path of the session cookie.
+	// Default: "/"
+	CookiePath string
+
+	// CookieDomain is the domain of the session cookie.
+	// Default: the host of the request
+	CookieDomain string
+
+	// SameSite is the SameSite attribute of the session cookie.
+	// Default: http.SameSiteLaxMode
+	SameSite http.SameSite
+
+	// IdleTimeout is the duration after which an unused session expires.
+	// Default: 30 minutes
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout is the duration after which a session expires, even if it is used.
+	// Default: 24 hours
+	AbsoluteTimeout time.Duration
+}
+
+// SessionStore stores the sessions
+//
+// The value given to Load is the value of the session cookie, as returned by Save.
+// Server side stores return the session ID, cookie stores return the encoded session.
+//
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Load loads the session of the given cookie value
+	//
+	// returns errors.NotFound if the session does not exist or is expired
+	Load(context context.Context, value string) (*Session, error)
+
+	// Save saves the session until the given expiration time and gives the value of the session cookie
+	Save(context context.Context, session *Session, expires time.Time) (string, error)
+
+	// Delete deletes the session with the given ID
+	Delete(context context.Context, id string) error
+}
+
+// Session is the session of a client
+//
+// Sessions are serialized as JSON by the stores, so values read back from the store
+// are JSON values (numbers are float64, objects are map[string]any, etc).
+// Use SessionValue to get typed values.
+type Session struct {
+	mutex      sync.RWMutex
+	id         string
+	values     map[string]any
+	created    time.Time
+	lastAccess time.Time
+	isNew      bool
+	modified   bool
+	destroyed  bool
+	previousID string
+}
+
+// sessionRecord is the JSON representation of a Session
+type sessionRecord struct {
+	ID         string         `json:"id"`
+	Values     map[string]any `json:"values,omitempty"`
+	Created    time.Time      `json:"created"`
+	LastAccess time.Time      `json:"lastAccess"`
+}
+
+// sessionContextKey is the context key of the session
+type sessionContextKey struct{}
+
+// newSession creates a new empty session
+func newSession() *Session {
+	now := time.Now()
+	return &Session{
+		id:         randomToken(),
+		values:     map[string]any{},
+		created:    now,
+		lastAccess: now,
+		isNew:      true,
+	}
+}
+
+// GetSession gets the session of the request
+//
+// returns nil if the request did not go through the sessions middleware
+func GetSession(r *http.Request) *Session {
+	if session, ok := r.Context().Value(sessionContextKey{}).(*Session); ok {
+		return session
+	}
+	return nil
+}
+
+// SessionValue gets a typed value from the session of the request
+//
+// Values read back from the store are converted to the requested type.
+//
+// returns false if there is no session, no such value, or if it cannot be converted
+func SessionValue[T any](r *http.Request, key string) (T, bool) {
+	var result T
+	session := GetSession(r)
+	if session == nil {
+		return result, false
+	}
+	value, found := session.Get(key)
+	if !found {
+		return result, false
+	}
+	if typed, ok := value.(T); ok {
+		return typed, true
+	}
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return result, false
+	}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return result, false
+	}
+	return result, true
+}
+
+// ID gives the identifier of the session
+func (session *Session) ID() string {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.id
+}
+
+// CreatedAt tells when the session was created
+func (session *Session) CreatedAt() time.Time {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.created
+}
+
+// IsNew tells if the session was created by this request
+func (session *Session) IsNew() bool {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.isNew
+}
+
+// Get gets a value of the session
+func (session *Session) Get(key string) (any, bool) {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	value, found := session.values[key]
+	return value, found
+}
+
+// Set sets a value of the session
+//
+// The value must be serializable as JSON.
+func (session *Session) Set(key string, value any) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.values[key] = value
+	session.modified = true
+}
+
+// Delete deletes a value of the session
+func (session *Session) Delete(key string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if _, found := session.values[key]; found {
+		delete(session.values, key)
+		session.modified = true
+	}
+}
+
+// Keys gives the keys of the session values, sorted
+func (session *Session) Keys() []string {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return slices.Sorted(maps.Keys(session.values))
+}
+
+// RegenerateID gives a new identifier to the session, keeping its values
+//
+// Call it when the privileges of the client change (login, logout, elevation, etc)
+// to prevent session fixation. The previous session is deleted from the store.
+func (session *Session) RegenerateID() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !session.isNew && len(session.previousID) == 0 {
+		session.previousID = session.id
+	}
+	session.id = randomToken()
+	session.modified = true
+}
+
+// Destroy deletes the session from the store and the client
+func (session *Session) Destroy() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.destroyed = true
+	session.values = map[string]any{}
+}
+
+// MarshalJSON marshals this into JSON
+//
+// implements json.Marshaler
+func (session *Session) MarshalJSON() ([]byte, error) {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	data, err := json.Marshal(sessionRecord{
+		ID:         session.id,
+		Values:     session.values,
+		Created:    session.created,
+		LastAccess: session.lastAccess,
+	})
+	return data, errors.JSONMarshalError.Wrap(err)
+}
+
+// UnmarshalJSON decodes JSON
+//
+// implements json.Unmarshaler
+func (session *Session) UnmarshalJSON(payload []byte) error {
+	var record sessionRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return errors.JSONUnmarshalError.Wrap(err)
+	}
+	if len(record.ID) == 0 {
+		return errors.JSONPropertyMissing.With("id")
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.id = record.ID
+	session.values = record.Values
+	session.created = record.Created
+	session.lastAccess = record.LastAccess
+	if session.values == nil {
+		session.values = map[string]any{}
+	}
+	return nil
+}
+
+// expires tells when the session expires
+func (session *Session) expires(options SessionOptions) time.Time {
+	idle := session.lastAccess.Add(options.IdleTimeout)
+	if absolute := session.created.Add(options.AbsoluteTimeout); absolute.Before(idle) {
+		return absolute
+	}
+	return idle
+}
+
+// withDefaults gives the options with their default values
+func (options SessionOptions) withDefaults() SessionOptions {
+	if options.Store == nil {
+		options.Store = NewMemorySessionStore()
+	}
+	if len(options.CookieName) == 0 {
+		options.CookieName = "wess_session"
+	}
+	if len(options.CookiePath) == 0 {
+		options.CookiePath = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = 30 * time.Minute
+	}
+	if options.AbsoluteTimeout <= 0 {
+		options.AbsoluteTimeout = 24 * time.Hour
+	}
+	return options
+}
+
+// touchInterval is how often the last access of an unmodified session is saved
+func (options SessionOptions) touchInterval() time.Duration {
+	return min(time.Minute, options.IdleTimeout/10)
+}
+
+// SessionsMiddleware loads the session of the requests and saves it when the response is sent
+//
+// The session is available in the handlers with GetSession and SessionValue.
+// New sessions are only saved (and their cookie sent) when a value is set.
+// Expired sessions (See SessionOptions.IdleTimeout and SessionOptions.AbsoluteTimeout)
+// are replaced by new sessions.
+func SessionsMiddleware(options SessionOptions) func(http.Handler) http.Handler {
+	options = options.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("session", "session")
+			session := loadSession(r, options, log)
+			writer := &sessionWriter{ResponseWriter: w, request: r, session: session, options: options, log: log}
+			defer writer.save()
+			next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session)))
+		})
+	}
+}
+
+// WithSessions loads and saves the session of the requests of a route (See SessionsMiddleware)
+func WithSessions(options SessionOptions) RouteOption {
+	return WithMiddleware(SessionsMiddleware(options))
+}
+
+// loadSession loads the session of the request from the store
+//
+// returns a new session if the request has no valid session
+func loadSession(r *http.Request, options SessionOptions, log *logger.Logger) *Session {
+	cookie, err := r.Cookie(options.CookieName)
+	if err != nil {
+		return newSession()
+	}
+	session, err := options.Store.Load(r.Context(), cookie.Value)
+	if err != nil {
+		log.Debugf("Failed to load the session: %s", err)
+		return newSession()
+	}
+	now := time.Now()
+	if expires := session.expires(options); !now.Before(expires) {
+		log.Debugf("Session %s expired at %s", session.id, expires)
+		if err := options.Store.Delete(r.Context(), session.id); err != nil {
+			log.Warnf("Failed to delete the expired session %s: %s", session.id, err)
+		}
+		return newSession()
+	}
+	if now.Sub(session.lastAccess) >= options.touchInterval() {
+		session.lastAccess = now
+		session.modified = true
+	}
+	return session
+}
+
+// sessionWriter is an http.ResponseWriter that saves the session before the response is sent
+type sessionWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	session     *Session
+	options     SessionOptions
+	log         *logger.Logger
+	wroteHeader bool
+	cookieValue string
+}
+
+// WriteHeader sends an HTTP response header with the provided status code
+//
+// implements http.ResponseWriter
+func (writer *sessionWriter) WriteHeader(status int) {
+	if !writer.wroteHeader {
+		writer.save()
+		writer.wroteHeader = true
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the connection as part of an HTTP reply
+//
+// implements http.ResponseWriter
+func (writer *sessionWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// Flush sends any buffered data to the client
+//
+// implements http.Flusher
+func (writer *sessionWriter) Flush() {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (writer *sessionWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// save saves the session if it was modified, and sets its cookie if the header was not sent yet
+//
+// It is called before the header is sent and when the handler returns,
+// so values set after the header was sent are still saved by server side stores.
+func (writer *sessionWriter) save() {
+	session := writer.session
+	session.mutex.Lock()
+	destroyed, modified, previousID, id, isNew := session.destroyed, session.modified, session.previousID, session.id, session.isNew
+	session.modified = false
+	session.previousID = ""
+	session.mutex.Unlock()
+
+	store := writer.options.Store
+	context := writer.request.Context()
+	if len(previousID) > 0 || (destroyed && !isNew) {
+		if len(previousID) == 0 {
+			previousID = id
+		}
+		if err := store.Delete(context, previousID); err != nil {
+			writer.log.Warnf("Failed to delete the session %s: %s", previousID, err)
+		}
+	}
+	if destroyed {
+		if !writer.wroteHeader && !isNew {
+			clearCookie(writer.ResponseWriter, writer.options.CookieName, writer.options.CookiePath)
+		}
+		return
+	}
+	if !modified {
+		return
+	}
+
+	session.mutex.Lock()
+	session.isNew = false
+	expires := session.expires(writer.options)
+	session.mutex.Unlock()
+	value, err := store.Save(context, session, expires)
+	if err != nil {
+		writer.log.Errorf("Failed to save the session %s", id, err)
+		return
+	}
+	if value == writer.cookieValue {
+		return
+	}
+	if writer.wroteHeader {
+		writer.log.Warnf("The session %s changed after the response was sent, the client will not get the new cookie", id)
+		return
+	}
+	writer.cookieValue = value
+	http.SetCookie(writer.ResponseWriter, &http.Cookie{
+		Name:     writer.options.CookieName,
+		Value:    value,
+		Path:     writer.options.CookiePath,
+		Domain:   writer.options.CookieDomain,
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		SameSite: writer.options.SameSite,
+		Secure:   GetClientInfo(writer.request).Scheme == "https",
+		HttpOnly: true,
+	})
+}
@@ -0,0 +1,68 @@
+package wess
+
+import (
+	"context"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+// CookieSessionStore is a SessionStore that keeps the sessions in their cookie
+//
+// The sessions are encrypted and authenticated with AES-GCM, nothing is kept on the server.
+// As cookies are limited to about 4KB, sessions should stay small.
+//
+// Sessions cannot be revoked before they expire, Delete does nothing.
+type CookieSessionStore struct {
+	codec *cookieCodec
+}
+
+// cookieSessionName is the name used to authenticate the encrypted sessions
+const cookieSessionName = "wess_session"
+
+// maxCookieSessionSize is the maximum size of an encoded session
+const maxCookieSessionSize = 4000
+
+// NewCookieSessionStore creates a new CookieSessionStore with the given AES keys (16, 24 or 32 bytes)
+//
+// The first key encrypts, all keys decrypt, so keys can be rotated by adding a new key in front.
+// If no key is given, a random key is generated and the sessions do not survive a restart.
+func NewCookieSessionStore(keys ...[]byte) (*CookieSessionStore, error) {
+	codec, err := newCookieCodec(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieSessionStore{codec: codec}, nil
+}
+
+// Load loads the session of the given cookie value
+//
+// implements SessionStore
+func (store *CookieSessionStore) Load(context context.Context, value string) (*Session, error) {
+	session := &Session{}
+	if err := store.codec.decode(cookieSessionName, value, session); err != nil {
+		return nil, errors.NotFound.With("session", "cookie")
+	}
+	return session, nil
+}
+
+// Save encodes the session, the value of the session cookie
+//
+// implements SessionStore
+func (store *CookieSessionStore) Save(context context.Context, session *Session, expires time.Time) (string, error) {
+	value, err := store.codec.encode(cookieSessionName, session, expires)
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxCookieSessionSize {
+		return "", errors.ArgumentInvalid.With("session size", len(value))
+	}
+	return value, nil
+}
+
+// Delete does nothing, the cookie is removed from the client by the sessions middleware
+//
+// implements SessionStore
+func (store *CookieSessionStore) Delete(context context.Context, id string) error {
+	return nil
+}
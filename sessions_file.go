@@ -0,0 +1,151 @@
+package wess
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+// FileSessionStore is a SessionStore that keeps the sessions in files, one per session
+//
+// The sessions survive restarts and can be shared between instances through a shared volume.
+type FileSessionStore struct {
+	// Folder is the folder where the sessions are stored
+	Folder string
+
+	mutex     sync.Mutex
+	lastSweep time.Time
+}
+
+// fileSessionRecord is the content of a session file
+type fileSessionRecord struct {
+	Expires time.Time `json:"expires"`
+	Session *Session  `json:"session"`
+}
+
+// NewFileSessionStore creates a new FileSessionStore in the given folder
+//
+// The folder is created if it does not exist.
+func NewFileSessionStore(folder string) (*FileSessionStore, error) {
+	if len(folder) == 0 {
+		return nil, errors.ArgumentMissing.With("folder")
+	}
+	if err := os.MkdirAll(folder, 0o700); err != nil {
+		return nil, errors.CreationFailed.Wrap(err)
+	}
+	return &FileSessionStore{Folder: folder, lastSweep: time.Now()}, nil
+}
+
+// Load loads the session with the given ID
+//
+// implements SessionStore
+func (store *FileSessionStore) Load(context context.Context, id string) (*Session, error) {
+	filename, err := store.filename(id)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.NotFound.With("session", id)
+	}
+	record := fileSessionRecord{}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil, errors.JSONUnmarshalError.Wrap(err)
+	}
+	if record.Session == nil || !time.Now().Before(record.Expires) {
+		_ = os.Remove(filename)
+		return nil, errors.NotFound.With("session", id)
+	}
+	return record.Session, nil
+}
+
+// Save saves the session and gives its ID
+//
+// implements SessionStore
+func (store *FileSessionStore) Save(context context.Context, session *Session, expires time.Time) (string, error) {
+	id := session.ID()
+	filename, err := store.filename(id)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(fileSessionRecord{Expires: expires, Session: session})
+	if err != nil {
+		return "", errors.JSONMarshalError.Wrap(err)
+	}
+	store.sweep(time.Now())
+	// Write in a temporary file first, so readers never see a partial session
+	temp, err := os.CreateTemp(store.Folder, ".session-*")
+	if err != nil {
+		return "", errors.CreationFailed.Wrap(err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(payload); err != nil {
+		temp.Close()
+		return "", errors.RuntimeError.Wrap(err)
+	}
+	if err := temp.Close(); err != nil {
+		return "", errors.RuntimeError.Wrap(err)
+	}
+	if err := os.Rename(temp.Name(), filename); err != nil {
+		return "", errors.RuntimeError.Wrap(err)
+	}
+	return id, nil
+}
+
+// Delete deletes the session with the given ID
+//
+// implements SessionStore
+func (store *FileSessionStore) Delete(context context.Context, id string) error {
+	filename, err := store.filename(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return errors.RuntimeError.Wrap(err)
+	}
+	return nil
+}
+
+// filename gives the file of the session with the given ID
+//
+// Session IDs are URL safe base64 strings, anything else is rejected
+// so IDs cannot escape the folder.
+func (store *FileSessionStore) filename(id string) (string, error) {
+	if len(id) == 0 || len(id) > 128 || strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) >= 0 {
+		return "", errors.ArgumentInvalid.With("id", id)
+	}
+	return filepath.Join(store.Folder, id+".json"), nil
+}
+
+// sweep deletes the expired sessions, at most once per 10 minutes
+func (store *FileSessionStore) sweep(now time.Time) {
+	store.mutex.Lock()
+	if now.Sub(store.lastSweep) < 10*time.Minute {
+		store.mutex.Unlock()
+		return
+	}
+	store.lastSweep = now
+	store.mutex.Unlock()
+
+	files, _ := filepath.Glob(filepath.Join(store.Folder, "*.json"))
+	for _, filename := range files {
+		payload, err := os.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		record := struct {
+			Expires time.Time `json:"expires"`
+		}{}
+		if err := json.Unmarshal(payload, &record); err == nil && !now.Before(record.Expires) {
+			_ = os.Remove(filename)
+		}
+	}
+}
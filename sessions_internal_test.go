@@ -0,0 +1,119 @@
+package wess
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// addSessionRoutes adds routes that use the sessions with the given options
+func addSessionRoutes(server *Server, options SessionOptions) {
+	sessions := WithSessions(options)
+	server.AddRouteWithFunc(http.MethodGet, "/set", func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Set("color", r.URL.Query().Get("color"))
+		GetSession(r).Set("cart", map[string]int{"apples": 3})
+	}, sessions)
+	server.AddRouteWithFunc(http.MethodGet, "/get", func(w http.ResponseWriter, r *http.Request) {
+		color, _ := SessionValue[string](r, "color")
+		cart, _ := SessionValue[map[string]int](r, "cart")
+		_, _ = w.Write([]byte(color + ":" + strconv.Itoa(cart["apples"])))
+	}, sessions)
+	server.AddRouteWithFunc(http.MethodGet, "/login", func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).RegenerateID()
+		GetSession(r).Set("user", "john")
+	}, sessions)
+	server.AddRouteWithFunc(http.MethodGet, "/logout", func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Destroy()
+	}, sessions)
+}
+
+func (suite *ServerSuite) TestCanUseSessionsWithMemoryStore() {
+	store := NewMemorySessionStore()
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	addSessionRoutes(server, SessionOptions{Store: store})
+	client := &cookieTestClient{server: server, cookies: map[string]*http.Cookie{}}
+
+	res := client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal(":0", res.Body.String())
+	suite.Assert().Empty(res.Result().Cookies(), "Empty sessions should not be saved")
+	suite.Assert().Equal(0, store.Len())
+
+	client.send(http.MethodGet, "/set?color=blue", "")
+	suite.Require().Contains(client.cookies, "wess_session")
+	suite.Assert().True(client.cookies["wess_session"].HttpOnly)
+	suite.Assert().Equal(http.SameSiteLaxMode, client.cookies["wess_session"].SameSite)
+	suite.Assert().Equal(1, store.Len())
+
+	res = client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal("blue:3", res.Body.String())
+
+	previous := client.cookies["wess_session"]
+	client.send(http.MethodGet, "/login", "")
+	suite.Assert().NotEqual(previous.Value, client.cookies["wess_session"].Value, "The session ID should be regenerated")
+	suite.Assert().Equal(1, store.Len(), "The previous session should be deleted")
+	res = client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal("blue:3", res.Body.String(), "The values should be kept")
+
+	client.send(http.MethodGet, "/logout", "")
+	suite.Assert().NotContains(client.cookies, "wess_session")
+	suite.Assert().Equal(0, store.Len())
+}
+
+func (suite *ServerSuite) TestCanUseSessionsWithCookieStoreAndRotateKeys() {
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	oldStore, err := NewCookieSessionStore(oldKey)
+	suite.Require().NoError(err)
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	addSessionRoutes(server, SessionOptions{Store: oldStore})
+	client := &cookieTestClient{server: server, cookies: map[string]*http.Cookie{}}
+	client.send(http.MethodGet, "/set?color=red", "")
+	suite.Require().Contains(client.cookies, "wess_session")
+
+	rotatedStore, err := NewCookieSessionStore(newKey, oldKey)
+	suite.Require().NoError(err)
+	server = NewServer(ServerOptions{Logger: suite.Logger})
+	addSessionRoutes(server, SessionOptions{Store: rotatedStore})
+	client.server = server
+	res := client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal("red:3", res.Body.String(), "Sessions encrypted with the old key should be read")
+
+	newStore, err := NewCookieSessionStore(newKey)
+	suite.Require().NoError(err)
+	server = NewServer(ServerOptions{Logger: suite.Logger})
+	addSessionRoutes(server, SessionOptions{Store: newStore})
+	client.server = server
+	res = client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal(":0", res.Body.String(), "Sessions encrypted with a removed key should be discarded")
+
+	_, err = NewCookieSessionStore([]byte("short"))
+	suite.Assert().Error(err)
+}
+
+func (suite *ServerSuite) TestShouldExpireSessions() {
+	store, err := NewFileSessionStore(suite.T().TempDir())
+	suite.Require().NoError(err)
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	addSessionRoutes(server, SessionOptions{Store: store, IdleTimeout: 200 * time.Millisecond, AbsoluteTimeout: time.Hour})
+	client := &cookieTestClient{server: server, cookies: map[string]*http.Cookie{}}
+	client.send(http.MethodGet, "/set?color=green", "")
+	res := client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal("green:3", res.Body.String(), "The file store should keep the session")
+	time.Sleep(250 * time.Millisecond)
+	res = client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal(":0", res.Body.String(), "The session should expire when idle")
+
+	server = NewServer(ServerOptions{Logger: suite.Logger})
+	addSessionRoutes(server, SessionOptions{Store: store, IdleTimeout: time.Hour, AbsoluteTimeout: 200 * time.Millisecond})
+	client.server = server
+	client.send(http.MethodGet, "/set?color=green", "")
+	time.Sleep(120 * time.Millisecond)
+	res = client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal("green:3", res.Body.String())
+	time.Sleep(120 * time.Millisecond)
+	res = client.send(http.MethodGet, "/get", "")
+	suite.Assert().Equal(":0", res.Body.String(), "The session should expire after the absolute timeout, even if used")
+
+	_, err = store.Load(suite.T().Context(), "../../etc/passwd")
+	suite.Assert().Error(err, "Session IDs should not escape the folder")
+}
@@ -0,0 +1,97 @@
+package wess
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+)
+
+// MemorySessionStore is a SessionStore that keeps the sessions in memory
+//
+// The sessions are lost when the server restarts and are not shared between instances.
+type MemorySessionStore struct {
+	mutex     sync.Mutex
+	entries   map[string]memorySessionEntry
+	lastSweep time.Time
+}
+
+// memorySessionEntry holds a serialized session
+type memorySessionEntry struct {
+	payload []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore creates a new MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{entries: map[string]memorySessionEntry{}, lastSweep: time.Now()}
+}
+
+// Len gives the number of sessions in the store
+func (store *MemorySessionStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.entries)
+}
+
+// Load loads the session with the given ID
+//
+// implements SessionStore
+func (store *MemorySessionStore) Load(context context.Context, id string) (*Session, error) {
+	store.mutex.Lock()
+	entry, found := store.entries[id]
+	if found && !time.Now().Before(entry.expires) {
+		delete(store.entries, id)
+		found = false
+	}
+	store.mutex.Unlock()
+	if !found {
+		return nil, errors.NotFound.With("session", id)
+	}
+	session := &Session{}
+	if err := json.Unmarshal(entry.payload, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Save saves the session and gives its ID
+//
+// implements SessionStore
+func (store *MemorySessionStore) Save(context context.Context, session *Session, expires time.Time) (string, error) {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	id := session.ID()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.sweep(time.Now())
+	store.entries[id] = memorySessionEntry{payload: payload, expires: expires}
+	return id, nil
+}
+
+// Delete deletes the session with the given ID
+//
+// implements SessionStore
+func (store *MemorySessionStore) Delete(context context.Context, id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.entries, id)
+	return nil
+}
+
+// sweep evicts the expired sessions, at most once per minute
+func (store *MemorySessionStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < time.Minute {
+		return
+	}
+	store.lastSweep = now
+	for id, entry := range store.entries {
+		if !now.Before(entry.expires) {
+			delete(store.entries, id)
+		}
+	}
+}
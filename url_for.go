@@ -0,0 +1,142 @@
+package wess
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gildas/go-errors"
+	"github.com/gorilla/mux"
+)
+
+// AddNamedRoute adds a named route to the server and gives its gorilla/mux route
+//
+// The name is used to build the URL of the route (See URLFor and URLForRequest).
+// Options can be given to configure the route (See WithMiddleware, WithRateLimit, WithPriority).
+func (server Server) AddNamedRoute(name, method, path string, handler http.Handler, options ...RouteOption) *mux.Route {
+	config := newRouteConfig(append(slices.Clone(options), WithName(name))...).describe(handler)
+	route := server.webrouter.Methods(method).Path(path).Handler(config.wrap(handler))
+	server.register(route, config)
+	return route
+}
+
+// AddNamedRouteWithFunc adds a named route to the server and gives its gorilla/mux route
+//
+// The name is used to build the URL of the route (See URLFor and URLForRequest).
+// Options can be given to configure the route (See WithMiddleware, WithRateLimit, WithPriority).
+func (server Server) AddNamedRouteWithFunc(name, method, path string, handlerFunc http.HandlerFunc, options ...RouteOption) *mux.Route {
+	return server.AddNamedRoute(name, method, path, handlerFunc, options...)
+}
+
+// URLFor builds the absolute URL of a named route
+//
+// The routes are named with AddNamedRoute or WithName, the routes of subrouters and groups include their prefix.
+//
+// The params are pairs of names and values, like "id", "42".
+// The params that are not variables of the route are added to the query.
+//
+// The scheme and host come from ServerOptions.BaseURL,
+// or from the Address, the Port and the TLSConfig of the server.
+// Handlers should use URLForRequest to build URLs as seen by their clients.
+//
+// returns an errors.NotFound error if no route has this name,
+// an errors.ArgumentInvalid error if the params do not match the variables of the route.
+func (server Server) URLFor(name string, params ...string) (*url.URL, error) {
+	return server.buildURL(*server.baseURL, name, params...)
+}
+
+// URLForRequest builds the absolute URL of a named route, as seen by the client of the request
+//
+// The scheme and host are the ones of the client (See GetClientInfo),
+// they come from the Forwarded or X-Forwarded-* headers when the request went through trusted proxies
+// (See ServerOptions.TrustedProxies).
+// The path of ServerOptions.BaseURL is kept.
+//
+// If the request is nil, the URL is built like URLFor.
+func (server Server) URLForRequest(r *http.Request, name string, params ...string) (*url.URL, error) {
+	base := *server.baseURL
+	if r != nil {
+		info := GetClientInfo(r)
+		if len(info.Scheme) > 0 {
+			base.Scheme = info.Scheme
+		}
+		if len(info.Host) > 0 {
+			base.Host = info.Host
+		}
+	}
+	return server.buildURL(base, name, params...)
+}
+
+// buildURL builds the URL of a named route under the base URL
+func (server Server) buildURL(base url.URL, name string, params ...string) (*url.URL, error) {
+	route := server.webrouter.Get(name)
+	if route == nil {
+		return nil, errors.NotFound.With("route", name)
+	}
+	if len(params)%2 != 0 {
+		return nil, errors.ArgumentInvalid.With("params", strings.Join(params, ","))
+	}
+	variables, _ := route.GetVarNames()
+	pairs := []string{}
+	query := url.Values{}
+	for index := 0; index < len(params); index += 2 {
+		if slices.Contains(variables, params[index]) {
+			pairs = append(pairs, params[index], params[index+1])
+		} else {
+			query.Add(params[index], params[index+1])
+		}
+	}
+	location, err := route.URL(pairs...)
+	if err != nil {
+		return nil, errors.ArgumentInvalid.Wrap(err)
+	}
+	location.Scheme = base.Scheme
+	if len(location.Host) == 0 {
+		location.Host = base.Host
+	}
+	location.Path = strings.TrimSuffix(base.Path, "/") + location.Path
+	if len(query) > 0 {
+		if len(location.RawQuery) > 0 {
+			location.RawQuery += "&"
+		}
+		location.RawQuery += query.Encode()
+	}
+	return location, nil
+}
+
+// parseBaseURL parses the public URL of the webserver (See ServerOptions.BaseURL)
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.ArgumentInvalid.Wrap(err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || len(base.Host) == 0 {
+		return nil, errors.ArgumentInvalid.With("baseURL", baseURL)
+	}
+	base.RawQuery, base.Fragment = "", ""
+	return base, nil
+}
+
+// defaultBaseURL gives the URL of the webserver from its address, port and TLS configuration
+//
+// The wildcard addresses are replaced by "localhost", the default ports are omitted.
+func defaultBaseURL(options ServerOptions) *url.URL {
+	base := &url.URL{Scheme: "http", Host: options.Address}
+	defaultPort := 80
+	if options.TLSConfig != nil {
+		base.Scheme = "https"
+		defaultPort = 443
+	}
+	if ip := net.ParseIP(options.Address); len(options.Address) == 0 || (ip != nil && ip.IsUnspecified()) {
+		base.Host = "localhost"
+	}
+	if options.Port != defaultPort {
+		base.Host = net.JoinHostPort(base.Host, strconv.Itoa(options.Port))
+	} else if strings.Contains(base.Host, ":") {
+		base.Host = "[" + base.Host + "]"
+	}
+	return base
+}
@@ -0,0 +1,86 @@
+package wess
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gildas/go-errors"
+)
+
+func (suite *ServerSuite) TestCanBuildURLsOfNamedRoutes() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, Port: 8080, TrustedProxies: []string{"10.0.0.0/8"}})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		location, err := server.URLForRequest(r, "users.get", "id", "42")
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", location.String())
+		w.WriteHeader(http.StatusCreated)
+	}
+	route := server.AddNamedRouteWithFunc("users.get", http.MethodGet, "/users/{id:[0-9]+}", handler)
+	suite.Require().NotNil(route)
+	suite.Assert().Equal("users.get", route.GetName())
+	server.AddRouteWithFunc(http.MethodPost, "/users", handler, WithName("users.create"))
+	server.Group("/api/v1").Get("/orders/{order}/items", handler, WithName("orders.items"))
+
+	location, err := server.URLFor("users.get", "id", "42")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("http://localhost:8080/users/42", location.String())
+
+	location, err = server.URLFor("orders.items", "order", "A 1", "page", "2")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("http://localhost:8080/api/v1/orders/A%201/items?page=2", location.String(), "The prefix of the group should be kept, the extra params should be in the query")
+
+	location, err = server.URLFor("users.create")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("http://localhost:8080/users", location.String(), "Routes named with WithName should be found")
+
+	_, err = server.URLFor("users.get", "id", "abc")
+	suite.Assert().ErrorIs(err, errors.ArgumentInvalid, "Values should match the variables of the route")
+	_, err = server.URLFor("users.get")
+	suite.Assert().ErrorIs(err, errors.ArgumentInvalid, "Variables should not be missing")
+	_, err = server.URLFor("users.get", "id")
+	suite.Assert().ErrorIs(err, errors.ArgumentInvalid)
+	_, err = server.URLFor("users.unknown")
+	suite.Assert().ErrorIs(err, errors.NotFound)
+
+	req := httptest.NewRequest(http.MethodGet, "http://10.0.0.5:8080/users/1", nil)
+	req.RemoteAddr = "10.0.0.2:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "www.acme.com")
+	res := httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Require().Equal(http.StatusCreated, res.Code)
+	suite.Assert().Equal("https://www.acme.com/users/42", res.Header().Get("Location"), "The scheme and host should be the ones forwarded by the trusted proxy")
+
+	req = httptest.NewRequest(http.MethodGet, "http://10.0.0.5:8080/users/1", nil)
+	req.RemoteAddr = "203.0.113.7:4567"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Forwarded-Host", "evil.com")
+	res = httptest.NewRecorder()
+	server.webserver.Handler.ServeHTTP(res, req)
+	suite.Assert().Equal("http://10.0.0.5:8080/users/42", res.Header().Get("Location"), "Untrusted forwarded hosts should be ignored")
+}
+
+func (suite *ServerSuite) TestCanBuildURLsWithBaseURL() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, Port: 443, TLSConfig: &tls.Config{}})
+	server.AddNamedRouteWithFunc("health", http.MethodGet, "/health", func(w http.ResponseWriter, r *http.Request) {})
+	location, err := server.URLFor("health")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("https://localhost/health", location.String(), "The TLS configuration should give the scheme")
+
+	server = NewServer(ServerOptions{Logger: suite.Logger, BaseURL: "https://www.acme.com/app/"})
+	server.AddNamedRouteWithFunc("health", http.MethodGet, "/health", func(w http.ResponseWriter, r *http.Request) {})
+	location, err = server.URLFor("health")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("https://www.acme.com/app/health", location.String())
+
+	server = NewServer(ServerOptions{Logger: suite.Logger, Address: "127.0.0.1", BaseURL: "not a url"})
+	server.AddNamedRouteWithFunc("health", http.MethodGet, "/health", func(w http.ResponseWriter, r *http.Request) {})
+	location, err = server.URLFor("health")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("http://127.0.0.1/health", location.String(), "Invalid base URLs should be ignored")
+}
@@ -0,0 +1,276 @@
+package wess
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gildas/go-errors"
+)
+
+// Validator is implemented by values that validate themselves
+//
+// Validate calls it after the validate tags are checked successfully.
+// If it returns a Problem, the Problem is given as is.
+type Validator interface {
+	Validate() error
+}
+
+// Validate validates a value with the validate tags of its fields
+//
+// The rules of a tag are separated by commas:
+//
+//	type CreateUser struct {
+//	  Name  string   `json:"name" validate:"required,max=64"`
+//	  Email string   `json:"email" validate:"required,email"`
+//	  Age   int      `json:"age" validate:"omitempty,min=18"`
+//	  Role  string   `json:"role" validate:"oneof=admin user guest"`
+//	  Tags  []string `json:"tags" validate:"max=10"`
+//	}
+//
+// The rules are:
+//   - required: the value is not empty (zero, nil, empty string, slice or map)
+//   - omitempty: the other rules are not checked if the value is empty
+//   - min=n, max=n, len=n: the value of numbers, the length of strings, slices and maps
+//   - oneof=a b c: the value is one of the given words
+//   - email, url: the value is an email address, an absolute URL
+//
+// Nested structs and the structs in slices and maps are validated as well.
+// Finally, if the value implements Validator, its Validate method is called.
+//
+// returns a 422 Unprocessable Entity Problem with the invalid fields in its "errors" member.
+// Invalid rules (unknown, with a bad argument, or that do not apply to the field) panic,
+// like invalid regular expressions in regexp.MustCompile.
+func Validate(value any) error {
+	fieldErrors := validateValue(reflect.ValueOf(value), "", "body")
+	if len(fieldErrors) > 0 {
+		return NewProblem(http.StatusUnprocessableEntity, "The request is not valid").With("errors", fieldErrors)
+	}
+	if validator, ok := value.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			var problem Problem
+			if errors.As(err, &problem) {
+				return problem
+			}
+			return NewProblem(http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+	return nil
+}
+
+// validateValue validates the fields of a struct, or the structs in a slice or map
+func validateValue(value reflect.Value, path, in string) (fieldErrors []FieldError) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == reflect.TypeOf(time.Time{}) {
+			return nil
+		}
+		for _, field := range reflect.VisibleFields(value.Type()) {
+			if !field.IsExported() || field.Anonymous {
+				continue
+			}
+			fieldValue, err := value.FieldByIndexErr(field.Index)
+			if err != nil {
+				continue // the field belongs to a nil embedded struct
+			}
+			name, fieldIn := validationFieldName(field, in)
+			if len(path) > 0 {
+				name = path + "." + name
+			}
+			if tag, found := field.Tag.Lookup("validate"); found && len(tag) > 0 {
+				if message := validateField(fieldValue, tag); len(message) > 0 {
+					fieldErrors = append(fieldErrors, FieldError{Field: name, In: fieldIn, Message: message})
+					continue
+				}
+			}
+			fieldErrors = append(fieldErrors, validateValue(fieldValue, name, fieldIn)...)
+		}
+	case reflect.Slice, reflect.Array:
+		for index := 0; index < value.Len(); index++ {
+			fieldErrors = append(fieldErrors, validateValue(value.Index(index), fmt.Sprintf("%s[%d]", path, index), in)...)
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)) })
+		for _, key := range keys {
+			fieldErrors = append(fieldErrors, validateValue(value.MapIndex(key), fmt.Sprintf("%s[%v]", path, key), in)...)
+		}
+	}
+	return fieldErrors
+}
+
+// validationFieldName gives the name of a field as sent by the client, and where it comes from
+func validationFieldName(field reflect.StructField, in string) (string, string) {
+	for _, source := range []string{"path", "query", "header"} {
+		if name := field.Tag.Get(source); len(name) > 0 && name != "-" {
+			return name, source
+		}
+	}
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); len(name) > 0 && name != "-" {
+		return name, in
+	}
+	return field.Name, in
+}
+
+// validateField checks the rules of a validate tag
+//
+// returns why the value is invalid, or an empty string if it is valid
+func validateField(value reflect.Value, tag string) string {
+	rules := strings.Split(tag, ",")
+	empty := value.IsZero() || ((value.Kind() == reflect.Slice || value.Kind() == reflect.Map) && value.Len() == 0)
+	if empty {
+		if slices.Contains(rules, "required") {
+			return "is required"
+		}
+		if slices.Contains(rules, "omitempty") {
+			return ""
+		}
+	}
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	for _, rule := range rules {
+		name, argument, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "", "required", "omitempty":
+		case "min", "max", "len":
+			limit, err := strconv.ParseFloat(argument, 64)
+			if err != nil {
+				panic("wess: invalid validation rule " + rule)
+			}
+			measure, isLength, ok := validationMeasure(value)
+			if !ok {
+				panic("wess: validation rule " + rule + " cannot be applied to " + value.Type().String())
+			}
+			if message := checkLimit(name, measure, limit, argument, isLength); len(message) > 0 {
+				return message
+			}
+		case "oneof":
+			if !slices.Contains(strings.Fields(argument), fmt.Sprint(value.Interface())) {
+				return "must be one of " + strings.Join(strings.Fields(argument), ", ")
+			}
+		case "email":
+			if value.Kind() != reflect.String {
+				panic("wess: validation rule " + rule + " cannot be applied to " + value.Type().String())
+			}
+			address, err := mail.ParseAddress(value.String())
+			if err != nil || address.Address != value.String() {
+				return "must be an email address"
+			}
+		case "url":
+			if value.Kind() != reflect.String {
+				panic("wess: validation rule " + rule + " cannot be applied to " + value.Type().String())
+			}
+			parsed, err := url.Parse(value.String())
+			if err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+				return "must be an absolute URL"
+			}
+		default:
+			panic("wess: unknown validation rule " + rule)
+		}
+	}
+	return ""
+}
+
+// validationMeasure gives the number to compare with min, max and len: the value of numbers, the length of strings, slices and maps
+func validationMeasure(value reflect.Value) (measure float64, isLength bool, ok bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), true, true
+	}
+	return 0, false, false
+}
+
+// checkLimit checks a min, max or len rule
+func checkLimit(rule string, measure, limit float64, argument string, isLength bool) string {
+	subject := "must be"
+	if isLength {
+		subject = "must have a length"
+	}
+	switch {
+	case rule == "min" && measure < limit:
+		return subject + " at least " + argument
+	case rule == "max" && measure > limit:
+		return subject + " at most " + argument
+	case rule == "len" && measure != limit:
+		return subject + " of " + argument
+	}
+	return ""
+}
+
+// validateRules checks the validate tags of a type, so invalid rules are found when a handler is created
+func validateRules(t reflect.Type, visited map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) || visited[t] {
+		return
+	}
+	visited[t] = true
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if reason := checkValidationRule(rule, field.Type); len(reason) > 0 {
+				panic("wess: validation rule " + rule + " on " + t.String() + "." + field.Name + " " + reason)
+			}
+		}
+		validateRules(field.Type, visited)
+	}
+}
+
+// checkValidationRule checks a rule and its argument can be applied to the values of a type
+//
+// returns why the rule is invalid, or an empty string if it is valid
+func checkValidationRule(rule string, t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name, argument, _ := strings.Cut(strings.TrimSpace(rule), "=")
+	switch name {
+	case "", "required", "omitempty":
+	case "min", "max", "len":
+		if _, err := strconv.ParseFloat(argument, 64); err != nil {
+			return "needs a number"
+		}
+		if _, _, ok := validationMeasure(reflect.Zero(t)); !ok {
+			return "cannot be applied to " + t.String()
+		}
+	case "oneof":
+		if len(strings.Fields(argument)) == 0 {
+			return "needs at least one value"
+		}
+	case "email", "url":
+		if t.Kind() != reflect.String {
+			return "cannot be applied to " + t.String()
+		}
+	default:
+		return "is unknown"
+	}
+	return ""
+}
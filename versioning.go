@@ -0,0 +1,384 @@
+package wess
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// VersionStrategy tells how the clients give the version of an API (See Server.Versioned)
+type VersionStrategy string
+
+const (
+	// VersionByPath reads the version in the path, after the prefix of the API, like "/api/v2/users"
+	VersionByPath VersionStrategy = "path"
+
+	// VersionByHeader reads the version in a request header, like "Accept-Version: v2"
+	VersionByHeader VersionStrategy = "header"
+
+	// VersionByMediaType reads the version in the vendor media type of the Accept header,
+	// like "application/vnd.acme.v2+json" or "application/vnd.acme+json; version=2"
+	VersionByMediaType VersionStrategy = "mediatype"
+)
+
+// VersioningOptions defines the options of a versioned API (See Server.Versioned)
+type VersioningOptions struct {
+	// Prefix is the path prefix of the API, like "/api"
+	Prefix string
+
+	// Strategy tells how the clients give the version.
+	// Default: VersionByPath
+	Strategy VersionStrategy
+
+	// Header is the request header that gives the version with VersionByHeader.
+	// Default: "Accept-Version"
+	Header string
+
+	// Vendor is the vendor of the media types with VersionByMediaType,
+	// like "acme" for "application/vnd.acme.v2+json"
+	Vendor string
+
+	// Default is the version of the requests that do not give one.
+	// Default: the first version of the API
+	Default string
+}
+
+// VersionOptions defines the lifecycle of a version of an API (See VersionedAPI.Version)
+type VersionOptions struct {
+	// Deprecation is the date the version was deprecated, it is sent in the Deprecation header (RFC 9745).
+	// If zero, the version is not deprecated.
+	Deprecation time.Time
+
+	// Sunset is the date the version will be retired, it is sent in the Sunset header (RFC 8594).
+	// If zero, no Sunset header is sent.
+	Sunset time.Time
+
+	// Link is the URL of the documentation of the deprecation, it is sent in a Link header
+	Link string
+}
+
+// VersionedAPI is an API whose versions are served side by side (See Server.Versioned)
+type VersionedAPI struct {
+	server      Server
+	options     VersioningOptions
+	middlewares []func(http.Handler) http.Handler
+	mutex       sync.RWMutex
+	versions    []string
+}
+
+// Versioned creates an API whose versions are served side by side under the given prefix
+//
+// The versions are added with Version, the middlewares are executed for the routes of all the versions.
+// The requests that do not give a version are served by the default version.
+// With VersionByPath, their path is rewritten, so "/api/users" is served as "/api/v1/users".
+// With VersionByMediaType, the vendor media types of the Accept header are replaced with their structured syntax suffix,
+// so "application/vnd.acme.v2+json" is rendered as "application/json" (See Render).
+//
+// The requests for unknown versions get a 400 Bad Request Problem with VersionByHeader
+// and a 406 Not Acceptable Problem with VersionByMediaType, both with the available versions in their "versions" member.
+//
+// The requests are counted per API prefix and version in the wess_api_version_requests_total metric.
+//
+// Example:
+//
+//	api := server.Versioned(wess.VersioningOptions{Prefix: "/api", Strategy: wess.VersionByHeader}, authMiddleware)
+//	api.Version("v1", wess.VersionOptions{Deprecation: deprecatedAt, Sunset: retiredAt}).Get("/users", listUsersV1)
+//	api.Version("v2", wess.VersionOptions{}).Get("/users", listUsersV2)
+func (server Server) Versioned(options VersioningOptions, middlewares ...func(http.Handler) http.Handler) *VersionedAPI {
+	if len(options.Strategy) == 0 {
+		options.Strategy = VersionByPath
+	}
+	if len(options.Header) == 0 {
+		options.Header = "Accept-Version"
+	}
+	options.Prefix = strings.TrimSuffix(options.Prefix, "/")
+	api := &VersionedAPI{server: server, options: options, middlewares: middlewares}
+	server.metrics.Describe("wess_api_version_requests_total", CounterMetric, "Number of requests handled, by API and version")
+	server.versions.add(api)
+	return api
+}
+
+// Version adds a version to the API and gives the group of its routes
+//
+// With VersionByPath, the prefix of the group is the prefix of the API followed by the version, like "/api/v2".
+// Otherwise, the versions share the prefix of the API.
+//
+// When the version is deprecated, its responses have Deprecation, Sunset and Link headers.
+func (api *VersionedAPI) Version(version string, options VersionOptions) *RouteGroup {
+	api.mutex.Lock()
+	api.versions = append(api.versions, version)
+	api.mutex.Unlock()
+
+	var route *mux.Route
+	prefix := api.options.Prefix
+	if api.options.Strategy == VersionByPath {
+		prefix += "/" + version
+		route = api.server.webrouter.PathPrefix(prefix)
+	} else {
+		route = api.server.webrouter.NewRoute()
+		if len(prefix) > 0 {
+			route = route.PathPrefix(prefix)
+		}
+		route = route.MatcherFunc(func(r *http.Request, match *mux.RouteMatch) bool {
+			requested, ok := r.Context().Value(apiVersionContextKey{}).(apiVersion)
+			return ok && requested.api == api && requested.version == version
+		})
+	}
+	group := &RouteGroup{
+		server: api.server,
+		router: route.Subrouter(),
+		prefix: prefix,
+	}
+	middlewares := append([]func(http.Handler) http.Handler{api.middleware(version, options)}, api.middlewares...)
+	for _, middleware := range middlewares {
+		group.router.Use(middleware)
+	}
+	api.server.routes.setRouter(route, middlewares)
+	return group
+}
+
+// Prefix gives the path prefix of the API
+func (api *VersionedAPI) Prefix() string {
+	return api.options.Prefix
+}
+
+// Versions gives the versions of the API, in the order they were added
+func (api *VersionedAPI) Versions() []string {
+	api.mutex.RLock()
+	defer api.mutex.RUnlock()
+	return slices.Clone(api.versions)
+}
+
+// GetAPIVersion gets the version of the API requested by the request (See Server.Versioned)
+//
+// returns an empty string if the request is not for a versioned API
+func GetAPIVersion(r *http.Request) string {
+	if requested, ok := r.Context().Value(apiVersionContextKey{}).(apiVersion); ok {
+		return requested.version
+	}
+	return ""
+}
+
+// apiVersion is the version of an API requested by a request
+type apiVersion struct {
+	api     *VersionedAPI
+	version string
+}
+
+// apiVersionContextKey is the context key of the apiVersion
+type apiVersionContextKey struct{}
+
+// middleware sends the lifecycle headers of a version and counts its requests
+func (api *VersionedAPI) middleware(version string, options VersionOptions) func(http.Handler) http.Handler {
+	name := api.options.Prefix
+	if len(name) == 0 {
+		name = "/"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !options.Deprecation.IsZero() {
+				w.Header().Set("Deprecation", "@"+strconv.FormatInt(options.Deprecation.Unix(), 10))
+				if len(options.Link) > 0 {
+					w.Header().Add("Link", "<"+options.Link+`>; rel="deprecation"; type="text/html"`)
+				}
+			}
+			if !options.Sunset.IsZero() {
+				w.Header().Set("Sunset", options.Sunset.UTC().Format(http.TimeFormat))
+			}
+			api.server.metrics.Inc("wess_api_version_requests_total", "api", name, "version", version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// defaultVersion gives the version of the requests that do not give one
+//
+// returns an empty string if the API has no version
+func (api *VersionedAPI) defaultVersion() string {
+	if len(api.options.Default) > 0 {
+		return api.options.Default
+	}
+	api.mutex.RLock()
+	defer api.mutex.RUnlock()
+	if len(api.versions) == 0 {
+		return ""
+	}
+	return api.versions[0]
+}
+
+// lookup finds the version of the API that matches the given value, with or without its "v" prefix
+func (api *VersionedAPI) lookup(value string) (string, bool) {
+	value = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "v")
+	api.mutex.RLock()
+	defer api.mutex.RUnlock()
+	for _, version := range api.versions {
+		if strings.TrimPrefix(strings.ToLower(version), "v") == value {
+			return version, true
+		}
+	}
+	return "", false
+}
+
+// matches tells if the path belongs to the API
+func (api *VersionedAPI) matches(path string) bool {
+	return len(api.options.Prefix) == 0 || path == api.options.Prefix || strings.HasPrefix(path, api.options.Prefix+"/")
+}
+
+// resolve resolves the version requested by the request
+//
+// returns the request to route, nil if a Problem was written
+func (api *VersionedAPI) resolve(w http.ResponseWriter, r *http.Request) *http.Request {
+	if len(api.defaultVersion()) > 0 {
+		switch api.options.Strategy {
+		case VersionByHeader:
+			addVary(w.Header(), api.options.Header)
+		case VersionByMediaType:
+			addVary(w.Header(), "Accept")
+		}
+	}
+	routed, problem := api.route(r)
+	if problem != nil {
+		WriteProblem(w, r, *problem)
+		return nil
+	}
+	return routed
+}
+
+// route gives the request to route, with the version it requested in its context
+//
+// returns a Problem if the requested version is unknown
+func (api *VersionedAPI) route(r *http.Request) (*http.Request, *Problem) {
+	version := api.defaultVersion()
+	if len(version) == 0 {
+		return r, nil
+	}
+	switch api.options.Strategy {
+	case VersionByPath:
+		rest := strings.TrimPrefix(r.URL.Path, api.options.Prefix)
+		segment, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+		if found, ok := api.lookup(segment); ok && segment == found {
+			version = found
+		} else {
+			url := *r.URL
+			url.Path = api.options.Prefix + "/" + version + rest
+			url.RawPath = ""
+			r = r.Clone(r.Context())
+			r.URL = &url
+		}
+	case VersionByHeader:
+		if value := r.Header.Get(api.options.Header); len(value) > 0 {
+			found, ok := api.lookup(value)
+			if !ok {
+				problem := NewProblem(http.StatusBadRequest, "Unsupported API version: "+value).With("versions", api.Versions())
+				return nil, &problem
+			}
+			version = found
+		}
+	case VersionByMediaType:
+		value, accept, found := api.vendorVersion(r.Header.Get("Accept"))
+		if found {
+			found, ok := api.lookup(value)
+			if !ok {
+				problem := NewProblem(http.StatusNotAcceptable, "Unsupported API version: "+value).With("versions", api.Versions())
+				return nil, &problem
+			}
+			version = found
+			r = r.Clone(r.Context())
+			r.Header.Set("Accept", accept)
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), apiVersionContextKey{}, apiVersion{api: api, version: version})), nil
+}
+
+// vendorVersion finds the version in the vendor media types of an Accept header
+//
+// The vendor media types are replaced with their structured syntax suffix in the returned Accept header,
+// like "application/vnd.acme.v2+json" with "application/json".
+func (api *VersionedAPI) vendorVersion(accept string) (version string, replaced string, found bool) {
+	if len(api.options.Vendor) == 0 || len(accept) == 0 {
+		return "", accept, false
+	}
+	vendor := "application/vnd." + strings.ToLower(api.options.Vendor)
+	parts := strings.Split(accept, ",")
+	for index, part := range parts {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || (mediaType != vendor && !strings.HasPrefix(mediaType, vendor+".") && !strings.HasPrefix(mediaType, vendor+"+")) {
+			continue
+		}
+		name, suffix, _ := strings.Cut(strings.TrimPrefix(mediaType, vendor), "+")
+		if len(suffix) == 0 {
+			suffix = "json"
+		}
+		value := strings.TrimPrefix(name, ".")
+		if param, ok := params["version"]; ok {
+			value = param
+		}
+		if !found && len(value) > 0 {
+			version, found = value, true
+		}
+		parts[index] = "application/" + suffix
+		if quality, ok := params["q"]; ok {
+			parts[index] += ";q=" + quality
+		}
+	}
+	return version, strings.Join(parts, ","), found
+}
+
+// versionRegistry contains the versioned APIs of a server
+type versionRegistry struct {
+	mutex sync.RWMutex
+	apis  []*VersionedAPI
+}
+
+// add adds a versioned API
+func (registry *versionRegistry) add(api *VersionedAPI) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.apis = append(registry.apis, api)
+}
+
+// find finds the versioned API with the longest prefix that matches the path
+//
+// returns nil if no API matches
+func (registry *versionRegistry) find(path string) *VersionedAPI {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	var found *VersionedAPI
+	for _, api := range registry.apis {
+		if api.matches(path) && (found == nil || len(api.options.Prefix) > len(found.options.Prefix)) {
+			found = api
+		}
+	}
+	return found
+}
+
+// route gives the request as it is routed, with its API version resolved
+//
+// returns the request as is if it is not for a versioned API or its version is unknown
+func (registry *versionRegistry) route(r *http.Request) *http.Request {
+	if api := registry.find(r.URL.Path); api != nil {
+		if routed, problem := api.route(r); problem == nil {
+			return routed
+		}
+	}
+	return r
+}
+
+// middleware resolves the API version of the requests before they are routed
+func (registry *versionRegistry) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api := registry.find(r.URL.Path); api != nil {
+			if r = api.resolve(w, r); r == nil {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package wess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (suite *ServerSuite) TestCanVersionAPIsByPath() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	calls := 0
+	shared := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(GetAPIVersion(r) + " " + r.URL.Path))
+	}
+	deprecation := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	api := server.Versioned(VersioningOptions{Prefix: "/api/"}, shared)
+	api.Version("v1", VersionOptions{Deprecation: deprecation, Sunset: sunset, Link: "https://docs.acme.com/v2"}).Get("/users", handler)
+	api.Version("v2", VersionOptions{}).Get("/users", handler)
+	suite.Assert().Equal([]string{"v1", "v2"}, api.Versions())
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		return res
+	}
+
+	res := serve("/api/v2/users")
+	suite.Assert().Equal("v2 /api/v2/users", res.Body.String())
+	suite.Assert().Empty(res.Header().Get("Deprecation"))
+
+	res = serve("/api/v1/users")
+	suite.Assert().Equal("v1 /api/v1/users", res.Body.String())
+	suite.Assert().Equal("@1767225600", res.Header().Get("Deprecation"))
+	suite.Assert().Equal("Fri, 01 Jan 2027 00:00:00 GMT", res.Header().Get("Sunset"))
+	suite.Assert().Equal(`<https://docs.acme.com/v2>; rel="deprecation"; type="text/html"`, res.Header().Get("Link"))
+
+	res = serve("/api/users")
+	suite.Assert().Equal("v1 /api/v1/users", res.Body.String(), "Requests without a version should be served by the default version")
+
+	res = serve("/api/v3/users")
+	suite.Assert().Equal(http.StatusNotFound, res.Code)
+
+	suite.Assert().Equal(3, calls, "The middlewares of the API should be executed for all the versions")
+	suite.Assert().Equal(float64(2), server.Metrics().Get("wess_api_version_requests_total", "api", "/api", "version", "v1"))
+	suite.Assert().Equal(float64(1), server.Metrics().Get("wess_api_version_requests_total", "api", "/api", "version", "v2"))
+}
+
+func (suite *ServerSuite) TestCanVersionAPIsByHeader() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	api := server.Versioned(VersioningOptions{Prefix: "/api", Strategy: VersionByHeader, Default: "v2"})
+	for _, version := range []string{"v1", "v2"} {
+		api.Version(version, VersionOptions{}).Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(version))
+		})
+	}
+
+	serve := func(version string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		if len(version) > 0 {
+			req.Header.Set("Accept-Version", version)
+		}
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve("v1")
+	suite.Assert().Equal("v1", res.Body.String())
+	suite.Assert().Equal("Accept-Version", res.Header().Get("Vary"))
+	suite.Assert().Equal("v1", serve("1").Body.String(), "The \"v\" prefix should be optional")
+	suite.Assert().Equal("v2", serve("").Body.String(), "Requests without a version should be served by the default version")
+
+	res = serve("v9")
+	suite.Assert().Equal(http.StatusBadRequest, res.Code)
+	suite.Assert().Contains(res.Body.String(), `"versions":["v1","v2"]`)
+}
+
+func (suite *ServerSuite) TestCanVersionAPIsByMediaType() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	api := server.Versioned(VersioningOptions{Prefix: "/api", Strategy: VersionByMediaType, Vendor: "acme"})
+	for _, version := range []string{"v1", "v2"} {
+		api.Version(version, VersionOptions{}).Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			Render(w, r, http.StatusOK, map[string]string{"version": GetAPIVersion(r)})
+		})
+	}
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.Header.Set("Accept", accept)
+		res := httptest.NewRecorder()
+		server.webserver.Handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve("application/vnd.acme.v2+json")
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("application/json", res.Header().Get("Content-Type"))
+	suite.Assert().JSONEq(`{"version":"v2"}`, res.Body.String())
+
+	res = serve("application/vnd.acme+yaml; version=2")
+	suite.Require().Equal(http.StatusOK, res.Code)
+	suite.Assert().Equal("application/yaml", res.Header().Get("Content-Type"))
+	suite.Assert().Equal("version: v2\n", res.Body.String())
+
+	res = serve("application/json")
+	suite.Assert().JSONEq(`{"version":"v1"}`, res.Body.String(), "Requests without a version should be served by the default version")
+
+	res = serve("application/vnd.acme.v3+json")
+	suite.Assert().Equal(http.StatusNotAcceptable, res.Code)
+}
@@ -0,0 +1,633 @@
+package wess
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gildas/go-errors"
+	"github.com/gildas/go-logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// TextMessage denotes a text data message (UTF-8 encoded)
+	TextMessage = websocket.TextMessage
+
+	// BinaryMessage denotes a binary data message
+	BinaryMessage = websocket.BinaryMessage
+)
+
+// WebSocketOptions defines the options of a WebSocket route (See Server.AddWebSocket)
+type WebSocketOptions struct {
+	// AllowedOrigins is the list of origins allowed to open a connection, "*" allows all origins.
+	//
+	// If empty, the allowed origins of the CORS policy of the route are used,
+	// and if CORS is not enabled, only the server's own origin is allowed.
+	// Requests without an Origin header (non-browser clients) are always allowed.
+	AllowedOrigins []string
+
+	// CheckOrigin is a custom function to validate the origin, it replaces the other origin checks
+	CheckOrigin func(r *http.Request) bool
+
+	// Subprotocols is the list of supported subprotocols, in order of preference
+	Subprotocols []string
+
+	// PingInterval is the interval between two pings sent to the client.
+	// Default: 30 seconds
+	PingInterval time.Duration
+
+	// PongTimeout is the maximum amount of time to wait for a message or a pong from the client.
+	// Default: 60 seconds
+	PongTimeout time.Duration
+
+	// WriteTimeout is the maximum amount of time to write a message to the client.
+	// Default: 10 seconds
+	WriteTimeout time.Duration
+
+	// MaxMessageSize is the maximum size in bytes of the messages sent by the client, 0 means no limit
+	MaxMessageSize int64
+
+	// SendBuffer is the number of messages that can be queued for a connection.
+	// A connection that is too slow to empty its queue is closed.
+	// Default: 64
+	SendBuffer int
+
+	// ReceiveBuffer is the number of messages of the client that can wait to be read by the handler.
+	// When the queue is full, the new messages are dropped, so the pongs are still processed.
+	// Default: 64
+	ReceiveBuffer int
+
+	// EnableCompression negotiates the per-message compression with the client
+	EnableCompression bool
+}
+
+// WebSocketHandler handles a WebSocket connection
+//
+// The connection is closed when the handler returns.
+type WebSocketHandler func(conn *WebSocketConn)
+
+// WebSocketHub tracks the connections of a WebSocket route and broadcasts messages to them
+type WebSocketHub struct {
+	path    string
+	options WebSocketOptions
+	metrics *Metrics
+	mutex   sync.RWMutex
+	conns   map[*WebSocketConn]bool
+	rooms   map[string]map[*WebSocketConn]bool
+}
+
+// WebSocketConn is a WebSocket connection of a WebSocketHub
+//
+// The messages of the client are read in the background, so a handler that only sends messages
+// can wait on Done. The messages that do not fit in the receive queue are dropped (See WebSocketOptions.ReceiveBuffer).
+//
+// Send, SendJSON, Join, Leave and Close are safe for concurrent use,
+// Read and ReadJSON must be called from one goroutine at a time.
+type WebSocketConn struct {
+	// ID is the unique identifier of the connection
+	ID string
+
+	hub       *WebSocketHub
+	conn      *websocket.Conn
+	request   *http.Request
+	logger    *logger.Logger
+	context   context.Context
+	cancel    context.CancelFunc
+	send      chan webSocketMessage
+	received  chan webSocketMessage
+	readErr   error
+	readDone  chan struct{}
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+}
+
+// webSocketMessage is a message queued for a connection
+type webSocketMessage struct {
+	messageType int
+	data        []byte
+}
+
+// webSocketRegistry is the list of the WebSocket hubs of a server
+type webSocketRegistry struct {
+	mutex sync.Mutex
+	hubs  []*WebSocketHub
+}
+
+// AddWebSocket adds a WebSocket route to the server
+//
+// The handler is called with each connection once upgraded, the returned hub broadcasts messages to the connections.
+// The open connections receive a close frame when the server shuts down.
+func (server Server) AddWebSocket(path string, handler WebSocketHandler, options WebSocketOptions, routeOptions ...RouteOption) *WebSocketHub {
+	if options.PingInterval <= 0 {
+		options.PingInterval = 30 * time.Second
+	}
+	if options.PongTimeout <= 0 {
+		options.PongTimeout = 60 * time.Second
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = 10 * time.Second
+	}
+	if options.SendBuffer <= 0 {
+		options.SendBuffer = 64
+	}
+	if options.ReceiveBuffer <= 0 {
+		options.ReceiveBuffer = 64
+	}
+	hub := &WebSocketHub{
+		path:    path,
+		options: options,
+		metrics: server.metrics,
+		conns:   map[*WebSocketConn]bool{},
+		rooms:   map[string]map[*WebSocketConn]bool{},
+	}
+	hub.metrics.Describe("wess_websocket_connections", GaugeMetric, "Number of open WebSocket connections, by path")
+	hub.metrics.Describe("wess_websocket_messages_total", CounterMetric, "Number of WebSocket messages, by path and direction (in or out)")
+	hub.metrics.Describe("wess_websocket_dropped_messages_total", CounterMetric, "Number of WebSocket messages of the clients dropped because the handler did not read them, by path")
+	hub.metrics.Set("wess_websocket_connections", 0, "path", path)
+	server.websockets.add(hub)
+
+	upgrader := websocket.Upgrader{
+		Subprotocols:      options.Subprotocols,
+		EnableCompression: options.EnableCompression,
+		CheckOrigin:       server.checkWebSocketOrigin(options),
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			WriteProblem(w, r, NewProblem(status, reason.Error()))
+		},
+	}
+	webhandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(hijackWriter{w}, r, nil)
+		if err != nil {
+			return // the upgrader has already answered
+		}
+		hub.serve(ws, r, handler)
+	})
+	config := newRouteConfig(routeOptions...)
+	server.register(server.webrouter.Methods(http.MethodGet).Path(path).Handler(config.wrap(webhandler)), config)
+	return hub
+}
+
+// hijackWriter gives access to the http.Hijacker of the wrapped writers (sessions, timeouts, etc)
+//
+// gorilla/websocket looks for the http.Hijacker without following Unwrap.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+// Hijack lets the caller take over the connection
+//
+// implements http.Hijacker
+func (writer hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(writer.ResponseWriter).Hijack()
+}
+
+// Unwrap gives the original http.ResponseWriter
+//
+// This is used by http.ResponseController
+func (writer hijackWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// checkWebSocketOrigin gives the origin check of a WebSocket route
+func (server Server) checkWebSocketOrigin(options WebSocketOptions) func(r *http.Request) bool {
+	if options.CheckOrigin != nil {
+		return options.CheckOrigin
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			return true
+		}
+		if len(options.AllowedOrigins) > 0 {
+			for _, allowed := range options.AllowedOrigins {
+				if allowed == "*" || matchOrigin(allowed, origin) {
+					return true
+				}
+			}
+			return false
+		}
+		if policy := server.cors.policyFor(r); policy != nil {
+			return policy.allowsOrigin(origin)
+		}
+		info := GetClientInfo(r)
+		return strings.EqualFold(origin, info.Scheme+"://"+info.Host)
+	}
+}
+
+// Path gives the path of the WebSocket route
+func (hub *WebSocketHub) Path() string {
+	return hub.path
+}
+
+// Count gives the number of open connections
+func (hub *WebSocketHub) Count() int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.conns)
+}
+
+// Rooms gives the rooms that have connections, sorted
+func (hub *WebSocketHub) Rooms() []string {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	rooms := make([]string, 0, len(hub.rooms))
+	for room := range hub.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Broadcast sends a message to all the connections
+//
+// returns the number of connections the message was queued for
+func (hub *WebSocketHub) Broadcast(messageType int, data []byte) int {
+	return hub.broadcast(hub.connections(), messageType, data)
+}
+
+// BroadcastTo sends a message to the connections that joined the given room
+//
+// returns the number of connections the message was queued for
+func (hub *WebSocketHub) BroadcastTo(room string, messageType int, data []byte) int {
+	hub.mutex.RLock()
+	conns := make([]*WebSocketConn, 0, len(hub.rooms[room]))
+	for conn := range hub.rooms[room] {
+		conns = append(conns, conn)
+	}
+	hub.mutex.RUnlock()
+	return hub.broadcast(conns, messageType, data)
+}
+
+// BroadcastJSON sends a value as a JSON text message to all the connections
+func (hub *WebSocketHub) BroadcastJSON(value any) (int, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return 0, errors.JSONMarshalError.Wrap(err)
+	}
+	return hub.Broadcast(TextMessage, payload), nil
+}
+
+// BroadcastJSONTo sends a value as a JSON text message to the connections that joined the given room
+func (hub *WebSocketHub) BroadcastJSONTo(room string, value any) (int, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return 0, errors.JSONMarshalError.Wrap(err)
+	}
+	return hub.BroadcastTo(room, TextMessage, payload), nil
+}
+
+// broadcast queues a message for the given connections
+func (hub *WebSocketHub) broadcast(conns []*WebSocketConn, messageType int, data []byte) (count int) {
+	for _, conn := range conns {
+		if conn.Send(messageType, data) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// serve runs a connection until it is closed
+func (hub *WebSocketHub) serve(ws *websocket.Conn, r *http.Request, handler WebSocketHandler) {
+	id := uuid.NewString()
+	conn := &WebSocketConn{
+		ID:       id,
+		hub:      hub,
+		conn:     ws,
+		request:  r,
+		logger:   logger.Must(logger.FromContext(r.Context(), nilLogger)).Child("websocket", "websocket", "path", hub.path, "connection", id),
+		send:     make(chan webSocketMessage, hub.options.SendBuffer),
+		received: make(chan webSocketMessage, hub.options.ReceiveBuffer),
+		readDone: make(chan struct{}),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	// The request context can end before the connection (See TimeoutMiddleware)
+	conn.context, conn.cancel = context.WithCancel(context.WithoutCancel(r.Context()))
+	if hub.options.MaxMessageSize > 0 {
+		ws.SetReadLimit(hub.options.MaxMessageSize)
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(hub.options.PongTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(hub.options.PongTimeout))
+	})
+	hub.add(conn)
+	conn.logger.Infof("Connection opened (subprotocol: %q)", ws.Subprotocol())
+	go conn.writePump()
+	go conn.readPump()
+
+	defer func() {
+		if err := recover(); err != nil {
+			conn.logger.Errorf("Handler panicked", errors.RuntimeError.With("panic", err))
+			conn.Close(websocket.CloseInternalServerErr, "")
+		}
+		conn.Close(websocket.CloseNormalClosure, "")
+		// Wait for the client to acknowledge the close frame, or for the write pump to give up
+		<-conn.readDone
+		hub.remove(conn)
+		_ = ws.Close()
+		close(conn.done)
+		conn.logger.Infof("Connection closed")
+	}()
+	handler(conn)
+}
+
+// add adds a connection to the hub
+func (hub *WebSocketHub) add(conn *WebSocketConn) {
+	hub.mutex.Lock()
+	hub.conns[conn] = true
+	count := len(hub.conns)
+	hub.mutex.Unlock()
+	hub.metrics.Set("wess_websocket_connections", float64(count), "path", hub.path)
+}
+
+// remove removes a connection and its rooms from the hub
+func (hub *WebSocketHub) remove(conn *WebSocketConn) {
+	hub.mutex.Lock()
+	delete(hub.conns, conn)
+	for room, members := range hub.rooms {
+		delete(members, conn)
+		if len(members) == 0 {
+			delete(hub.rooms, room)
+		}
+	}
+	count := len(hub.conns)
+	hub.mutex.Unlock()
+	hub.metrics.Set("wess_websocket_connections", float64(count), "path", hub.path)
+}
+
+// connections gives the open connections of the hub
+func (hub *WebSocketHub) connections() []*WebSocketConn {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	conns := make([]*WebSocketConn, 0, len(hub.conns))
+	for conn := range hub.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Request gives the HTTP request that opened the connection
+func (conn *WebSocketConn) Request() *http.Request {
+	return conn.request
+}
+
+// Context gives the context of the connection
+//
+// It carries the values of the HTTP request that opened the connection and is canceled when the connection starts closing.
+func (conn *WebSocketConn) Context() context.Context {
+	return conn.context
+}
+
+// Logger gives the logger of the connection
+func (conn *WebSocketConn) Logger() *logger.Logger {
+	return conn.logger
+}
+
+// Subprotocol gives the subprotocol negotiated with the client
+func (conn *WebSocketConn) Subprotocol() string {
+	return conn.conn.Subprotocol()
+}
+
+// Read reads the next message sent by the client
+//
+// returns an error when the connection is closed
+func (conn *WebSocketConn) Read() (messageType int, data []byte, err error) {
+	message, ok := <-conn.received
+	if !ok {
+		return 0, nil, conn.readErr
+	}
+	return message.messageType, message.data, nil
+}
+
+// ReadJSON reads the next message sent by the client and unmarshals it into the given value
+func (conn *WebSocketConn) ReadJSON(value any) error {
+	_, data, err := conn.Read()
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return errors.JSONUnmarshalError.Wrap(err)
+	}
+	return nil
+}
+
+// Send queues a message for the client
+//
+// If the queue of the connection is full, the connection is closed.
+func (conn *WebSocketConn) Send(messageType int, data []byte) error {
+	select {
+	case <-conn.closing:
+		return errors.NotConnected.With("websocket")
+	default:
+	}
+	select {
+	case conn.send <- webSocketMessage{messageType, data}:
+		return nil
+	default:
+		conn.logger.Warnf("The send queue is full, closing the connection")
+		conn.Close(websocket.CloseTryAgainLater, "too slow")
+		return errors.NotConnected.With("websocket")
+	}
+}
+
+// SendJSON queues a value as a JSON text message for the client
+func (conn *WebSocketConn) SendJSON(value any) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return errors.JSONMarshalError.Wrap(err)
+	}
+	return conn.Send(TextMessage, payload)
+}
+
+// Join adds the connection to a room of its hub (See WebSocketHub.BroadcastTo)
+func (conn *WebSocketConn) Join(room string) {
+	conn.hub.mutex.Lock()
+	defer conn.hub.mutex.Unlock()
+	if !conn.hub.conns[conn] {
+		return
+	}
+	members, found := conn.hub.rooms[room]
+	if !found {
+		members = map[*WebSocketConn]bool{}
+		conn.hub.rooms[room] = members
+	}
+	members[conn] = true
+}
+
+// Leave removes the connection from a room of its hub
+func (conn *WebSocketConn) Leave(room string) {
+	conn.hub.mutex.Lock()
+	defer conn.hub.mutex.Unlock()
+	if members, found := conn.hub.rooms[room]; found {
+		delete(members, conn)
+		if len(members) == 0 {
+			delete(conn.hub.rooms, room)
+		}
+	}
+}
+
+// Rooms gives the rooms the connection joined, sorted
+func (conn *WebSocketConn) Rooms() []string {
+	conn.hub.mutex.RLock()
+	defer conn.hub.mutex.RUnlock()
+	rooms := []string{}
+	for room, members := range conn.hub.rooms {
+		if members[conn] {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Close sends a close frame with the given code and reason to the client
+//
+// The queued messages are sent first, the pending Read returns once the client acknowledges the close frame.
+// Only the first call has an effect.
+func (conn *WebSocketConn) Close(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeCode = code
+		conn.closeText = reason
+		close(conn.closing)
+		conn.cancel()
+	})
+}
+
+// Done is closed when the connection starts closing:
+// the client closed it or went away, the server is shutting down, or Close was called
+func (conn *WebSocketConn) Done() <-chan struct{} {
+	return conn.closing
+}
+
+// readPump reads the messages of the client until the connection is closed
+//
+// It never waits for the handler, so the pongs are always processed:
+// the messages that do not fit in the receive queue, or that are read after the connection started closing, are dropped.
+func (conn *WebSocketConn) readPump() {
+	defer close(conn.readDone)
+	for {
+		messageType, data, err := conn.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				conn.logger.Debugf("Failed to read a message: %s", err)
+			}
+			conn.readErr = err
+			close(conn.received)
+			// The close frame of the client was already answered
+			conn.Close(websocket.CloseAbnormalClosure, "")
+			return
+		}
+		conn.hub.metrics.Inc("wess_websocket_messages_total", "path", conn.hub.path, "direction", "in")
+		_ = conn.conn.SetReadDeadline(time.Now().Add(conn.hub.options.PongTimeout))
+		select {
+		case <-conn.closing:
+			continue
+		default:
+		}
+		select {
+		case conn.received <- webSocketMessage{messageType, data}:
+		default:
+			conn.logger.Warnf("The receive queue is full, dropping a message")
+			conn.hub.metrics.Inc("wess_websocket_dropped_messages_total", "path", conn.hub.path)
+		}
+	}
+}
+
+// writePump writes the queued messages and the pings to the client
+func (conn *WebSocketConn) writePump() {
+	ticker := time.NewTicker(conn.hub.options.PingInterval)
+	defer ticker.Stop()
+
+	write := func(message webSocketMessage) bool {
+		_ = conn.conn.SetWriteDeadline(time.Now().Add(conn.hub.options.WriteTimeout))
+		if err := conn.conn.WriteMessage(message.messageType, message.data); err != nil {
+			conn.logger.Debugf("Failed to write a message: %s", err)
+			return false
+		}
+		conn.hub.metrics.Inc("wess_websocket_messages_total", "path", conn.hub.path, "direction", "out")
+		return true
+	}
+	for {
+		select {
+		case message := <-conn.send:
+			if !write(message) {
+				conn.Close(websocket.CloseAbnormalClosure, "")
+				_ = conn.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.hub.options.WriteTimeout)); err != nil {
+				conn.logger.Debugf("Failed to ping the client: %s", err)
+				conn.Close(websocket.CloseAbnormalClosure, "")
+				_ = conn.conn.Close()
+				return
+			}
+		case <-conn.done:
+			return
+		case <-conn.closing:
+			for pending := len(conn.send); pending > 0; pending-- {
+				if !write(<-conn.send) {
+					break
+				}
+			}
+			deadline := time.Now().Add(conn.hub.options.WriteTimeout)
+			if conn.closeCode != websocket.CloseAbnormalClosure {
+				_ = conn.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(conn.closeCode, conn.closeText), deadline)
+			}
+			select {
+			case <-conn.readDone:
+			case <-time.After(time.Until(deadline)):
+				// the client did not acknowledge the close frame
+				_ = conn.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// add adds a hub to the registry
+func (registry *webSocketRegistry) add(hub *WebSocketHub) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.hubs = append(registry.hubs, hub)
+}
+
+// shutdown sends a close frame to all the open connections and waits for them to be closed
+//
+// The connections that are still open when the context is done are closed abruptly.
+func (registry *webSocketRegistry) shutdown(ctx context.Context, log *logger.Logger) {
+	registry.mutex.Lock()
+	hubs := append([]*WebSocketHub{}, registry.hubs...)
+	registry.mutex.Unlock()
+
+	conns := []*WebSocketConn{}
+	for _, hub := range hubs {
+		conns = append(conns, hub.connections()...)
+	}
+	if len(conns) == 0 {
+		return
+	}
+	log.Debugf("Closing %d WebSocket connections", len(conns))
+	for _, conn := range conns {
+		conn.Close(websocket.CloseGoingAway, "server shutting down")
+	}
+	for _, conn := range conns {
+		select {
+		case <-conn.done:
+		case <-ctx.Done():
+			log.Warnf("WebSocket connection %s did not close in time", conn.ID)
+			_ = conn.conn.Close()
+		}
+	}
+	log.Infof("WebSocket connections closed")
+}
@@ -0,0 +1,211 @@
+package wess
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func (suite *ServerSuite) TestCanServeWebSockets() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	hub := server.AddWebSocket("/live", func(conn *WebSocketConn) {
+		conn.Join(conn.Request().URL.Query().Get("room"))
+		for {
+			messageType, data, err := conn.Read()
+			if err != nil {
+				return
+			}
+			_ = conn.Send(messageType, append([]byte("echo: "), data...))
+		}
+	}, WebSocketOptions{})
+	front := httptest.NewServer(server.webserver.Handler)
+	defer front.Close()
+
+	dial := func(room string) *websocket.Conn {
+		conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http")+"/live?room="+room, nil)
+		suite.Require().NoError(err)
+		suite.Require().Equal(http.StatusSwitchingProtocols, res.StatusCode)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	read := func(conn *websocket.Conn) string {
+		_, data, err := conn.ReadMessage()
+		suite.Require().NoError(err)
+		return string(data)
+	}
+	first, second := dial("blue"), dial("red")
+	defer first.Close()
+	defer second.Close()
+
+	suite.Require().NoError(first.WriteMessage(websocket.TextMessage, []byte("hello")))
+	suite.Assert().Equal("echo: hello", read(first))
+	suite.Assert().Equal(2, hub.Count())
+	suite.Assert().Equal([]string{"blue", "red"}, hub.Rooms())
+
+	suite.Assert().Equal(1, hub.BroadcastTo("red", TextMessage, []byte("red only")))
+	suite.Assert().Equal("red only", read(second))
+	count, err := hub.BroadcastJSON(map[string]string{"event": "refresh"})
+	suite.Require().NoError(err)
+	suite.Assert().Equal(2, count)
+	suite.Assert().JSONEq(`{"event":"refresh"}`, read(first))
+	suite.Assert().JSONEq(`{"event":"refresh"}`, read(second))
+
+	suite.Require().NoError(second.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	suite.Assert().Eventually(func() bool { return hub.Count() == 1 }, time.Second, 10*time.Millisecond)
+	suite.Assert().Equal([]string{"blue"}, hub.Rooms(), "The rooms should be left when the connection is closed")
+	suite.Assert().Equal(float64(1), server.Metrics().Get("wess_websocket_connections", "path", "/live"))
+	suite.Assert().Equal(float64(1), server.Metrics().Get("wess_websocket_messages_total", "path", "/live", "direction", "in"))
+}
+
+func (suite *ServerSuite) TestShouldCheckWebSocketOrigins() {
+	server := NewServer(ServerOptions{Logger: suite.Logger, AllowedCORSOrigins: []string{"https://*.acme.com"}})
+	server.AddWebSocket("/live", func(conn *WebSocketConn) {}, WebSocketOptions{})
+	server.AddWebSocket("/admin", func(conn *WebSocketConn) {}, WebSocketOptions{AllowedOrigins: []string{"https://admin.acme.com"}})
+	front := httptest.NewServer(server.webserver.Handler)
+	defer front.Close()
+
+	dial := func(path, origin string) int {
+		header := http.Header{}
+		if len(origin) > 0 {
+			header.Set("Origin", origin)
+		}
+		conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http")+path, header)
+		if err == nil {
+			_ = conn.Close()
+		}
+		suite.Require().NotNil(res)
+		return res.StatusCode
+	}
+	suite.Assert().Equal(http.StatusSwitchingProtocols, dial("/live", "https://www.acme.com"), "The CORS allowed origins should be allowed")
+	suite.Assert().Equal(http.StatusSwitchingProtocols, dial("/live", ""), "Requests without an Origin should be allowed")
+	suite.Assert().Equal(http.StatusForbidden, dial("/live", "https://www.evil.com"))
+	suite.Assert().Equal(http.StatusSwitchingProtocols, dial("/admin", "https://admin.acme.com"))
+	suite.Assert().Equal(http.StatusForbidden, dial("/admin", "https://www.acme.com"), "The route allowed origins should replace the CORS allowed origins")
+}
+
+func (suite *ServerSuite) TestShouldCloseWebSocketsOnShutdown() {
+	server := NewServer(ServerOptions{Port: 9897, Logger: suite.Logger})
+	hub := server.AddWebSocket("/live", func(conn *WebSocketConn) {
+		for {
+			if _, _, err := conn.Read(); err != nil {
+				return
+			}
+		}
+	}, WebSocketOptions{})
+	shutdown, stop, err := server.Start(context.Background())
+	suite.Require().NoError(err, "Failed starting the server")
+
+	time.Sleep(100 * time.Millisecond)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9897/live", nil)
+	suite.Require().NoError(err)
+	defer conn.Close()
+	suite.Assert().Eventually(func() bool { return hub.Count() == 1 }, time.Second, 10*time.Millisecond)
+
+	stop <- os.Interrupt
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	suite.Assert().True(websocket.IsCloseError(err, websocket.CloseGoingAway), "The client should receive a close frame, got %v", err)
+
+	err = <-shutdown
+	suite.Require().NoError(err, "Failed shutting down the server")
+	suite.Assert().Equal(0, hub.Count(), "The connections should be closed when the server is stopped")
+}
+
+func (suite *ServerSuite) TestCanServeWebSocketsWithSessionsAndTimeouts() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	sessions := WithSessions(SessionOptions{Store: NewMemorySessionStore()})
+	server.AddRouteWithFunc(http.MethodGet, "/login", func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Set("user", "john")
+	}, sessions)
+	closed := make(chan struct{})
+	hub := server.AddWebSocket("/live", func(conn *WebSocketConn) {
+		user, _ := SessionValue[string](conn.Request(), "user")
+		_ = conn.Send(TextMessage, []byte("hello "+user))
+		<-conn.Done()
+		suite.Assert().Error(conn.Context().Err(), "The context should be canceled when the connection closes")
+		close(closed)
+	}, WebSocketOptions{}, sessions, WithTimeout(50*time.Millisecond))
+	front := httptest.NewServer(server.webserver.Handler)
+	defer front.Close()
+
+	res, err := http.Get(front.URL + "/login")
+	suite.Require().NoError(err)
+	_ = res.Body.Close()
+	suite.Require().NotEmpty(res.Cookies())
+	header := http.Header{}
+	header.Set("Cookie", res.Cookies()[0].String())
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http")+"/live", header)
+	suite.Require().NoError(err, "The upgrade should go through the session and timeout writers")
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	suite.Require().NoError(err)
+	suite.Assert().Equal("hello john", string(data))
+
+	time.Sleep(100 * time.Millisecond)
+	suite.Assert().Equal(1, hub.Broadcast(TextMessage, []byte("still there")), "The route timeout should not apply to the connection")
+	_, data, err = conn.ReadMessage()
+	suite.Require().NoError(err)
+	suite.Assert().Equal("still there", string(data))
+
+	stopped := make(chan struct{})
+	go func() {
+		server.websockets.shutdown(context.Background(), suite.Logger)
+		close(stopped)
+	}()
+	_, _, err = conn.ReadMessage()
+	suite.Assert().True(websocket.IsCloseError(err, websocket.CloseGoingAway), "The client should receive a close frame, got %v", err)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		suite.Fail("A handler waiting on Done should not stall the shutdown")
+	}
+	<-closed
+	suite.Assert().Equal(0, hub.Count())
+}
+
+func (suite *ServerSuite) TestShouldDetectDeadWebSocketClientsWithUnreadMessages() {
+	server := NewServer(ServerOptions{Logger: suite.Logger})
+	closed := make(chan struct{}, 2)
+	hub := server.AddWebSocket("/live", func(conn *WebSocketConn) {
+		<-conn.Done()
+		closed <- struct{}{}
+	}, WebSocketOptions{PingInterval: 50 * time.Millisecond, PongTimeout: 200 * time.Millisecond, ReceiveBuffer: 1})
+	front := httptest.NewServer(server.webserver.Handler)
+	defer front.Close()
+	url := "ws" + strings.TrimPrefix(front.URL, "http") + "/live"
+
+	alive, _, err := websocket.DefaultDialer.Dial(url, nil)
+	suite.Require().NoError(err)
+	defer alive.Close()
+	go func() {
+		for { // reading answers the pings of the server
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	dead, _, err := websocket.DefaultDialer.Dial(url, nil)
+	suite.Require().NoError(err)
+	defer dead.Close()
+	for _, conn := range []*websocket.Conn{alive, dead} {
+		for range 3 {
+			suite.Require().NoError(conn.WriteMessage(websocket.TextMessage, []byte("unread")))
+		}
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		suite.Fail("A client that does not answer the pings should be closed, even with unread messages")
+	}
+	suite.Assert().Eventually(func() bool { return hub.Count() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	suite.Assert().Equal(1, hub.Count(), "A client that answers the pings should stay connected")
+	suite.Assert().GreaterOrEqual(server.Metrics().Get("wess_websocket_dropped_messages_total", "path", "/live"), float64(2))
+}